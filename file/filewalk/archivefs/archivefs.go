@@ -0,0 +1,302 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package archivefs provides an implementation of filewalk.Filesystem that
+// presents the contents of a tar, tar.gz (or .tgz) or zip archive as a
+// hierarchy of prefixes and files. The names, sizes, modes, modification
+// times and, for tar archives, the user and group ids stored in the archive
+// are used to create the filewalk.Info for each entry. The archive's
+// header for each entry, *tar.Header or *zip.FileHeader, is available via
+// filewalk.Info.Sys.
+//
+// A composite mode is also supported whereby a walk of the local filesystem
+// will transparently descend into any archives that it encounters, with
+// each archive appearing as a prefix.
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+// Option represents an option accepted by New and NewComposite.
+type Option func(o *options)
+
+type options struct {
+	scanSize int
+	maxOpen  int
+}
+
+// ScanSize sets the number of entries to be sent in each Contents message
+// when listing the contents of an archive. The default is 1000.
+func ScanSize(n int) Option {
+	return func(o *options) {
+		o.scanSize = n
+	}
+}
+
+// MaxOpenArchives sets the maximum number of archives whose index will be
+// retained in memory by a composite filesystem. The default is 16.
+func MaxOpenArchives(n int) Option {
+	return func(o *options) {
+		o.maxOpen = n
+	}
+}
+
+func defaultOptions(opts []Option) options {
+	o := options{scanSize: 1000, maxOpen: 16}
+	for _, fn := range opts {
+		fn(&o)
+	}
+	return o
+}
+
+type format int
+
+const (
+	unsupported format = iota
+	tarFormat
+	tarGzipFormat
+	zipFormat
+)
+
+func archiveFormat(name string) format {
+	switch name = strings.ToLower(name); {
+	case strings.HasSuffix(name, ".tar"):
+		return tarFormat
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return tarGzipFormat
+	case strings.HasSuffix(name, ".zip"):
+		return zipFormat
+	}
+	return unsupported
+}
+
+// IsArchive returns true if name has one of the file extensions
+// supported by this package, ie. .tar, .tar.gz, .tgz or .zip.
+func IsArchive(name string) bool {
+	return archiveFormat(name) != unsupported
+}
+
+// FS represents the contents of a single archive. The root of the archive
+// is named "/" and all paths within it are / separated.
+type FS struct {
+	archive string
+	fs      *memfs.FS
+}
+
+// New reads the index of the specified archive and returns a Filesystem
+// that can be used to traverse it. The format of the archive is
+// determined by its file extension.
+func New(filename string, opts ...Option) (*FS, error) {
+	o := defaultOptions(opts)
+	var (
+		fs  *memfs.FS
+		err error
+	)
+	switch archiveFormat(filename) {
+	case tarFormat, tarGzipFormat:
+		fs, err = readTar(filename, o)
+	case zipFormat:
+		fs, err = readZip(filename, o)
+	default:
+		return nil, fmt.Errorf("%v: unsupported archive format", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	}
+	return &FS{archive: filename, fs: fs}, nil
+}
+
+func fileMode(m os.FileMode) filewalk.FileMode {
+	return filewalk.FileMode(m&os.ModePerm | m&os.ModeSymlink | m&os.ModeDir)
+}
+
+func readTar(filename string, o options) (*memfs.FS, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rd io.Reader = f
+	if archiveFormat(filename) == tarGzipFormat {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	fs := memfs.New(memfs.ListBatchSize(o.scanSize))
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		info := filewalk.NewInfo("", hdr.Size, fileMode(hdr.FileInfo().Mode()), hdr.ModTime, hdr)
+		info.UserID = strconv.Itoa(hdr.Uid)
+		info.GroupID = strconv.Itoa(hdr.Gid)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = fs.AddPrefix(hdr.Name, info)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			err = fs.AddFile(hdr.Name, info)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readZip(filename string, o options) (*memfs.FS, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	fs := memfs.New(memfs.ListBatchSize(o.scanSize))
+	for _, f := range zr.File {
+		hdr := f.FileHeader
+		fi := hdr.FileInfo()
+		info := filewalk.NewInfo("", fi.Size(), fileMode(fi.Mode()), hdr.Modified, &hdr)
+		if fi.IsDir() {
+			err = fs.AddPrefix(hdr.Name, info)
+		} else {
+			err = fs.AddFile(hdr.Name, info)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// Archive returns the filename of the archive.
+func (a *FS) Archive() string {
+	return a.archive
+}
+
+// Stat implements filewalk.Filesystem.
+func (a *FS) Stat(ctx context.Context, p string) (filewalk.Info, error) {
+	return a.fs.Stat(ctx, p)
+}
+
+// List implements filewalk.Filesystem.
+func (a *FS) List(ctx context.Context, p string, ch chan<- filewalk.Contents) {
+	a.fs.List(ctx, p, ch)
+}
+
+// Open implements filewalk.Reader. Note that the archive is reopened for
+// every call to Open and that tar archives must be read sequentially up
+// to the requested entry.
+func (a *FS) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	info, err := a.fs.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsPrefix() {
+		return nil, fmt.Errorf("%v: is a prefix", p)
+	}
+	switch hdr := info.Sys().(type) {
+	case *tar.Header:
+		return openTar(a.archive, hdr.Name)
+	case *zip.FileHeader:
+		return openZip(a.archive, hdr.Name)
+	}
+	return nil, fmt.Errorf("%v: unsupported entry", p)
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc *readCloser) Close() error {
+	return rc.close()
+}
+
+func openTar(filename, name string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rd io.Reader = f
+	if archiveFormat(filename) == tarGzipFormat {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		rd = gz
+	}
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			f.Close()
+			if err == io.EOF {
+				err = &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+			}
+			return nil, err
+		}
+		if hdr.Name == name {
+			return &readCloser{Reader: tr, close: f.Close}, nil
+		}
+	}
+}
+
+func openZip(filename, name string) (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: rc, close: func() error {
+			rc.Close()
+			return zr.Close()
+		}}, nil
+	}
+	zr.Close()
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// Join implements filewalk.Filesystem.
+func (a *FS) Join(components ...string) string {
+	return path.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (a *FS) IsPermissionError(err error) bool {
+	return a.fs.IsPermissionError(err)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (a *FS) IsNotExist(err error) bool {
+	return a.fs.IsNotExist(err)
+}
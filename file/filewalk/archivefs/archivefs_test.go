@@ -0,0 +1,335 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package archivefs_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/archivefs"
+)
+
+var modTime = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+type entry struct {
+	name string
+	data string
+	mode os.FileMode
+}
+
+var entries = []entry{
+	{"a/", "", 0755},
+	{"a/f1", "1", 0644},
+	{"a/b/f2", "22", 0600},
+	{"f0", "", 0640},
+}
+
+func writeTar(t *testing.T, filename string, compress bool) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var wr io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		wr = gz
+	}
+	tw := tar.NewWriter(wr)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     int64(e.mode),
+			Size:     int64(len(e.data)),
+			ModTime:  modTime,
+			Uid:      1001,
+			Gid:      2002,
+			Typeflag: tar.TypeReg,
+		}
+		if strings.HasSuffix(e.name, "/") {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, filename string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Modified: modTime, Method: zip.Deflate}
+		mode := e.mode
+		if strings.HasSuffix(e.name, "/") {
+			mode |= os.ModeDir
+		}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createArchives(t *testing.T, dir string) {
+	writeTar(t, filepath.Join(dir, "archive.tar"), false)
+	writeTar(t, filepath.Join(dir, "archive.tar.gz"), true)
+	writeTar(t, filepath.Join(dir, "archive.tgz"), true)
+	writeZip(t, filepath.Join(dir, "archive.zip"))
+}
+
+func listAll(fs filewalk.Filesystem, p string) ([]filewalk.Info, []filewalk.Info, error) {
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		fs.List(context.Background(), p, ch)
+		close(ch)
+	}()
+	var children, files []filewalk.Info
+	for c := range ch {
+		if c.Err != nil {
+			return nil, nil, c.Err
+		}
+		children = append(children, c.Children...)
+		files = append(files, c.Files...)
+	}
+	return children, files, nil
+}
+
+func names(infos []filewalk.Info) []string {
+	n := []string{}
+	for _, i := range infos {
+		n = append(n, i.Name)
+	}
+	return n
+}
+
+func readAll(fs filewalk.Filesystem, p string) (string, error) {
+	rc, err := fs.(filewalk.Reader).Open(context.Background(), p)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	return string(buf), err
+}
+
+func TestArchives(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "archivefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	createArchives(t, tmpDir)
+
+	for _, name := range []string{"archive.tar", "archive.tar.gz", "archive.tgz", "archive.zip"} {
+		isTar := !strings.HasSuffix(name, ".zip")
+		fs, err := archivefs.New(filepath.Join(tmpDir, name), archivefs.ScanSize(1))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		children, files, err := listAll(fs, "/")
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if got, want := names(children), []string{"a"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+		if got, want := names(files), []string{"f0"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+		if got, want := children[0].Perms(), filewalk.FileMode(0755); got != want {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+
+		info, err := fs.Stat(ctx, "/a/b/f2")
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if got, want := info.Size, int64(2); got != want {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+		if got, want := info.Perms(), filewalk.FileMode(0600); got != want {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+		if got, want := info.ModTime, modTime; !got.Equal(want) {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+		if isTar {
+			if got, want := info.UserID+":"+info.GroupID, "1001:2002"; got != want {
+				t.Errorf("%v: got %v, want %v", name, got, want)
+			}
+			if _, ok := info.Sys().(*tar.Header); !ok {
+				t.Errorf("%v: wrong type for Sys: %T", name, info.Sys())
+			}
+		} else if _, ok := info.Sys().(*zip.FileHeader); !ok {
+			t.Errorf("%v: wrong type for Sys: %T", name, info.Sys())
+		}
+
+		info, err = fs.Stat(ctx, "/a/b")
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if !info.IsPrefix() {
+			t.Errorf("%v: %v is not a prefix", name, info.Name)
+		}
+		if _, err := fs.Stat(ctx, "/a/nowhere"); err == nil || !fs.IsNotExist(err) {
+			t.Errorf("%v: missing or wrong error: %v", name, err)
+		}
+
+		for _, e := range entries {
+			if strings.HasSuffix(e.name, "/") {
+				continue
+			}
+			data, err := readAll(fs, "/"+e.name)
+			if err != nil {
+				t.Errorf("%v: %v: %v", name, e.name, err)
+				continue
+			}
+			if got, want := data, e.data; got != want {
+				t.Errorf("%v: %v: got %q, want %q", name, e.name, got, want)
+			}
+		}
+		if _, err := readAll(fs, "/a/nowhere"); err == nil || !fs.IsNotExist(err) {
+			t.Errorf("%v: missing or wrong error: %v", name, err)
+		}
+		if _, err := readAll(fs, "/a"); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+
+	if _, err := archivefs.New(filepath.Join(tmpDir, "archive.rar")); err == nil || !strings.Contains(err.Error(), "unsupported archive format") {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	corrupt := filepath.Join(tmpDir, "corrupt.zip")
+	if err := ioutil.WriteFile(corrupt, []byte("not a zip file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archivefs.New(corrupt); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestComposite(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "archivefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(filepath.Join(tmpDir, "d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	createArchives(t, filepath.Join(tmpDir, "d"))
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "d", "plain"), []byte("plain"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// A directory with an archive's extension is treated as a directory.
+	if err := os.MkdirAll(filepath.Join(tmpDir, "dir.zip"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "dir.zip", "f"), []byte("f"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := archivefs.NewComposite(10, archivefs.MaxOpenArchives(2))
+	archive := filepath.Join(tmpDir, "d", "archive.tgz")
+	info, err := fs.Stat(ctx, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsPrefix() {
+		t.Errorf("%v: is not a prefix", archive)
+	}
+	if got, want := info.Name, "archive.tgz"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := fs.Stat(ctx, filepath.Join(archive, "a", "nowhere")); err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+
+	var mu sync.Mutex
+	var lines []string
+	wk := filewalk.New(fs, filewalk.Concurrency(2))
+	err = wk.Walk(ctx,
+		func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+			return false, nil, err
+		},
+		func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+			children := []filewalk.Info{}
+			for c := range ch {
+				if c.Err != nil {
+					return nil, c.Err
+				}
+				mu.Lock()
+				for _, f := range c.Files {
+					rel := strings.TrimPrefix(fs.Join(prefix, f.Name), tmpDir)
+					lines = append(lines, filepath.ToSlash(rel))
+				}
+				mu.Unlock()
+				children = append(children, c.Children...)
+			}
+			return children, nil
+		},
+		tmpDir,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	var expected []string
+	for _, a := range []string{"archive.tar", "archive.tar.gz", "archive.tgz", "archive.zip"} {
+		expected = append(expected, "/d/"+a+"/a/b/f2", "/d/"+a+"/a/f1", "/d/"+a+"/f0")
+	}
+	expected = append(expected, "/d/plain", "/dir.zip/f")
+	sort.Strings(expected)
+	if got, want := lines, expected; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for p, want := range map[string]string{
+		filepath.Join(archive, "a", "b", "f2"):               "22",
+		filepath.Join(tmpDir, "d", "archive.zip", "a", "f1"): "1",
+		filepath.Join(tmpDir, "d", "plain"):                  "plain",
+	} {
+		if got, err := readAll(fs, p); err != nil || got != want {
+			t.Errorf("%v: got %q, %v, want %q", p, got, err, want)
+		}
+	}
+	if _, err := readAll(fs, archive); err == nil {
+		t.Errorf("expected an error")
+	}
+}
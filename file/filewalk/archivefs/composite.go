@@ -0,0 +1,191 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package archivefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+)
+
+type composite struct {
+	opts  options
+	local filewalk.Filesystem
+
+	mu       sync.Mutex
+	archives map[string]*FS
+	order    []string
+}
+
+// NewComposite returns a filewalk.Filesystem for the local filesystem that
+// treats any archive files it encounters as prefixes whose contents are
+// those of the archive. Paths within an archive are formed by joining the
+// archive's filename with the path of the entry within the archive, for
+// example /data/backup.tar.gz/home/user/notes.txt. The Info for an archive
+// has ModePrefix set but otherwise retains the size, modification time,
+// ownership and permissions of the archive file itself. Archives stored
+// within other archives are reported as files.
+func NewComposite(scanSize int, opts ...Option) filewalk.Filesystem {
+	return &composite{
+		opts:     defaultOptions(append([]Option{ScanSize(scanSize)}, opts...)),
+		local:    filewalk.LocalFilesystem(scanSize),
+		archives: map[string]*FS{},
+	}
+}
+
+// split returns the filename of the archive that contains p and the path
+// of p within that archive, or false if p is not within an archive.
+// p itself may be an archive, in which case its path within the archive
+// is "/".
+func (c *composite) split(ctx context.Context, p string) (string, string, bool) {
+	sep := string(filepath.Separator)
+	components := strings.Split(p, sep)
+	for i, component := range components {
+		if !IsArchive(component) {
+			continue
+		}
+		archive := strings.Join(components[:i+1], sep)
+		info, err := c.local.Stat(ctx, archive)
+		if err != nil || info.IsPrefix() || info.IsLink() {
+			continue
+		}
+		return archive, "/" + strings.Join(components[i+1:], "/"), true
+	}
+	return "", "", false
+}
+
+// open returns the FS for the specified archive, reading its index if
+// it is not already cached.
+func (c *composite) open(archive string) (*FS, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fs := c.archives[archive]; fs != nil {
+		return fs, nil
+	}
+	fs, err := New(archive, ScanSize(c.opts.scanSize))
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.maxOpen > 0 && len(c.order) >= c.opts.maxOpen {
+		delete(c.archives, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.archives[archive] = fs
+	c.order = append(c.order, archive)
+	return fs, nil
+}
+
+func asPrefix(info filewalk.Info) filewalk.Info {
+	info.Mode |= filewalk.ModePrefix
+	return info
+}
+
+func isArchiveFile(info filewalk.Info) bool {
+	return !info.IsPrefix() && !info.IsLink() && IsArchive(info.Name)
+}
+
+// Stat implements filewalk.Filesystem.
+func (c *composite) Stat(ctx context.Context, p string) (filewalk.Info, error) {
+	archive, inner, ok := c.split(ctx, p)
+	if !ok {
+		return c.local.Stat(ctx, p)
+	}
+	if inner == "/" {
+		info, err := c.local.Stat(ctx, archive)
+		if err != nil {
+			return info, err
+		}
+		return asPrefix(info), nil
+	}
+	fs, err := c.open(archive)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	return fs.Stat(ctx, inner)
+}
+
+// List implements filewalk.Filesystem.
+func (c *composite) List(ctx context.Context, p string, ch chan<- filewalk.Contents) {
+	if archive, inner, ok := c.split(ctx, p); ok {
+		fs, err := c.open(archive)
+		if err != nil {
+			ch <- filewalk.Contents{Path: p, Err: err}
+			return
+		}
+		c.listArchive(ctx, fs, p, inner, ch)
+		return
+	}
+	lch := make(chan filewalk.Contents, 1)
+	go func() {
+		c.local.List(ctx, p, lch)
+		close(lch)
+	}()
+	for contents := range lch {
+		// The slices may be retained by the underlying Filesystem, eg.
+		// if it is a cache, and hence new ones are created here rather
+		// than modifying them in place.
+		children := append([]filewalk.Info{}, contents.Children...)
+		files := make([]filewalk.Info, 0, len(contents.Files))
+		for _, file := range contents.Files {
+			if isArchiveFile(file) {
+				children = append(children, asPrefix(file))
+				continue
+			}
+			files = append(files, file)
+		}
+		contents.Children, contents.Files = children, files
+		ch <- contents
+	}
+}
+
+func (c *composite) listArchive(ctx context.Context, fs *FS, p, inner string, ch chan<- filewalk.Contents) {
+	ach := make(chan filewalk.Contents, 1)
+	go func() {
+		fs.List(ctx, inner, ach)
+		close(ach)
+	}()
+	for contents := range ach {
+		contents.Path = p
+		ch <- contents
+	}
+}
+
+// Open implements filewalk.Reader.
+func (c *composite) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	archive, inner, ok := c.split(ctx, p)
+	if !ok {
+		return c.local.(filewalk.Reader).Open(ctx, p)
+	}
+	if inner == "/" {
+		return nil, fmt.Errorf("%v: is an archive", p)
+	}
+	fs, err := c.open(archive)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(ctx, inner)
+}
+
+// Join implements filewalk.Filesystem.
+func (c *composite) Join(components ...string) string {
+	return c.local.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (c *composite) IsPermissionError(err error) bool {
+	return c.local.IsPermissionError(err) || errors.Is(err, os.ErrPermission)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (c *composite) IsNotExist(err error) bool {
+	return c.local.IsNotExist(err) || errors.Is(err, os.ErrNotExist)
+}
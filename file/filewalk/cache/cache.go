@@ -0,0 +1,255 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package cache provides a filewalk.Filesystem that wraps another
+// filewalk.Filesystem in order to memoize the results of Stat and List
+// calls. Cached results may be held in memory or persisted to a local
+// directory so that they survive across runs, which is useful when the
+// same, typically cloud hosted, hierarchy is walked repeatedly. Entries
+// may be expired after a fixed TTL and invalidated explicitly, and,
+// optionally, expired entries may be returned when the wrapped
+// filesystem fails.
+package cache
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	ttl   time.Duration
+	store Store
+	stale bool
+}
+
+// TTL sets the time for which cached entries remain valid. A TTL of zero,
+// the default, means that entries never expire.
+func TTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// WithStore sets the Store used to hold cached entries. The default is
+// an in-memory store as returned by NewMemoryStore.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// ServeStale controls whether expired entries are returned when the
+// wrapped filesystem fails with an error other than one for which
+// IsNotExist returns true.
+func ServeStale(v bool) Option {
+	return func(o *options) {
+		o.stale = v
+	}
+}
+
+// Stats represents the counters maintained by a caching Filesystem.
+type Stats struct {
+	// Hits is the number of calls to Stat and List that were satisfied
+	// from the cache.
+	Hits int64
+	// Misses is the number of calls to Stat and List that required
+	// the wrapped filesystem to be used.
+	Misses int64
+	// Stale is the number of calls that returned an expired entry
+	// because the wrapped filesystem failed.
+	Stale int64
+	// StoreErrors is the number of errors encountered reading from or
+	// writing to the Store; such errors are otherwise ignored.
+	StoreErrors int64
+}
+
+// FS is a filewalk.Filesystem that caches the results of Stat and List.
+// It also implements filewalk.Reader and filewalk.LinkFollower by
+// forwarding them, uncached, to the Filesystem it wraps; see filewalk.Open
+// and filewalk.StatFollow.
+type FS struct {
+	fs   filewalk.Filesystem
+	opts options
+	sep  string
+
+	hits, misses, stale, storeErrors int64
+}
+
+// New returns a filewalk.Filesystem that caches the results of successful
+// Stat and List calls on the supplied Filesystem. Failed calls are never
+// cached.
+func New(fs filewalk.Filesystem, opts ...Option) *FS {
+	c := &FS{fs: fs}
+	for _, fn := range opts {
+		fn(&c.opts)
+	}
+	if c.opts.store == nil {
+		c.opts.store = NewMemoryStore()
+	}
+	c.sep = filewalk.Separator(fs)
+	return c
+}
+
+// Stats returns the current values of the counters maintained by c.
+func (c *FS) Stats() Stats {
+	return Stats{
+		Hits:        atomic.LoadInt64(&c.hits),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Stale:       atomic.LoadInt64(&c.stale),
+		StoreErrors: atomic.LoadInt64(&c.storeErrors),
+	}
+}
+
+// Invalidate removes any cached entries for path.
+func (c *FS) Invalidate(path string) error {
+	return c.opts.store.Delete(func(p string) bool { return p == path })
+}
+
+// InvalidatePrefix removes any cached entries for prefix and everything
+// below it in the hierarchy.
+func (c *FS) InvalidatePrefix(prefix string) error {
+	parent := strings.TrimSuffix(prefix, c.sep) + c.sep
+	return c.opts.store.Delete(func(p string) bool {
+		return p == prefix || strings.HasPrefix(p, parent)
+	})
+}
+
+func (c *FS) expired(t time.Time) bool {
+	return c.opts.ttl > 0 && time.Since(t) > c.opts.ttl
+}
+
+func (c *FS) get(path string) (Entry, bool) {
+	e, ok, err := c.opts.store.Get(path)
+	if err != nil {
+		atomic.AddInt64(&c.storeErrors, 1)
+		return Entry{}, false
+	}
+	return e, ok
+}
+
+// update applies fn to the current entry for path, if any, and stores
+// the result.
+func (c *FS) update(path string, fn func(e *Entry)) {
+	e, _ := c.get(path)
+	fn(&e)
+	if err := c.opts.store.Set(path, e); err != nil {
+		atomic.AddInt64(&c.storeErrors, 1)
+	}
+}
+
+// useStale returns true if an expired entry should be returned rather
+// than err.
+func (c *FS) useStale(err error) bool {
+	if c.opts.stale && !c.fs.IsNotExist(err) {
+		atomic.AddInt64(&c.stale, 1)
+		return true
+	}
+	return false
+}
+
+// Stat implements filewalk.Filesystem.
+func (c *FS) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	e, ok := c.get(path)
+	cached := ok && !e.Stated.IsZero()
+	if cached && !c.expired(e.Stated) {
+		atomic.AddInt64(&c.hits, 1)
+		return *e.Info, nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+	info, err := c.fs.Stat(ctx, path)
+	if err != nil {
+		if cached && c.useStale(err) {
+			return *e.Info, nil
+		}
+		return info, err
+	}
+	c.update(path, func(e *Entry) {
+		e.Info, e.Stated = &info, time.Now()
+	})
+	return info, nil
+}
+
+// List implements filewalk.Filesystem. Note that the results from the
+// wrapped filesystem are only returned once they have all been obtained
+// so that they may be cached, or if an error is encountered, so that
+// a stale entry may be returned instead.
+func (c *FS) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	e, ok := c.get(path)
+	cached := ok && !e.Listed.IsZero()
+	if cached && !c.expired(e.Listed) {
+		atomic.AddInt64(&c.hits, 1)
+		send(e.Contents, ch)
+		return
+	}
+	atomic.AddInt64(&c.misses, 1)
+	lch := make(chan filewalk.Contents, 10)
+	go func() {
+		c.fs.List(ctx, path, lch)
+		close(lch)
+	}()
+	contents := []filewalk.Contents{}
+	var err error
+	for lc := range lch {
+		contents = append(contents, lc)
+		if err == nil {
+			err = lc.Err
+		}
+	}
+	if err != nil {
+		if cached && c.useStale(err) {
+			send(e.Contents, ch)
+			return
+		}
+		send(contents, ch)
+		return
+	}
+	c.update(path, func(e *Entry) {
+		e.Contents, e.Listed = contents, time.Now()
+	})
+	send(contents, ch)
+}
+
+// StatFollow implements filewalk.LinkFollower.
+func (c *FS) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	return filewalk.StatFollow(ctx, c.fs, path)
+}
+
+// FileID implements filewalk.LinkFollower.
+func (c *FS) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return filewalk.FileID(c.fs, info)
+}
+
+// Open implements filewalk.Reader.
+func (c *FS) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return filewalk.Open(ctx, c.fs, path)
+}
+
+func send(contents []filewalk.Contents, ch chan<- filewalk.Contents) {
+	for _, c := range contents {
+		ch <- c
+	}
+}
+
+// Join implements filewalk.Filesystem.
+func (c *FS) Join(components ...string) string {
+	return c.fs.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (c *FS) IsPermissionError(err error) bool {
+	return c.fs.IsPermissionError(err)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (c *FS) IsNotExist(err error) bool {
+	return c.fs.IsNotExist(err)
+}
@@ -0,0 +1,304 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/cache"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+// counting counts the calls made to the wrapped filesystem.
+type counting struct {
+	*memfs.FS
+	stats, lists int64
+}
+
+func (c *counting) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	atomic.AddInt64(&c.stats, 1)
+	return c.FS.Stat(ctx, path)
+}
+
+func (c *counting) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	atomic.AddInt64(&c.lists, 1)
+	c.FS.List(ctx, path, ch)
+}
+
+func newCounting(t *testing.T) *counting {
+	fs, err := memfs.Parse(`
+a/
+  f0 size=1
+  f1 size=2
+  b/
+    f2 size=3
+c/
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &counting{FS: fs}
+}
+
+func list(fs filewalk.Filesystem, path string) ([]string, error) {
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		fs.List(context.Background(), path, ch)
+		close(ch)
+	}()
+	var names []string
+	var err error
+	for c := range ch {
+		if c.Err != nil {
+			err = c.Err
+		}
+		for _, i := range append(c.Children, c.Files...) {
+			names = append(names, i.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, err
+}
+
+func expectList(t *testing.T, fs filewalk.Filesystem, path string, want ...string) {
+	t.Helper()
+	got, err := list(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 {
+		want = nil
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%v: got %v, want %v", path, got, want)
+	}
+}
+
+func expectStat(t *testing.T, fs filewalk.Filesystem, path string, size int64) {
+	t.Helper()
+	info, err := fs.Stat(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Size, size; got != want {
+		t.Errorf("%v: got %v, want %v", path, got, want)
+	}
+}
+
+func testCaching(t *testing.T, opts ...cache.Option) {
+	inner := newCounting(t)
+	fs := cache.New(inner, opts...)
+	for i := 0; i < 3; i++ {
+		expectList(t, fs, "/a", "b", "f0", "f1")
+		expectList(t, fs, "/a/b", "f2")
+		expectList(t, fs, "/c")
+		expectStat(t, fs, "/a/f1", 2)
+	}
+	if got, want := inner.lists, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := inner.stats, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := fs.Stats(), (cache.Stats{Hits: 8, Misses: 4}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Errors are not cached.
+	if _, err := fs.Stat(context.Background(), "/x"); err == nil || !fs.IsNotExist(err) {
+		t.Errorf("expected a not-exist error: %v", err)
+	}
+	if _, err := fs.Stat(context.Background(), "/x"); err == nil {
+		t.Errorf("expected an error")
+	}
+	if got, want := inner.stats, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Changes are only seen once the cache has been invalidated.
+	if err := inner.AddFile("/a/b/f3", filewalk.Info{Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.AddFile("/a/f4", filewalk.Info{Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+	expectList(t, fs, "/a/b", "f2")
+	expectList(t, fs, "/a", "b", "f0", "f1")
+	if err := fs.Invalidate("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	expectList(t, fs, "/a/b", "f2", "f3")
+	expectList(t, fs, "/a", "b", "f0", "f1")
+	if err := fs.InvalidatePrefix("/a"); err != nil {
+		t.Fatal(err)
+	}
+	expectList(t, fs, "/a", "b", "f0", "f1", "f4")
+	// /c is unaffected by the invalidation of /a.
+	lists := inner.lists
+	expectList(t, fs, "/c")
+	if got, want := inner.lists, lists; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testCaching(t)
+}
+
+func TestDir(t *testing.T) {
+	store, err := cache.NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCaching(t, cache.WithStore(store))
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	newFS := func() (*counting, *cache.FS) {
+		store, err := cache.NewDirStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inner := newCounting(t)
+		return inner, cache.New(inner, cache.WithStore(store))
+	}
+	inner, fs := newFS()
+	expectList(t, fs, "/a", "b", "f0", "f1")
+	expectStat(t, fs, "/a/f0", 1)
+	if inner.lists != 1 || inner.stats != 1 {
+		t.Errorf("unexpected calls: %v %v", inner.lists, inner.stats)
+	}
+
+	// A new instance uses the entries persisted by the previous one.
+	inner, fs = newFS()
+	expectList(t, fs, "/a", "b", "f0", "f1")
+	expectStat(t, fs, "/a/f0", 1)
+	if inner.lists != 0 || inner.stats != 0 {
+		t.Errorf("unexpected calls: %v %v", inner.lists, inner.stats)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	for _, stale := range []bool{false, true} {
+		inner := newCounting(t)
+		fs := cache.New(inner, cache.TTL(10*time.Millisecond), cache.ServeStale(stale))
+		expectList(t, fs, "/a", "b", "f0", "f1")
+		expectStat(t, fs, "/a/f0", 1)
+		expectList(t, fs, "/a", "b", "f0", "f1")
+		if got, want := inner.lists, int64(1); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		// Expired entries are refreshed.
+		expectList(t, fs, "/a", "b", "f0", "f1")
+		if got, want := inner.lists, int64(2); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		// Expired entries are only used when the wrapped filesystem fails
+		// and ServeStale is set.
+		failure := errors.New("unavailable")
+		inner.SetError(memfs.List, "/a", failure)
+		inner.SetError(memfs.Stat, "/a/f0", failure)
+		_, lerr := list(fs, "/a")
+		_, serr := fs.Stat(context.Background(), "/a/f0")
+		if stale {
+			if lerr != nil || serr != nil {
+				t.Errorf("unexpected errors: %v, %v", lerr, serr)
+			}
+			if got, want := fs.Stats().Stale, int64(2); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		} else {
+			if !errors.Is(lerr, failure) || !errors.Is(serr, failure) {
+				t.Errorf("unexpected errors: %v, %v", lerr, serr)
+			}
+		}
+
+		// Not-exist errors are always returned.
+		if err := inner.Remove("/a/f0"); err != nil {
+			t.Fatal(err)
+		}
+		inner.SetError(memfs.Stat, "/a/f0", nil)
+		if _, err := fs.Stat(context.Background(), "/a/f0"); !fs.IsNotExist(err) {
+			t.Errorf("expected a not-exist error: %v", err)
+		}
+	}
+}
+
+func TestForwarding(t *testing.T) {
+	ctx := context.Background()
+	inner := newCounting(t)
+	if err := inner.SetContents("/a/f0", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	fs := cache.New(inner)
+	for i := 0; i < 2; i++ {
+		rc, err := fs.Open(ctx, "/a/f0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if got, want := string(buf), "x"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		// memfs does not implement filewalk.LinkFollower and hence
+		// StatFollow is the same as an uncached Stat.
+		if _, err := fs.StatFollow(ctx, "/a/f1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := inner.stats, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, _, ok := fs.FileID(filewalk.Info{}); ok {
+		t.Errorf("unexpected file id")
+	}
+	if got, want := fs.Stats(), (cache.Stats{}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestContentsNotShared(t *testing.T) {
+	ctx := context.Background()
+	inner := newCounting(t)
+	fs := cache.New(inner)
+	modify := func() {
+		ch := make(chan filewalk.Contents, 10)
+		go func() {
+			fs.List(ctx, "/a", ch)
+			close(ch)
+		}()
+		for c := range ch {
+			for i := range c.Children {
+				c.Children[i].Name = "modified"
+			}
+			for i := range c.Files {
+				c.Files[i].Name = "modified"
+			}
+		}
+	}
+	// Neither the contents returned by a miss nor those returned by a hit
+	// may be shared with the cache.
+	modify()
+	expectList(t, fs, "/a", "b", "f0", "f1")
+	modify()
+	expectList(t, fs, "/a", "b", "f0", "f1")
+	if got, want := inner.lists, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
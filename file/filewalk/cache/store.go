@@ -0,0 +1,180 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+)
+
+// Entry represents the cached results for a single path. Stated and Listed
+// record when the results of Stat and List respectively were obtained
+// and are zero if there are no such results.
+type Entry struct {
+	Info     *filewalk.Info
+	Stated   time.Time
+	Contents []filewalk.Contents
+	Listed   time.Time
+}
+
+// Store represents the storage used to hold cached entries. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	// Get returns the entry for path, if any.
+	Get(path string) (Entry, bool, error)
+	// Set stores the entry for path, replacing any existing entry.
+	Set(path string, entry Entry) error
+	// Delete removes all entries whose path is matched by match.
+	Delete(match func(path string) bool) error
+}
+
+type memoryStore struct {
+	sync.Mutex
+	entries map[string]Entry
+}
+
+// NewMemoryStore returns a Store that holds entries in memory. Entries are
+// copied as they are stored and retrieved so that the Contents they hold
+// are not shared with, and hence cannot be modified by, their callers.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: map[string]Entry{}}
+}
+
+// Get implements Store.
+func (s *memoryStore) Get(path string) (Entry, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[path]
+	return copyEntry(e), ok, nil
+}
+
+// Set implements Store.
+func (s *memoryStore) Set(path string, entry Entry) error {
+	s.Lock()
+	defer s.Unlock()
+	s.entries[path] = copyEntry(entry)
+	return nil
+}
+
+func copyInfos(infos []filewalk.Info) []filewalk.Info {
+	if infos == nil {
+		return nil
+	}
+	return append(make([]filewalk.Info, 0, len(infos)), infos...)
+}
+
+func copyEntry(e Entry) Entry {
+	if e.Info != nil {
+		info := *e.Info
+		e.Info = &info
+	}
+	if e.Contents != nil {
+		contents := make([]filewalk.Contents, len(e.Contents))
+		for i, c := range e.Contents {
+			c.Children, c.Files = copyInfos(c.Children), copyInfos(c.Files)
+			contents[i] = c
+		}
+		e.Contents = contents
+	}
+	return e
+}
+
+// Delete implements Store.
+func (s *memoryStore) Delete(match func(path string) bool) error {
+	s.Lock()
+	defer s.Unlock()
+	for p := range s.entries {
+		if match(p) {
+			delete(s.entries, p)
+		}
+	}
+	return nil
+}
+
+type dirStore struct {
+	dir string
+}
+
+// NewDirStore returns a Store that persists each entry, gob encoded, in its
+// own file within dir. The file name is the escaped form of the entry's
+// path and hence the length of the paths that can be cached is limited by
+// the local filesystem's limit on the length of a file name.
+func NewDirStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+func (s *dirStore) filename(path string) string {
+	return filepath.Join(s.dir, url.PathEscape(path))
+}
+
+// Get implements Store.
+func (s *dirStore) Get(path string) (Entry, bool, error) {
+	buf, err := os.ReadFile(s.filename(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Entry{}, false, nil
+		}
+		return Entry{}, false, err
+	}
+	var e Entry
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&e); err != nil {
+		return Entry{}, false, err
+	}
+	return e, true, nil
+}
+
+// Set implements Store. Entries are written to a temporary file that is
+// then renamed so that concurrent readers never see a partial entry.
+func (s *dirStore) Set(path string, entry Entry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.filename(path))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Delete implements Store.
+func (s *dirStore) Delete(match func(path string) bool) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	errs := errors.M{}
+	for _, e := range entries {
+		p, err := url.PathUnescape(e.Name())
+		if err != nil || !match(p) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			errs.Append(err)
+		}
+	}
+	return errs.Err()
+}
@@ -0,0 +1,426 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package chaos provides a filewalk.Filesystem that wraps another
+// filewalk.Filesystem in order to inject faults into its Stat, List and
+// Open calls for testing purposes. Faults are described by Rules, which may be
+// added and removed as a test progresses, and include latency drawn from
+// a distribution, errors returned with a given probability or for matching
+// paths, listings that are truncated, with or without an error, after some
+// entries have been returned, and hangs that ignore context cancelation.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Op identifies the Filesystem operation that a Rule applies to.
+type Op int
+
+const (
+	// Any refers to all operations.
+	Any Op = iota
+	// Stat refers to Filesystem.Stat and LinkFollower.StatFollow.
+	Stat
+	// List refers to Filesystem.List.
+	List
+	// Open refers to Reader.Open.
+	Open
+)
+
+// String implements stringer.
+func (op Op) String() string {
+	switch op {
+	case Any:
+		return "any"
+	case Stat:
+		return "stat"
+	case List:
+		return "list"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("unknown op: %d", int(op))
+}
+
+// Distribution represents a distribution of latencies.
+type Distribution func(rnd *rand.Rand) time.Duration
+
+// Fixed returns a Distribution that always returns d.
+func Fixed(d time.Duration) Distribution {
+	return func(*rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// Uniform returns a Distribution that is uniformly distributed over
+// [min, max).
+func Uniform(min, max time.Duration) Distribution {
+	return func(rnd *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rnd.Int63n(int64(max-min)))
+	}
+}
+
+// Exponential returns a Distribution that is exponentially distributed
+// with the specified mean.
+func Exponential(mean time.Duration) Distribution {
+	return func(rnd *rand.Rand) time.Duration {
+		return time.Duration(rnd.ExpFloat64() * float64(mean))
+	}
+}
+
+// Matcher determines if a Rule applies to a given path.
+type Matcher func(path string) bool
+
+// Paths returns a Matcher for the specified paths.
+func Paths(paths ...string) Matcher {
+	return func(p string) bool {
+		for _, m := range paths {
+			if p == m {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Glob returns a Matcher for paths that match pattern as per path.Match.
+func Glob(pattern string) Matcher {
+	return func(p string) bool {
+		matched, _ := path.Match(pattern, p)
+		return matched
+	}
+}
+
+// Under returns a Matcher for prefix and all paths below it in a
+// hierarchy that uses '/' as its separator.
+func Under(prefix string) Matcher {
+	parent := strings.TrimSuffix(prefix, "/") + "/"
+	return func(p string) bool {
+		return p == prefix || strings.HasPrefix(p, parent)
+	}
+}
+
+// Rule describes a fault to be injected.
+type Rule struct {
+	// Op is the operation that the rule applies to.
+	Op Op
+	// Match, if non-nil, restricts the rule to the paths that it matches.
+	Match Matcher
+	// Probability is the probability with which the rule is applied to
+	// a matching call; zero, or any value of one or more, means always.
+	Probability float64
+	// Times, if greater than zero, is the maximum number of times that the
+	// rule will be applied.
+	Times int
+
+	// Latency, if non-nil, is the distribution from which a delay to be
+	// applied before the call is made to the wrapped filesystem is drawn.
+	// The delay is cut short if the context is canceled.
+	Latency Distribution
+	// Hang causes the call to block, regardless of its context, until
+	// Release is called.
+	Hang bool
+	// Err is the error to be returned. For List it is returned as the Err
+	// field of a Contents after any entries allowed by Truncate have been
+	// returned.
+	Err error
+	// Truncate, if greater than zero, limits the number of entries, children
+	// and files combined, returned by List to Truncate. It is ignored for
+	// all other operations.
+	Truncate int
+}
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	seed int64
+}
+
+// Seed sets the seed used for the random number generator used to
+// determine whether rules are applied and to draw latencies. The default
+// is 1 so that the faults injected are reproducible.
+func Seed(seed int64) Option {
+	return func(o *options) {
+		o.seed = seed
+	}
+}
+
+// Stats represents the counters maintained by a chaos Filesystem.
+type Stats struct {
+	Delayed   int64 // number of calls that were delayed.
+	Hung      int64 // number of calls that hung.
+	Errors    int64 // number of errors injected.
+	Truncated int64 // number of listings truncated.
+}
+
+type rule struct {
+	Rule
+	applied int
+}
+
+// FS is a filewalk.Filesystem that injects faults. It also implements
+// filewalk.Reader and filewalk.LinkFollower by forwarding them, subject to
+// any matching rules, to the Filesystem it wraps; see filewalk.Open and
+// filewalk.StatFollow. It is safe for concurrent use.
+type FS struct {
+	fs filewalk.Filesystem
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	rules   []*rule
+	release chan struct{}
+
+	delayed, hung, errors, truncated int64
+}
+
+// New returns a new FS that wraps fs and initially injects no faults.
+func New(fs filewalk.Filesystem, opts ...Option) *FS {
+	o := options{seed: 1}
+	for _, fn := range opts {
+		fn(&o)
+	}
+	return &FS{
+		fs:      fs,
+		rnd:     rand.New(rand.NewSource(o.seed)),
+		release: make(chan struct{}),
+	}
+}
+
+// Add appends the specified rules. For every call the rules are evaluated
+// in the order in which they were added and the first one that applies
+// is used.
+func (c *FS) Add(rules ...Rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range rules {
+		c.rules = append(c.rules, &rule{Rule: r})
+	}
+}
+
+// Reset removes all rules.
+func (c *FS) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = nil
+}
+
+// Release unblocks all calls that are hung and prevents any subsequent
+// calls from hanging.
+func (c *FS) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.release:
+	default:
+		close(c.release)
+	}
+}
+
+// Stats returns the current values of the counters maintained by c.
+func (c *FS) Stats() Stats {
+	return Stats{
+		Delayed:   atomic.LoadInt64(&c.delayed),
+		Hung:      atomic.LoadInt64(&c.hung),
+		Errors:    atomic.LoadInt64(&c.errors),
+		Truncated: atomic.LoadInt64(&c.truncated),
+	}
+}
+
+// fault describes the fault to be injected for a single call.
+type fault struct {
+	latency  time.Duration
+	hang     bool
+	err      error
+	truncate int
+}
+
+// lookup returns the fault, if any, to be injected for the specified call.
+func (c *FS) lookup(op Op, p string) *fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.rules {
+		if r.Op != Any && r.Op != op {
+			continue
+		}
+		if r.Match != nil && !r.Match(p) {
+			continue
+		}
+		if r.Times > 0 && r.applied >= r.Times {
+			continue
+		}
+		if r.Probability > 0 && r.Probability < 1 && c.rnd.Float64() >= r.Probability {
+			continue
+		}
+		r.applied++
+		f := &fault{hang: r.Hang, err: r.Err, truncate: r.Truncate}
+		if r.Latency != nil {
+			f.latency = r.Latency(c.rnd)
+		}
+		return f
+	}
+	return nil
+}
+
+// inject applies the latency and hang, if any, specified by f and returns
+// a non-nil error if the context was canceled whilst doing so.
+func (c *FS) inject(ctx context.Context, f *fault) error {
+	if f.latency > 0 {
+		atomic.AddInt64(&c.delayed, 1)
+		timer := time.NewTimer(f.latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if f.hang {
+		atomic.AddInt64(&c.hung, 1)
+		c.mu.Lock()
+		release := c.release
+		c.mu.Unlock()
+		<-release
+	}
+	return nil
+}
+
+// apply looks up and applies the fault, if any, for the specified call
+// and returns the error, if any, that should be returned in place of
+// making the call.
+func (c *FS) apply(ctx context.Context, op Op, p string) error {
+	f := c.lookup(op, p)
+	if f == nil {
+		return nil
+	}
+	if err := c.inject(ctx, f); err != nil {
+		return err
+	}
+	if f.err != nil {
+		atomic.AddInt64(&c.errors, 1)
+	}
+	return f.err
+}
+
+// Stat implements filewalk.Filesystem.
+func (c *FS) Stat(ctx context.Context, p string) (filewalk.Info, error) {
+	if err := c.apply(ctx, Stat, p); err != nil {
+		return filewalk.Info{}, err
+	}
+	return c.fs.Stat(ctx, p)
+}
+
+// StatFollow implements filewalk.LinkFollower.
+func (c *FS) StatFollow(ctx context.Context, p string) (filewalk.Info, error) {
+	if err := c.apply(ctx, Stat, p); err != nil {
+		return filewalk.Info{}, err
+	}
+	return filewalk.StatFollow(ctx, c.fs, p)
+}
+
+// FileID implements filewalk.LinkFollower.
+func (c *FS) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return filewalk.FileID(c.fs, info)
+}
+
+// Open implements filewalk.Reader.
+func (c *FS) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	if err := c.apply(ctx, Open, p); err != nil {
+		return nil, err
+	}
+	return filewalk.Open(ctx, c.fs, p)
+}
+
+// List implements filewalk.Filesystem.
+func (c *FS) List(ctx context.Context, p string, ch chan<- filewalk.Contents) {
+	f := c.lookup(List, p)
+	if f == nil {
+		c.fs.List(ctx, p, ch)
+		return
+	}
+	if err := c.inject(ctx, f); err != nil {
+		ch <- filewalk.Contents{Path: p, Err: err}
+		return
+	}
+	if f.truncate <= 0 {
+		if f.err != nil {
+			atomic.AddInt64(&c.errors, 1)
+			ch <- filewalk.Contents{Path: p, Err: f.err}
+			return
+		}
+		c.fs.List(ctx, p, ch)
+		return
+	}
+	c.listTruncated(ctx, p, f, ch)
+}
+
+// listTruncated lists p, returning at most f.truncate entries, followed by
+// f.err, if set, regardless of whether any entries were dropped.
+func (c *FS) listTruncated(ctx context.Context, p string, f *fault, ch chan<- filewalk.Contents) {
+	lch := make(chan filewalk.Contents, 10)
+	go func() {
+		c.fs.List(ctx, p, lch)
+		close(lch)
+	}()
+	remaining := f.truncate
+	dropped := false
+	for contents := range lch {
+		n := len(contents.Children) + len(contents.Files)
+		if n > remaining {
+			dropped = true
+			if remaining == 0 {
+				// Drain the remaining contents so that the goroutine
+				// above exits.
+				continue
+			}
+			contents.Children, contents.Files = truncate(contents.Children, contents.Files, remaining)
+		}
+		remaining -= len(contents.Children) + len(contents.Files)
+		ch <- contents
+	}
+	if dropped {
+		atomic.AddInt64(&c.truncated, 1)
+	}
+	if f.err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		ch <- filewalk.Contents{Path: p, Err: f.err}
+	}
+}
+
+// truncate limits children and files, in that order, to n entries.
+func truncate(children, files []filewalk.Info, n int) ([]filewalk.Info, []filewalk.Info) {
+	if len(children) >= n {
+		return children[:n], nil
+	}
+	return children, files[:n-len(children)]
+}
+
+// Join implements filewalk.Filesystem.
+func (c *FS) Join(components ...string) string {
+	return c.fs.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (c *FS) IsPermissionError(err error) bool {
+	return c.fs.IsPermissionError(err)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (c *FS) IsNotExist(err error) bool {
+	return c.fs.IsNotExist(err)
+}
@@ -0,0 +1,288 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package chaos_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	cerrors "cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/chaos"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+const tree = `
+a/
+  f0
+  f1
+  f2
+  b/
+    f3
+c/
+  f4
+d/
+  f5
+`
+
+func newFS(t *testing.T, opts ...chaos.Option) *chaos.FS {
+	fs, err := memfs.Parse(tree, memfs.ListBatchSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chaos.New(fs, opts...)
+}
+
+// walk walks fs and returns the sorted names of the files encountered
+// and the errors recorded by the walker.
+func walk(t *testing.T, fs filewalk.Filesystem) ([]string, []string) {
+	var mu sync.Mutex
+	var files []string
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		return err != nil, nil, err
+	}
+	contentsFn := func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+		var children []filewalk.Info
+		var err error
+		for c := range ch {
+			if c.Err != nil {
+				err = c.Err
+				continue
+			}
+			mu.Lock()
+			for _, f := range c.Files {
+				files = append(files, f.Name)
+			}
+			mu.Unlock()
+			children = append(children, c.Children...)
+		}
+		return children, err
+	}
+	err := filewalk.New(fs).Walk(context.Background(), prefixFn, contentsFn, "/")
+	var errs []string
+	if err != nil {
+		m, ok := err.(*cerrors.M)
+		if !ok {
+			t.Fatalf("unexpected error type: %T", err)
+		}
+		for e := m.Unwrap(); e != nil; e = m.Unwrap() {
+			var fe *filewalk.Error
+			if !errors.As(e, &fe) {
+				t.Fatalf("unexpected error: %v", e)
+			}
+			errs = append(errs, fe.Op+" "+fe.Path)
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(errs)
+	return files, errs
+}
+
+func TestErrors(t *testing.T) {
+	fs := newFS(t)
+	failure := errors.New("failure")
+	fs.Add(
+		// An error part way through the listing of /a.
+		chaos.Rule{Op: chaos.List, Match: chaos.Paths("/a"), Truncate: 3, Err: failure},
+		chaos.Rule{Op: chaos.Stat, Match: chaos.Glob("/d*"), Err: os.ErrPermission},
+	)
+	files, errs := walk(t, fs)
+	// memfs lists entries in lexical order, hence b, f0 and f1 are
+	// returned, but since the listing failed, b is not descended.
+	if got, want := files, []string{"f0", "f1", "f4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := errs, []string{"fileFunc /a", "stat /d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := fs.Stats(), (chaos.Stats{Errors: 2, Truncated: 1}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Once the rules are removed, the walk succeeds.
+	fs.Reset()
+	files, errs = walk(t, fs)
+	if got, want := files, []string{"f0", "f1", "f2", "f3", "f4", "f5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	fs := newFS(t)
+	list := func(path string) (int, error) {
+		ch := make(chan filewalk.Contents, 10)
+		fs.List(context.Background(), path, ch)
+		close(ch)
+		n := 0
+		var err error
+		for c := range ch {
+			n += len(c.Children) + len(c.Files)
+			if c.Err != nil {
+				err = c.Err
+			}
+		}
+		return n, err
+	}
+	failure := errors.New("failure")
+	for _, tc := range []struct {
+		truncate  int
+		err       error
+		n         int
+		truncated bool
+	}{
+		{1, nil, 1, true},
+		{2, failure, 2, true},
+		{3, failure, 3, true},
+		{4, failure, 4, false},
+		// Not truncated, but the error is still returned.
+		{5, failure, 4, false},
+		{5, nil, 4, false},
+	} {
+		fs.Reset()
+		before := fs.Stats().Truncated
+		fs.Add(chaos.Rule{Op: chaos.List, Truncate: tc.truncate, Err: tc.err})
+		n, err := list("/a")
+		if got, want := n, tc.n; got != want {
+			t.Errorf("%v: got %v, want %v", tc.truncate, got, want)
+		}
+		if err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.truncate, err, tc.err)
+		}
+		if got, want := fs.Stats().Truncated-before == 1, tc.truncated; got != want {
+			t.Errorf("%v: got %v, want %v", tc.truncate, got, want)
+		}
+	}
+}
+
+func TestProbability(t *testing.T) {
+	count := func(seed int64) int {
+		fs := newFS(t, chaos.Seed(seed))
+		fs.Add(chaos.Rule{Op: chaos.Stat, Probability: 0.25, Err: os.ErrNotExist})
+		failed := 0
+		for i := 0; i < 1000; i++ {
+			if _, err := fs.Stat(context.Background(), "/a"); err != nil {
+				failed++
+			}
+		}
+		return failed
+	}
+	failed := count(1)
+	if failed < 200 || failed > 300 {
+		t.Errorf("unexpected number of failures: %v", failed)
+	}
+	// Faults are reproducible for a given seed.
+	if got, want := count(1), failed; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	fs := newFS(t)
+	fs.Add(chaos.Rule{Op: chaos.Any, Times: 2, Err: os.ErrNotExist})
+	failed = 0
+	for i := 0; i < 10; i++ {
+		if _, err := fs.Stat(context.Background(), "/a"); err != nil {
+			failed++
+		}
+	}
+	if got, want := failed, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLatencyAndHang(t *testing.T) {
+	fs := newFS(t)
+	delay := 20 * time.Millisecond
+	fs.Add(chaos.Rule{Match: chaos.Under("/a"), Latency: chaos.Uniform(delay, 2*delay)})
+	fs.Add(chaos.Rule{Match: chaos.Paths("/c"), Hang: true})
+	start := time.Now()
+	if _, err := fs.Stat(context.Background(), "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < delay {
+		t.Errorf("call was too fast: %v", took)
+	}
+
+	// Hangs ignore context cancelation.
+	ctx, cancel := context.WithTimeout(context.Background(), delay)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := fs.Stat(ctx, "/c")
+		done <- err
+	}()
+	time.Sleep(2 * delay)
+	select {
+	case <-done:
+		t.Fatalf("call did not hang")
+	default:
+	}
+	fs.Release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fs.Stats(), (chaos.Stats{Delayed: 1, Hung: 1}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	fs := newFS(t)
+	for _, d := range []chaos.Distribution{
+		chaos.Fixed(time.Millisecond),
+		chaos.Uniform(time.Millisecond, 2*time.Millisecond),
+		chaos.Exponential(time.Millisecond),
+	} {
+		fs.Reset()
+		fs.Add(chaos.Rule{Latency: d})
+		if _, err := fs.Stat(context.Background(), "/a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The exponential distribution may yield a zero delay.
+	if got := fs.Stats().Delayed; got < 2 {
+		t.Errorf("unexpected number of delayed calls: %v", got)
+	}
+}
+
+func TestForwarding(t *testing.T) {
+	ctx := context.Background()
+	fs := newFS(t)
+	failure := errors.New("failure")
+	fs.Add(
+		chaos.Rule{Op: chaos.Open, Match: chaos.Paths("/a/f0"), Err: failure},
+		chaos.Rule{Op: chaos.Stat, Match: chaos.Paths("/a/f1"), Err: os.ErrNotExist},
+	)
+	if _, err := fs.Open(ctx, "/a/f0"); err != failure {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	rc, err := fs.Open(ctx, "/a/f2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	// StatFollow is subject to the rules for Stat.
+	if _, err := fs.StatFollow(ctx, "/a/f1"); !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	info, err := fs.StatFollow(ctx, "/a/f2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// memfs does not implement filewalk.LinkFollower.
+	if _, _, ok := fs.FileID(info); ok {
+		t.Errorf("unexpected file id")
+	}
+	if got, want := fs.Stats(), (chaos.Stats{Errors: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
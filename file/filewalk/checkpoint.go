@@ -0,0 +1,313 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Checkpoint represents the frontier of a walk, that is, the prefixes
+// that have yet to be traversed.
+type Checkpoint struct {
+	// Roots are the roots of the walk that the checkpoint belongs to.
+	Roots []string
+	// Pending are the prefixes that have been discovered but whose
+	// traversal has not started.
+	Pending []string
+	// InProgress are the prefixes that were being listed when the
+	// checkpoint was taken.
+	InProgress []string
+	// Completed is the number of prefixes whose listing had completed
+	// when the checkpoint was taken.
+	Completed int64
+	// Done is true if the walk completed.
+	Done bool
+	// When is the time at which the checkpoint was taken.
+	When time.Time
+	// States are the states accumulated by the walk on its way to those
+	// pending and in-progress prefixes that have any, keyed by prefix.
+	States map[string]PrefixState `json:",omitempty"`
+}
+
+// PrefixState represents the state accumulated by a walk as it descends
+// to a prefix that must be restored when a walk is resumed from that
+// prefix.
+type PrefixState struct {
+	// Device is the device of the root, or most recent mount point, that
+	// the prefix was reached from, as used by the SameDevice option.
+	Device uint64 `json:",omitempty"`
+	// Ancestors are the ids of the prefixes between the root and the
+	// prefix, starting with the root, as used to detect link cycles
+	// when the FollowLinks option is in effect.
+	Ancestors []DeviceInode `json:",omitempty"`
+}
+
+// DeviceInode represents the device and inode numbers of a file or prefix.
+type DeviceInode struct {
+	Device, Inode uint64
+}
+
+// prefixState returns the PrefixState for state and false if there is
+// nothing to be saved.
+func (state walkState) prefixState() (PrefixState, bool) {
+	ps := PrefixState{Device: state.device}
+	for a := state.parents; a != nil; a = a.parent {
+		ps.Ancestors = append(ps.Ancestors, DeviceInode{Device: a.device, Inode: a.inode})
+	}
+	for i, j := 0, len(ps.Ancestors)-1; i < j; i, j = i+1, j-1 {
+		ps.Ancestors[i], ps.Ancestors[j] = ps.Ancestors[j], ps.Ancestors[i]
+	}
+	return ps, ps.Device != 0 || len(ps.Ancestors) > 0
+}
+
+// walkState returns the walkState represented by ps.
+func (ps PrefixState) walkState() walkState {
+	state := walkState{device: ps.Device}
+	for _, id := range ps.Ancestors {
+		state.parents = &ancestor{device: id.Device, inode: id.Inode, parent: state.parents}
+	}
+	return state
+}
+
+// Checkpointer is the interface used to persist Checkpoints. It may be
+// implemented by a Database; see FileCheckpointer for a file based
+// implementation.
+type Checkpointer interface {
+	// SaveCheckpoint stores the supplied checkpoint, overwriting any
+	// existing one.
+	SaveCheckpoint(ctx context.Context, cp *Checkpoint) error
+	// LoadCheckpoint returns the most recently saved checkpoint or nil
+	// if there is none.
+	LoadCheckpoint(ctx context.Context) (*Checkpoint, error)
+}
+
+type fileCheckpointer struct {
+	filename string
+}
+
+// FileCheckpointer returns a Checkpointer that stores checkpoints, in JSON
+// format, in the specified file. The file is replaced atomically on every
+// save.
+func FileCheckpointer(filename string) Checkpointer {
+	return &fileCheckpointer{filename: filename}
+}
+
+// SaveCheckpoint implements Checkpointer.
+func (fc *fileCheckpointer) SaveCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	buf, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fc.filename), filepath.Base(fc.filename)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fc.filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// LoadCheckpoint implements Checkpointer.
+func (fc *fileCheckpointer) LoadCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	buf, err := ioutil.ReadFile(fc.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(buf, cp); err != nil {
+		return nil, fmt.Errorf("%v: %v", fc.filename, err)
+	}
+	return cp, nil
+}
+
+// frontier tracks the prefixes that are pending or being listed along
+// with the state to be used for each of them.
+type frontier struct {
+	sync.Mutex
+	roots      []string
+	pending    map[string]walkState
+	inProgress map[string]walkState
+	completed  int64
+}
+
+func newFrontier(roots []string, pending []startingPoint) *frontier {
+	f := &frontier{
+		roots:      roots,
+		pending:    map[string]walkState{},
+		inProgress: map[string]walkState{},
+	}
+	for _, p := range pending {
+		f.pending[p.path] = p.state
+	}
+	return f
+}
+
+// start records that prefix is being listed.
+func (f *frontier) start(prefix string, state walkState) {
+	if f == nil {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	delete(f.pending, prefix)
+	f.inProgress[prefix] = state
+}
+
+// done records that the listing of prefix has completed and that its
+// children, which share the same state, are now pending.
+func (f *frontier) done(prefix string, children []string, state walkState) {
+	if f == nil {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	delete(f.inProgress, prefix)
+	for _, c := range children {
+		f.pending[c] = state
+	}
+	f.completed++
+}
+
+func sortedKeys(m map[string]walkState) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *frontier) checkpoint() *Checkpoint {
+	f.Lock()
+	defer f.Unlock()
+	cp := &Checkpoint{
+		Roots:      f.roots,
+		Pending:    sortedKeys(f.pending),
+		InProgress: sortedKeys(f.inProgress),
+		Completed:  f.completed,
+		When:       time.Now(),
+	}
+	cp.Done = len(cp.Pending) == 0 && len(cp.InProgress) == 0
+	for _, m := range []map[string]walkState{f.pending, f.inProgress} {
+		for p, state := range m {
+			ps, ok := state.prefixState()
+			if !ok {
+				continue
+			}
+			if cp.States == nil {
+				cp.States = map[string]PrefixState{}
+			}
+			cp.States[p] = ps
+		}
+	}
+	return cp
+}
+
+func sameRoots(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// startingPoint represents a prefix that a walk starts from and the
+// state to be used for it.
+type startingPoint struct {
+	path  string
+	state walkState
+}
+
+// startingPoints returns the prefixes that a walk should start from,
+// taking any checkpoint to be resumed into account, and initializes
+// w.frontier if checkpointing or resumption has been requested.
+func (w *Walker) startingPoints(ctx context.Context, roots []string) ([]startingPoint, error) {
+	starts := make([]startingPoint, len(roots))
+	for i, root := range roots {
+		starts[i] = startingPoint{path: root}
+	}
+	if w.opts.resume == nil && w.opts.checkpointer == nil {
+		return starts, nil
+	}
+	if w.opts.resume != nil {
+		cp, err := w.opts.resume.LoadCheckpoint(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil && !cp.Done {
+			if !sameRoots(cp.Roots, roots) {
+				return nil, fmt.Errorf("checkpoint is for a walk of %v and not %v", cp.Roots, roots)
+			}
+			isRoot := map[string]bool{}
+			for _, root := range roots {
+				isRoot[root] = true
+			}
+			starts = nil
+			for _, p := range append(append([]string{}, cp.InProgress...), cp.Pending...) {
+				state := cp.States[p].walkState()
+				state.started = !isRoot[p]
+				starts = append(starts, startingPoint{path: p, state: state})
+			}
+			w.frontier = newFrontier(roots, starts)
+			w.frontier.completed = cp.Completed
+			return starts, nil
+		}
+	}
+	w.frontier = newFrontier(roots, starts)
+	return starts, nil
+}
+
+// checkpointer periodically saves the frontier until doneCh is closed,
+// at which point it saves a final checkpoint.
+func (w *Walker) checkpointer(ctx context.Context, doneCh <-chan struct{}) error {
+	if w.opts.checkpointer == nil {
+		return nil
+	}
+	interval := w.opts.checkpointInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.opts.checkpointer.SaveCheckpoint(ctx, w.frontier.checkpoint()); err != nil {
+				return err
+			}
+		case <-doneCh:
+			// Use a fresh context since ctx may have been canceled and
+			// the final checkpoint is the most important one.
+			return w.opts.checkpointer.SaveCheckpoint(context.Background(), w.frontier.checkpoint())
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+const checkpointTree = `
+a0/
+  f0 1
+  a0.0/
+    f1 1
+    a1.0/
+      f2 1
+  a0.1/
+    f3 1
+b0/
+  b0.0/
+    f4 1
+  b0.1/
+    f5 1
+c0/
+  f6 1
+`
+
+type recorder struct {
+	sync.Mutex
+	prefixes []string
+	cancelAt string
+	cancel   func()
+}
+
+func (r *recorder) prefixFunc(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.prefixes = append(r.prefixes, prefix)
+	if prefix == r.cancelAt {
+		r.cancel()
+	}
+	return false, nil, err
+}
+
+func (r *recorder) contentsFunc(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+	children := []filewalk.Info{}
+	for c := range ch {
+		children = append(children, c.Children...)
+	}
+	return children, nil
+}
+
+func (r *recorder) sorted() []string {
+	r.Lock()
+	defer r.Unlock()
+	sort.Strings(r.prefixes)
+	return r.prefixes
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(checkpointTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpr := filewalk.FileCheckpointer(filepath.Join(t.TempDir(), "checkpoint.json"))
+	if err := cpr.SaveCheckpoint(ctx, &filewalk.Checkpoint{
+		Roots:      []string{"/"},
+		Pending:    []string{"/b0", "/c0"},
+		InProgress: []string{"/a0/a0.0"},
+		Completed:  3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{}
+	wk := filewalk.New(fs, filewalk.ResumeFrom(cpr), filewalk.CheckpointTo(cpr, time.Hour))
+	if err := wk.Walk(ctx, rec.prefixFunc, rec.contentsFunc, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.sorted(), []string{
+		"/a0/a0.0", "/a0/a0.0/a1.0",
+		"/b0", "/b0/b0.0", "/b0/b0.1",
+		"/c0",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	cp, err := cpr.LoadCheckpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.Done || len(cp.Pending) != 0 || len(cp.InProgress) != 0 {
+		t.Errorf("walk is not complete: %#v", cp)
+	}
+	if got, want := cp.Completed, int64(9); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// A completed checkpoint results in a full walk.
+	rec = &recorder{}
+	if err := wk.Walk(ctx, rec.prefixFunc, rec.contentsFunc, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(rec.sorted()), 9; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The roots must match.
+	if err := cpr.SaveCheckpoint(ctx, &filewalk.Checkpoint{Roots: []string{"/a0"}, Pending: []string{"/a0"}}); err != nil {
+		t.Fatal(err)
+	}
+	err = wk.Walk(ctx, rec.prefixFunc, rec.contentsFunc, "/b0")
+	if err == nil || !strings.Contains(err.Error(), "checkpoint is for a walk of") {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+func TestCheckpointOnCancel(t *testing.T) {
+	fs, err := memfs.Parse(checkpointTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpr := filewalk.FileCheckpointer(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := &recorder{cancelAt: "/a0/a0.0", cancel: cancel}
+	wk := filewalk.New(fs, filewalk.Concurrency(1), filewalk.CheckpointTo(cpr, time.Millisecond))
+	err = wk.Walk(ctx, first.prefixFunc, first.contentsFunc, "/")
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("missing or wrong error: %v", err)
+	}
+	cp, err := cpr.LoadCheckpoint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Done || len(cp.Pending)+len(cp.InProgress) == 0 {
+		t.Fatalf("walk should not be complete: %#v", cp)
+	}
+
+	second := &recorder{}
+	wk = filewalk.New(fs, filewalk.ResumeFrom(cpr), filewalk.CheckpointTo(cpr, time.Hour))
+	if err := wk.Walk(context.Background(), second.prefixFunc, second.contentsFunc, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(second.sorted()); got == 0 || got >= 9 {
+		t.Errorf("resumed walk visited %v prefixes", got)
+	}
+	all := map[string]bool{}
+	for _, p := range append(first.sorted(), second.sorted()...) {
+		all[p] = true
+	}
+	if got, want := len(all), 9; got != want {
+		t.Errorf("got %v, want %v: %v", got, want, all)
+	}
+	cp, err = cpr.LoadCheckpoint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.Done {
+		t.Errorf("walk is not complete: %#v", cp)
+	}
+}
+
+// linkFS adds support for following links to memfs.
+type linkFS struct {
+	*memfs.FS
+	targets map[string]string
+}
+
+func (fs *linkFS) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	if target, ok := fs.targets[path]; ok {
+		return fs.Stat(ctx, target)
+	}
+	return fs.Stat(ctx, path)
+}
+
+func (fs *linkFS) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	if info.Metadata == nil {
+		return 0, 0, false
+	}
+	return info.Device, info.Metadata.Inode, true
+}
+
+func TestResumeWithState(t *testing.T) {
+	mfs, err := memfs.Parse(`
+r/ dev=1 ino=1
+  d/ dev=1 ino=2
+    f0 1
+    up link
+  other/ dev=2 ino=3
+    f1 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &linkFS{FS: mfs, targets: map[string]string{"/r/d/up": "/r"}}
+	cpr := filewalk.FileCheckpointer(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	var mu sync.Mutex
+	var lines []string
+	var cancelAt string
+	var cancel func()
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%v: cycle: %v", prefix, errors.Is(err, filewalk.ErrLinkCycle)))
+			return true, nil, nil
+		}
+		lines = append(lines, prefix)
+		if prefix == cancelAt {
+			cancel()
+		}
+		return false, nil, nil
+	}
+	contentsFn := (&recorder{}).contentsFunc
+
+	// Interrupt a walk when it reaches /r/d, at which point /r/other is
+	// still pending.
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelAt, cancel = "/r/d", cancelFn
+	wk := filewalk.New(fs,
+		filewalk.Order(filewalk.DepthFirst),
+		filewalk.SameDevice(),
+		filewalk.FollowLinks(true),
+		filewalk.CheckpointTo(cpr, time.Hour))
+	if err := wk.Walk(ctx, prefixFn, contentsFn, "/r"); err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("missing or wrong error: %v", err)
+	}
+	cp, err := cpr.LoadCheckpoint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := filewalk.PrefixState{Device: 1, Ancestors: []filewalk.DeviceInode{{Device: 1, Inode: 1}}}
+	if got, want := cp.States, map[string]filewalk.PrefixState{"/r/d": state, "/r/other": state}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The resumed walk must skip /r/other since it is on a different device
+	// to /r and detect the cycle via /r/d/up.
+	lines, cancelAt = nil, ""
+	wk = filewalk.New(fs,
+		filewalk.SameDevice(),
+		filewalk.FollowLinks(true),
+		filewalk.ResumeFrom(cpr))
+	if err := wk.Walk(context.Background(), prefixFn, contentsFn, "/r"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	if got, want := lines, []string{"/r/d", "/r/d/up: cycle: true"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -25,6 +27,24 @@ type PrefixInfo struct {
 	Files     []Info
 	DiskUsage int64 // DiskUsage is the total amount of storage required for the files under this prefix taking the filesystem's layout/block size into account.
 	Err       string
+	Digests   []FileDigest // Digests of the contents of some or all of Files.
+}
+
+// FileDigest represents a digest, or checksum, of the contents of a single
+// file. The size and modification time of the file at the time that the
+// digest was computed are recorded so that stale digests can be detected.
+type FileDigest struct {
+	Name      string    // base name of the file
+	Size      int64     // size of the file when the digest was computed
+	ModTime   time.Time // modification time of the file when the digest was computed
+	Algorithm string    // name of the algorithm used, eg. sha256
+	Sum       []byte    // the digest itself
+}
+
+// Matches returns true if the digest was computed for a file with the
+// same name, size and modification time as info.
+func (fd FileDigest) Matches(info Info) bool {
+	return fd.Name == info.Name && fd.Size == info.Size && fd.ModTime.Equal(info.ModTime)
 }
 
 var bufPool = sync.Pool{
@@ -47,6 +67,46 @@ func gobEncodeInfo(enc *gob.Encoder, info []Info) error {
 	return errs.Err()
 }
 
+func gobEncodeDigests(enc *gob.Encoder, digests []FileDigest) error {
+	errs := errors.M{}
+	errs.Append(enc.Encode(len(digests)))
+	for _, d := range digests {
+		errs.Append(enc.Encode(d.Name))
+		errs.Append(enc.Encode(d.Size))
+		errs.Append(enc.Encode(d.ModTime))
+		errs.Append(enc.Encode(d.Algorithm))
+		errs.Append(enc.Encode(d.Sum))
+	}
+	return errs.Err()
+}
+
+// gobEncodeMetadata encodes the device ids and extended metadata, if any,
+// for info. They are encoded separately from the rest of Info to allow
+// for PrefixInfo's encoded before they were supported to be decoded.
+func gobEncodeMetadata(enc *gob.Encoder, info []Info) error {
+	errs := errors.M{}
+	errs.Append(enc.Encode(len(info)))
+	for _, i := range info {
+		errs.Append(enc.Encode(i.Device))
+		md := i.Metadata
+		errs.Append(enc.Encode(md != nil))
+		if md == nil {
+			continue
+		}
+		errs.Append(enc.Encode(md.Inode))
+		errs.Append(enc.Encode(md.Links))
+		errs.Append(enc.Encode(md.Blocks))
+		errs.Append(enc.Encode(md.AccessTime))
+		errs.Append(enc.Encode(md.ChangeTime))
+		errs.Append(enc.Encode(len(md.XAttrs)))
+		for k, v := range md.XAttrs {
+			errs.Append(enc.Encode(k))
+			errs.Append(enc.Encode(v))
+		}
+	}
+	return errs.Err()
+}
+
 // GobEncode implements gob.Encoder.
 func (pi PrefixInfo) GobEncode() ([]byte, error) {
 	b := bufPool.Get().(*bytes.Buffer)
@@ -62,6 +122,9 @@ func (pi PrefixInfo) GobEncode() ([]byte, error) {
 	errs.Append(enc.Encode(pi.Err))
 	errs.Append(gobEncodeInfo(enc, pi.Children))
 	errs.Append(gobEncodeInfo(enc, pi.Files))
+	errs.Append(gobEncodeDigests(enc, pi.Digests))
+	errs.Append(gobEncodeMetadata(enc, pi.Children))
+	errs.Append(gobEncodeMetadata(enc, pi.Files))
 	buf := make([]byte, len(b.Bytes()))
 	copy(buf, b.Bytes())
 	bufPool.Put(b)
@@ -87,6 +150,75 @@ func gobDecodeInfo(dec *gob.Decoder) ([]Info, error) {
 	return info, errs.Err()
 }
 
+// gobDecodeDigests decodes digests, if any; PrefixInfo's encoded before
+// digests were supported will have none.
+func gobDecodeDigests(dec *gob.Decoder) ([]FileDigest, error) {
+	var size int
+	if err := dec.Decode(&size); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	errs := errors.M{}
+	digests := make([]FileDigest, size)
+	for i := range digests {
+		errs.Append(dec.Decode(&digests[i].Name))
+		errs.Append(dec.Decode(&digests[i].Size))
+		errs.Append(dec.Decode(&digests[i].ModTime))
+		errs.Append(dec.Decode(&digests[i].Algorithm))
+		errs.Append(dec.Decode(&digests[i].Sum))
+	}
+	return digests, errs.Err()
+}
+
+// gobDecodeMetadata decodes the device ids and extended metadata, if any,
+// for info; PrefixInfo's encoded before they were supported will have none.
+func gobDecodeMetadata(dec *gob.Decoder, info []Info) error {
+	var size int
+	if err := dec.Decode(&size); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if size != len(info) {
+		return fmt.Errorf("metadata for %v entries, expected %v", size, len(info))
+	}
+	errs := errors.M{}
+	for i := range info {
+		errs.Append(dec.Decode(&info[i].Device))
+		var present bool
+		errs.Append(dec.Decode(&present))
+		if !present {
+			continue
+		}
+		md := &Metadata{}
+		errs.Append(dec.Decode(&md.Inode))
+		errs.Append(dec.Decode(&md.Links))
+		errs.Append(dec.Decode(&md.Blocks))
+		errs.Append(dec.Decode(&md.AccessTime))
+		errs.Append(dec.Decode(&md.ChangeTime))
+		var nattrs int
+		errs.Append(dec.Decode(&nattrs))
+		if nattrs > 0 {
+			md.XAttrs = make(map[string][]byte, nattrs)
+		}
+		for j := 0; j < nattrs; j++ {
+			var k string
+			var v []byte
+			errs.Append(dec.Decode(&k))
+			errs.Append(dec.Decode(&v))
+			md.XAttrs[k] = v
+		}
+		info[i].Metadata = md
+	}
+	return errs.Err()
+}
+
 // GobDecode implements gob.Decoder.
 func (pi *PrefixInfo) GobDecode(buf []byte) error {
 	dec := gob.NewDecoder(bytes.NewBuffer(buf))
@@ -103,6 +235,10 @@ func (pi *PrefixInfo) GobDecode(buf []byte) error {
 	errs.Append(err)
 	pi.Files, err = gobDecodeInfo(dec)
 	errs.Append(err)
+	pi.Digests, err = gobDecodeDigests(dec)
+	errs.Append(err)
+	errs.Append(gobDecodeMetadata(dec, pi.Children))
+	errs.Append(gobDecodeMetadata(dec, pi.Files))
 	return errs.Err()
 }
 
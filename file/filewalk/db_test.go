@@ -31,8 +31,21 @@ func TestCodec(t *testing.T) {
 		ModTime: now,
 		Mode:    0666,
 	}
-	pi.Files = []filewalk.Info{child, child}
-	pi.Children = []filewalk.Info{child, child}
+	extended := child
+	extended.Device = 7
+	extended.Metadata = &filewalk.Metadata{
+		Inode:      33,
+		Links:      2,
+		Blocks:     8,
+		AccessTime: now,
+		ChangeTime: now,
+		XAttrs:     map[string][]byte{"user.a": []byte("b"), "user.c": []byte("d")},
+	}
+	pi.Files = []filewalk.Info{child, extended}
+	pi.Children = []filewalk.Info{extended, child}
+	pi.Digests = []filewalk.FileDigest{
+		{Name: "file1", Size: 3444, ModTime: now, Algorithm: "sha256", Sum: []byte{1, 2, 3}},
+	}
 	buf := &bytes.Buffer{}
 	enc := gob.NewEncoder(buf)
 	if err := enc.Encode(pi); err != nil {
@@ -48,3 +61,32 @@ func TestCodec(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestCodecWithoutDigests(t *testing.T) {
+	// PrefixInfo's encoded before digests were supported lack the
+	// trailing digests.
+	buf := &bytes.Buffer{}
+	enc := gob.NewEncoder(buf)
+	now := time.Now().Round(0)
+	for _, v := range []interface{}{now, int64(1), "u", "g", filewalk.FileMode(0700), int64(2), "", 0, 0} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var pi filewalk.PrefixInfo
+	if err := pi.GobDecode(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pi, (filewalk.PrefixInfo{ModTime: now, Size: 1, UserID: "u", GroupID: "g", Mode: 0700, DiskUsage: 2, Children: []filewalk.Info{}, Files: []filewalk.Info{}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	info := filewalk.Info{Name: "f", Size: 1, ModTime: now}
+	digest := filewalk.FileDigest{Name: "f", Size: 1, ModTime: now}
+	if !digest.Matches(info) {
+		t.Errorf("digest should match")
+	}
+	info.Size++
+	if digest.Matches(info) {
+		t.Errorf("digest should not match")
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package dbfs provides a filewalk.Filesystem that is backed by the
+// PrefixInfo records stored in a filewalk.Database rather than by a live
+// filesystem. It allows for existing PrefixFunc and ContentsFunc
+// implementations to be used to analyze previously stored scans.
+package dbfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	separator string
+}
+
+// Separator sets the separator used by the filesystem whose scan is stored
+// in the database, as returned by filewalk.Separator for that filesystem.
+// It must be supplied explicitly since that filesystem need not be
+// available when the database is read. The default is "/".
+func Separator(sep string) Option {
+	return func(o *options) {
+		o.separator = sep
+	}
+}
+
+// FS is a filewalk.Filesystem backed by a filewalk.Database.
+type FS struct {
+	db   filewalk.Database
+	opts options
+}
+
+// New returns a new FS that reads from db.
+func New(db filewalk.Database, opts ...Option) *FS {
+	fs := &FS{db: db}
+	fs.opts.separator = "/"
+	for _, fn := range opts {
+		fn(&fs.opts)
+	}
+	return fs
+}
+
+func (fs *FS) split(p string) (string, string) {
+	idx := strings.LastIndex(p, fs.opts.separator)
+	if idx < 0 {
+		return "", p
+	}
+	parent := p[:idx]
+	if len(parent) == 0 {
+		parent = fs.opts.separator
+	}
+	return parent, p[idx+len(fs.opts.separator):]
+}
+
+func notExist(op, p string) error {
+	return &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+}
+
+// Stat implements filewalk.Filesystem. The Info for prefixes is obtained
+// from their own PrefixInfo records and that for files from the record
+// for the prefix that contains them.
+func (fs *FS) Stat(ctx context.Context, p string) (filewalk.Info, error) {
+	var pi filewalk.PrefixInfo
+	ok, err := fs.db.Get(ctx, p, &pi)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	if ok {
+		_, name := fs.split(p)
+		if len(name) == 0 {
+			name = p
+		}
+		return filewalk.Info{
+			Name:    name,
+			UserID:  pi.UserID,
+			GroupID: pi.GroupID,
+			Size:    pi.Size,
+			ModTime: pi.ModTime,
+			Mode:    pi.Mode | filewalk.ModePrefix,
+		}, nil
+	}
+	parent, name := fs.split(p)
+	if len(parent) == 0 || parent == p {
+		return filewalk.Info{}, notExist("stat", p)
+	}
+	ok, err = fs.db.Get(ctx, parent, &pi)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	if ok {
+		for _, entries := range [][]filewalk.Info{pi.Files, pi.Children} {
+			for _, info := range entries {
+				if info.Name == name {
+					return info, nil
+				}
+			}
+		}
+	}
+	return filewalk.Info{}, notExist("stat", p)
+}
+
+// List implements filewalk.Filesystem. The stored Children and Files
+// are returned as a single Contents. If the stored record has an error
+// recorded for it then that error is returned in a subsequent Contents.
+func (fs *FS) List(ctx context.Context, p string, ch chan<- filewalk.Contents) {
+	var pi filewalk.PrefixInfo
+	ok, err := fs.db.Get(ctx, p, &pi)
+	if err == nil && !ok {
+		err = notExist("list", p)
+	}
+	if err != nil {
+		ch <- filewalk.Contents{Path: p, Err: err}
+		return
+	}
+	if len(pi.Children) > 0 || len(pi.Files) > 0 {
+		ch <- filewalk.Contents{Path: p, Children: pi.Children, Files: pi.Files}
+	}
+	if len(pi.Err) > 0 {
+		ch <- filewalk.Contents{Path: p, Err: errors.New(pi.Err)}
+	}
+}
+
+// Join implements filewalk.Filesystem.
+func (fs *FS) Join(components ...string) string {
+	if fs.opts.separator == "/" {
+		return path.Join(components...)
+	}
+	return strings.Join(components, fs.opts.separator)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (fs *FS) IsPermissionError(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (fs *FS) IsNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
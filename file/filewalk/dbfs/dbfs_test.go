@@ -0,0 +1,135 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package dbfs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/dbfs"
+	"cloudeng.io/file/filewalk/incremental"
+	"cloudeng.io/file/filewalk/localdb"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+const tree = `
+a0/
+  f0 size=10
+  a0.0/
+    f1 size=20
+b0/
+  b0.0/
+    f2 size=30
+  b0.1/
+c0/
+  f3 size=40
+`
+
+// walk walks fs and returns a sorted log of the prefixes and files
+// encountered and of any listing errors.
+func walk(t *testing.T, fs filewalk.Filesystem) []string {
+	var mu sync.Mutex
+	var lines []string
+	log := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		if err != nil {
+			return true, nil, err
+		}
+		log("%v* %v", prefix, info.IsPrefix())
+		return false, nil, nil
+	}
+	contentsFn := func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+		var children []filewalk.Info
+		for c := range ch {
+			if c.Err != nil {
+				log("%v: error", prefix)
+				continue
+			}
+			for _, f := range c.Files {
+				log("%v: %v", fs.Join(prefix, f.Name), f.Size)
+			}
+			children = append(children, c.Children...)
+		}
+		return children, nil
+	}
+	if err := filewalk.New(fs).Walk(context.Background(), prefixFn, contentsFn, "/"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestDBFS(t *testing.T) {
+	ctx := context.Background()
+	mfs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.SetError(memfs.List, "/c0", errors.New("oops"))
+	db, err := localdb.Open(ctx, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+	// The listing error for /c0 is stored in its PrefixInfo.
+	u := incremental.New(mfs, db)
+	if err := filewalk.New(mfs).Walk(ctx, u.PrefixFunc, u.ContentsFunc, "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := dbfs.New(db)
+	if got, want := walk(t, fs), walk(t, mfs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		path   string
+		name   string
+		size   int64
+		prefix bool
+	}{
+		{"/", "/", 0, true},
+		{"/a0", "a0", 0, true},
+		{"/b0/b0.1", "b0.1", 0, true},
+		{"/a0/f0", "f0", 10, false},
+		{"/b0/b0.0/f2", "f2", 30, false},
+	} {
+		info, err := fs.Stat(ctx, tc.path)
+		if err != nil {
+			t.Errorf("%v: %v", tc.path, err)
+			continue
+		}
+		if got, want := info.Name, tc.name; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+		if got, want := info.Size, tc.size; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+		if got, want := info.IsPrefix(), tc.prefix; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+
+	for _, p := range []string{"/x", "/a0/x", "/x/y"} {
+		if _, err := fs.Stat(ctx, p); !fs.IsNotExist(err) {
+			t.Errorf("%v: expected a not-exist error: %v", p, err)
+		}
+	}
+	ch := make(chan filewalk.Contents, 1)
+	fs.List(ctx, "/a0/f0", ch)
+	if c := <-ch; !fs.IsNotExist(c.Err) {
+		t.Errorf("expected a not-exist error: %v", c.Err)
+	}
+}
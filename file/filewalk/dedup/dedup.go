@@ -0,0 +1,468 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package dedup provides support for finding duplicate files. Candidate
+// duplicates are found by first grouping files by size, then by a digest
+// of their first and last blocks and finally by a digest of their entire
+// contents. Only files that remain candidates after each stage are read
+// further so that, typically, only a small fraction of the files
+// encountered need to be read in their entirety.
+//
+// Files that are hard links to the same underlying file, that is, that
+// share the same device and inode numbers, are considered only once since
+// removing any of them would not reclaim any space.
+//
+// The digests of entire files may be stored in, and reused from, a
+// filewalk.Database so that subsequent runs need only read those files
+// that have been created or modified since.
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/digest"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	blockSize   int64
+	minSize     int64
+	concurrency int
+	algorithm   string
+	newHash     func() hash.Hash
+	db          filewalk.Database
+	walkerOpts  []filewalk.Option
+}
+
+// BlockSize sets the size of the first and last blocks of each file that
+// are used for the partial digest. The default is 4KiB.
+func BlockSize(n int64) Option {
+	return func(o *options) {
+		o.blockSize = n
+	}
+}
+
+// MinSize sets the size of the smallest file to be considered, the
+// default is 1 so that empty files are ignored.
+func MinSize(n int64) Option {
+	return func(o *options) {
+		o.minSize = n
+	}
+}
+
+// Concurrency sets the maximum number of files that will be read
+// concurrently. The default is the number of available CPUs.
+func Concurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// Algorithm sets the hash algorithm used for digests, see
+// digest.Algorithm. The default is SHA-256. Since files are reported as
+// duplicates purely on the basis of their digests a cryptographic hash
+// should generally be used.
+func Algorithm(name string, fn func() hash.Hash) Option {
+	return func(o *options) {
+		o.algorithm = name
+		o.newHash = fn
+	}
+}
+
+// Database requests that digests be reused from, and stored in, the
+// specified database. Digests are reused only if they were computed with
+// the same algorithm for a file with the same size and modification time.
+// The database is used solely to store digests, that is, the PrefixInfo
+// stored for each prefix contains only the Digests of its files, and hence
+// it must not be shared with a database used for other purposes, such as
+// one maintained by cloudeng.io/file/filewalk/incremental.
+func Database(db filewalk.Database) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// WalkerOptions sets the options to be used for the filewalk.Walker used
+// to find files.
+func WalkerOptions(opts ...filewalk.Option) Option {
+	return func(o *options) {
+		o.walkerOpts = opts
+	}
+}
+
+// Set represents a set of identical files.
+type Set struct {
+	Size  int64    // Size of each file.
+	Sum   []byte   // Digest of each file.
+	Paths []string // Paths of all of the files, in lexicographic order.
+}
+
+// Reclaimable returns the number of bytes that would be reclaimed by
+// removing all but one of the files in the set.
+func (s Set) Reclaimable() int64 {
+	return s.Size * int64(len(s.Paths)-1)
+}
+
+// Stats records the work performed by Find.
+type Stats struct {
+	Files         int64 // Number of files considered.
+	PartialHashes int64 // Number of files for which a partial digest was computed.
+	FullHashes    int64 // Number of files for which a full digest was computed.
+	Reused        int64 // Number of full digests reused from the database.
+	HardLinks     int64 // Number of files ignored as being hard links to files already considered.
+}
+
+// Result represents the outcome of a call to Find.
+type Result struct {
+	Sets        []Set // Sets of duplicates in decreasing order of reclaimable bytes.
+	Reclaimable int64 // Total reclaimable bytes across all Sets.
+	Stats       Stats
+}
+
+// Finder finds duplicate files.
+type Finder struct {
+	opts    options
+	fs      filewalk.Filesystem
+	rd      filewalk.Reader
+	hasher  *digest.Hasher
+	limitCh chan struct{}
+}
+
+// New returns a Finder for fs, which must implement filewalk.Reader.
+func New(fs filewalk.Filesystem, opts ...Option) (*Finder, error) {
+	f := &Finder{fs: fs}
+	f.opts.blockSize = 4096
+	f.opts.minSize = 1
+	f.opts.algorithm = "sha256"
+	f.opts.newHash = sha256.New
+	for _, fn := range opts {
+		fn(&f.opts)
+	}
+	if f.opts.concurrency <= 0 {
+		f.opts.concurrency = runtime.GOMAXPROCS(-1)
+	}
+	rd, ok := fs.(filewalk.Reader)
+	if !ok {
+		return nil, fmt.Errorf("filesystem does not support reading files")
+	}
+	f.rd = rd
+	hasher, err := digest.New(fs,
+		digest.Algorithm(f.opts.algorithm, f.opts.newHash),
+		digest.Concurrency(f.opts.concurrency))
+	if err != nil {
+		return nil, err
+	}
+	f.hasher = hasher
+	f.limitCh = make(chan struct{}, f.opts.concurrency)
+	return f, nil
+}
+
+// prefix records the contents of a single prefix.
+type prefix struct {
+	name     string
+	files    []filewalk.Info
+	stored   map[string]filewalk.FileDigest
+	computed []filewalk.FileDigest
+}
+
+// file represents a single candidate file.
+type file struct {
+	prefix  *prefix
+	info    filewalk.Info
+	path    string
+	partial []byte
+	full    []byte
+}
+
+type finder struct {
+	*Finder
+	mu       sync.Mutex
+	prefixes []*prefix
+	errs     *errors.M
+	stats    Stats
+}
+
+// Find walks the specified roots and returns all of the sets of duplicate
+// files found. Information on every file encountered is retained in
+// memory until Find returns. Errors encountered whilst walking or reading
+// files are returned as an errors.M, along with the sets of duplicates
+// found amongst those files that could be read.
+func (f *Finder) Find(ctx context.Context, roots ...string) (*Result, error) {
+	fd := &finder{Finder: f, errs: &errors.M{}}
+	walker := filewalk.New(f.fs, f.opts.walkerOpts...)
+	if err := walker.Walk(ctx, fd.prefixFunc, fd.contentsFunc, roots...); err != nil {
+		fd.errs.Append(err)
+		return nil, fd.errs.Err()
+	}
+	bySize := map[int64][]*file{}
+	for _, fl := range fd.candidates() {
+		bySize[fl.info.Size] = append(bySize[fl.info.Size], fl)
+		fd.stats.Files++
+	}
+	var sets []Set
+	for size, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+		sets = append(sets, fd.sameSize(ctx, size, files)...)
+	}
+	if ctx.Err() != nil {
+		fd.errs.Append(ctx.Err())
+		return nil, fd.errs.Err()
+	}
+	if f.opts.db != nil {
+		fd.errs.Append(fd.store(ctx))
+	}
+	res := &Result{Sets: sets, Stats: fd.stats}
+	sort.Slice(res.Sets, func(i, j int) bool {
+		ri, rj := res.Sets[i].Reclaimable(), res.Sets[j].Reclaimable()
+		if ri != rj {
+			return ri > rj
+		}
+		return res.Sets[i].Paths[0] < res.Sets[j].Paths[0]
+	})
+	for _, s := range res.Sets {
+		res.Reclaimable += s.Reclaimable()
+	}
+	return res, fd.errs.Err()
+}
+
+type fileID struct {
+	device, inode uint64
+}
+
+// candidates returns the files to be considered, in lexicographic order,
+// with all but the first of any set of hard links to the same file being
+// ignored.
+func (fd *finder) candidates() []*file {
+	var files []*file
+	for _, p := range fd.prefixes {
+		for _, info := range p.files {
+			if info.IsLink() || info.IsPrefix() || info.Size < fd.opts.minSize {
+				continue
+			}
+			fl := &file{prefix: p, info: info, path: fd.fs.Join(p.name, info.Name)}
+			if d, ok := p.stored[info.Name]; ok {
+				fl.full = d.Sum
+			}
+			files = append(files, fl)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	seen := map[fileID]bool{}
+	unique := files[:0]
+	for _, fl := range files {
+		if md := fl.info.Metadata; md != nil && md.Links >= 2 {
+			id := fileID{device: fl.info.Device, inode: md.Inode}
+			if seen[id] {
+				fd.stats.HardLinks++
+				continue
+			}
+			seen[id] = true
+		}
+		unique = append(unique, fl)
+	}
+	return unique
+}
+
+func (fd *finder) prefixFunc(ctx context.Context, name string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+	if err != nil {
+		fd.errs.Append(err)
+		return true, nil, nil
+	}
+	return false, nil, nil
+}
+
+func (fd *finder) contentsFunc(ctx context.Context, name string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+	p := &prefix{name: name}
+	var children []filewalk.Info
+	for c := range ch {
+		if c.Err != nil {
+			fd.errs.Append(c.Err)
+			continue
+		}
+		children = append(children, c.Children...)
+		p.files = append(p.files, c.Files...)
+	}
+	if db := fd.opts.db; db != nil {
+		var pi filewalk.PrefixInfo
+		ok, err := db.Get(ctx, name, &pi)
+		if err != nil {
+			fd.errs.Append(err)
+		}
+		if ok {
+			p.stored = fd.reusable(pi.Digests, p.files)
+		}
+	}
+	fd.mu.Lock()
+	fd.prefixes = append(fd.prefixes, p)
+	fd.mu.Unlock()
+	return children, nil
+}
+
+// reusable returns those digests that are still valid for files.
+func (fd *finder) reusable(digests []filewalk.FileDigest, files []filewalk.Info) map[string]filewalk.FileDigest {
+	current := make(map[string]filewalk.Info, len(files))
+	for _, f := range files {
+		current[f.Name] = f
+	}
+	valid := map[string]filewalk.FileDigest{}
+	for _, d := range digests {
+		if info, ok := current[d.Name]; ok && d.Algorithm == fd.opts.algorithm && d.Matches(info) {
+			valid[d.Name] = d
+		}
+	}
+	return valid
+}
+
+// sameSize finds the duplicates amongst files all of the same size. Files
+// whose full digests were obtained from the database skip the partial
+// digest stage, but their presence requires that all partial digest
+// groups, even those with a single member, be fully hashed.
+func (fd *finder) sameSize(ctx context.Context, size int64, files []*file) []Set {
+	var known, unknown []*file
+	for _, fl := range files {
+		if fl.full != nil {
+			known = append(known, fl)
+			fd.stats.Reused++
+			continue
+		}
+		unknown = append(unknown, fl)
+	}
+	var toHash []*file
+	if size <= 2*fd.opts.blockSize {
+		// The partial digest would require reading the entire file.
+		toHash = unknown
+	} else {
+		fd.forEach(ctx, unknown, fd.partialDigest)
+		groups := map[string][]*file{}
+		for _, fl := range unknown {
+			if fl.partial != nil {
+				groups[string(fl.partial)] = append(groups[string(fl.partial)], fl)
+			}
+		}
+		for _, group := range groups {
+			if len(group) > 1 || len(known) > 0 {
+				toHash = append(toHash, group...)
+			}
+		}
+	}
+	fd.forEach(ctx, toHash, fd.fullDigest)
+	bySum := map[string][]*file{}
+	for _, fl := range files {
+		if fl.full != nil {
+			bySum[string(fl.full)] = append(bySum[string(fl.full)], fl)
+		}
+	}
+	var sets []Set
+	for _, group := range bySum {
+		if len(group) < 2 {
+			continue
+		}
+		set := Set{Size: size, Sum: group[0].full}
+		for _, fl := range group {
+			set.Paths = append(set.Paths, fl.path)
+		}
+		sort.Strings(set.Paths)
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+func (fd *finder) forEach(ctx context.Context, files []*file, fn func(context.Context, *file) error) {
+	var wg sync.WaitGroup
+	for _, fl := range files {
+		select {
+		case fd.limitCh <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(fl *file) {
+			defer wg.Done()
+			defer func() { <-fd.limitCh }()
+			if err := fn(ctx, fl); err != nil {
+				fd.errs.Append(&filewalk.Error{Path: fl.path, Op: "digest", Err: err})
+			}
+		}(fl)
+	}
+	wg.Wait()
+}
+
+// partialDigest computes a digest of the first and last blocks of a file.
+func (fd *finder) partialDigest(ctx context.Context, fl *file) error {
+	rc, err := fd.rd.Open(ctx, fl.path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	h := fd.opts.newHash()
+	bs := fd.opts.blockSize
+	if _, err := io.CopyN(h, rc, bs); err != nil {
+		return err
+	}
+	offset := fl.info.Size - bs
+	if s, ok := rc.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, rc, offset-bs)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(h, rc, bs); err != nil {
+		return err
+	}
+	fl.partial = h.Sum(nil)
+	atomic.AddInt64(&fd.stats.PartialHashes, 1)
+	return nil
+}
+
+func (fd *finder) fullDigest(ctx context.Context, fl *file) error {
+	d, err := fd.hasher.Digest(ctx, fl.path, fl.info)
+	if err != nil {
+		return err
+	}
+	fl.full = d.Sum
+	atomic.AddInt64(&fd.stats.FullHashes, 1)
+	fd.mu.Lock()
+	fl.prefix.computed = append(fl.prefix.computed, d)
+	fd.mu.Unlock()
+	return nil
+}
+
+// store records newly computed digests, along with those that are still
+// valid, in the database.
+func (fd *finder) store(ctx context.Context) error {
+	errs := &errors.M{}
+	for _, p := range fd.prefixes {
+		if len(p.computed) == 0 {
+			continue
+		}
+		digests := make([]filewalk.FileDigest, 0, len(p.stored)+len(p.computed))
+		for _, d := range p.stored {
+			digests = append(digests, d)
+		}
+		digests = append(digests, p.computed...)
+		sort.Slice(digests, func(i, j int) bool {
+			return digests[i].Name < digests[j].Name
+		})
+		errs.Append(fd.opts.db.Set(ctx, p.name, &filewalk.PrefixInfo{Digests: digests}))
+	}
+	return errs.Err()
+}
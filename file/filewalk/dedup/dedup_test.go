@@ -0,0 +1,197 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package dedup_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/dedup"
+	"cloudeng.io/file/filewalk/localdb"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+// With a block size of 2, f1, f2, f3 and f5 share the same partial digest,
+// f4 differs in its partial digest and s1 and s2 are too small to require
+// one.
+const tree = `
+a/
+  f1 data=abcdefgh
+  f2 data=abcdefgh
+  f3 data=abcXYZgh
+  f4 data=zzzzzzzz
+  s1 data=xyz
+b/
+  f5 data=abcdefgh
+  s2 data=xyz
+  u1 data=unique
+  e1 data=
+  e2 data=
+  l1 link
+`
+
+func summarize(res *dedup.Result) []string {
+	var out []string
+	for _, s := range res.Sets {
+		out = append(out, strings.Join(s.Paths, ","))
+	}
+	return out
+}
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finder, err := dedup.New(fs, dedup.BlockSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := finder.Find(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summarize(res), []string{"/a/f1,/a/f2,/b/f5", "/a/s1,/b/s2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := res.Reclaimable, int64(16+3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	sum := sha256.Sum256([]byte("abcdefgh"))
+	if got, want := res.Sets[0].Sum, sum[:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+	if got, want := res.Stats, (dedup.Stats{Files: 8, PartialHashes: 5, FullHashes: 6}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Empty files are duplicates of each other when MinSize is 0.
+	finder, _ = dedup.New(fs, dedup.BlockSize(2), dedup.MinSize(0))
+	res, err = finder.Find(ctx, "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summarize(res), []string{"/b/e1,/b/e2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Files that cannot be read are reported as errors and excluded.
+	fs.SetError(memfs.Open, "/a/f2", os.ErrPermission)
+	finder, _ = dedup.New(fs, dedup.BlockSize(2))
+	res, err = finder.Find(ctx, "/")
+	if err == nil || !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if got, want := summarize(res), []string{"/a/f1,/b/f5", "/a/s1,/b/s2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := dedup.New(struct{ filewalk.Filesystem }{fs}); err == nil {
+		t.Errorf("expected an error for a filesystem that does not support Open")
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	ctx := context.Background()
+	// h1 and h2 are hard links to the same file, h3 is on a different
+	// device and hence is a distinct file.
+	fs, err := memfs.Parse(`
+a/
+  f1 data=abcdefgh
+  h1 data=abcdefgh ino=7 links=2
+b/
+  h2 data=abcdefgh ino=7 links=2
+  h3 data=abcdefgh ino=7 links=2 dev=2
+c/
+  h4 data=xyz ino=8 links=2
+  h5 data=xyz ino=8 links=2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finder, err := dedup.New(fs, dedup.BlockSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := finder.Find(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summarize(res), []string{"/a/f1,/a/h1,/b/h3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := res.Stats, (dedup.Stats{Files: 4, PartialHashes: 3, FullHashes: 3, HardLinks: 2}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := localdb.Open(ctx, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+	finder, err := dedup.New(fs, dedup.BlockSize(2), dedup.Database(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(stats dedup.Stats, sets ...string) {
+		t.Helper()
+		res, err := finder.Find(ctx, "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := summarize(res), sets; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := res.Stats, stats; got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	find(dedup.Stats{Files: 8, PartialHashes: 5, FullHashes: 6},
+		"/a/f1,/a/f2,/b/f5", "/a/s1,/b/s2")
+
+	// f4 must now be fully hashed since it may match one of the files whose
+	// digest is reused.
+	find(dedup.Stats{Files: 8, PartialHashes: 1, FullHashes: 1, Reused: 6},
+		"/a/f1,/a/f2,/b/f5", "/a/s1,/b/s2")
+
+	find(dedup.Stats{Files: 8, Reused: 7},
+		"/a/f1,/a/f2,/b/f5", "/a/s1,/b/s2")
+
+	var pi filewalk.PrefixInfo
+	if ok, err := db.Get(ctx, "/a", &pi); !ok || err != nil {
+		t.Fatalf("missing entry: %v", err)
+	}
+	if got, want := len(pi.Digests), 5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Only the digests are stored.
+	if len(pi.Children) != 0 || len(pi.Files) != 0 || !pi.ModTime.IsZero() {
+		t.Errorf("unexpected entry: %+v", pi)
+	}
+
+	// Modifying a file invalidates its stored digest.
+	if err := fs.AddFile("/a/f3", filewalk.Info{ModTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetContents("/a/f3", []byte("abcdefgh")); err != nil {
+		t.Fatal(err)
+	}
+	find(dedup.Stats{Files: 8, PartialHashes: 1, FullHashes: 1, Reused: 6},
+		"/a/f1,/a/f2,/a/f3,/b/f5", "/a/s1,/b/s2")
+}
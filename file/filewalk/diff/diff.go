@@ -0,0 +1,294 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package diff provides support for determining the differences between
+// two snapshots of a filesystem as stored in instances of filewalk.Database,
+// for example, the results of scans run on consecutive nights. The snapshots
+// are compared by a merge-join of their keys, which are scanned in
+// ascending order, and hence memory usage is independent of the size of
+// the snapshots other than for the aggregate totals maintained per user
+// and per prefix.
+package diff
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"cloudeng.io/algo/container/heap"
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+)
+
+// ChangeType identifies the type of a Change.
+type ChangeType int
+
+const (
+	// PrefixAdded is reported for a prefix that appears only in the newer
+	// snapshot; it is followed by a FileAdded change for each of its files.
+	PrefixAdded ChangeType = iota
+	// PrefixRemoved is reported for a prefix that appears only in the older
+	// snapshot; it is followed by a FileRemoved change for each of its files.
+	PrefixRemoved
+	// FileAdded is reported for a file that appears only in the newer snapshot.
+	FileAdded
+	// FileRemoved is reported for a file that appears only in the older snapshot.
+	FileRemoved
+	// FileResized is reported for a file whose size differs between the
+	// two snapshots.
+	FileResized
+)
+
+// String implements stringer.
+func (ct ChangeType) String() string {
+	switch ct {
+	case PrefixAdded:
+		return "prefix-added"
+	case PrefixRemoved:
+		return "prefix-removed"
+	case FileAdded:
+		return "file-added"
+	case FileRemoved:
+		return "file-removed"
+	case FileResized:
+		return "file-resized"
+	}
+	return fmt.Sprintf("unknown change type: %d", int(ct))
+}
+
+// Change represents a single difference between two snapshots.
+type Change struct {
+	Type    ChangeType
+	Prefix  string
+	Name    string // Name of the file, empty for prefix changes.
+	UserID  string // Owner of the file or prefix.
+	OldSize int64  // Size of the file in the older snapshot.
+	NewSize int64  // Size of the file in the newer snapshot.
+}
+
+// Delta returns the change in size represented by c.
+func (c Change) Delta() int64 {
+	return c.NewSize - c.OldSize
+}
+
+// Delta represents the number of files and bytes added and removed.
+// Files that grow contribute to BytesAdded and those that shrink to
+// BytesRemoved.
+type Delta struct {
+	FilesAdded   int64
+	FilesRemoved int64
+	BytesAdded   int64
+	BytesRemoved int64
+}
+
+// Net returns the net change in bytes.
+func (d Delta) Net() int64 {
+	return d.BytesAdded - d.BytesRemoved
+}
+
+func (d *Delta) update(c Change) {
+	switch c.Type {
+	case FileAdded:
+		d.FilesAdded++
+	case FileRemoved:
+		d.FilesRemoved++
+	}
+	if delta := c.Delta(); delta > 0 {
+		d.BytesAdded += delta
+	} else {
+		d.BytesRemoved -= delta
+	}
+}
+
+// Totals represents the aggregate of all of the changes between two
+// snapshots.
+type Totals struct {
+	Delta
+	PrefixesAdded   int64
+	PrefixesRemoved int64
+	FilesResized    int64
+	Users           map[string]Delta  // Changes per user.
+	Growth          []filewalk.Metric // Prefixes with the largest net growth, in decreasing order.
+}
+
+// Option represents an option accepted by Scan and Databases.
+type Option func(o *options)
+
+type options struct {
+	topN int
+}
+
+// TopN sets the number of prefixes to be reported in Totals.Growth, the
+// default is 10.
+func TopN(n int) Option {
+	return func(o *options) {
+		o.topN = n
+	}
+}
+
+// ChangeFunc is called for every change found. Returning an error
+// terminates the comparison.
+type ChangeFunc func(ctx context.Context, change Change) error
+
+type differ struct {
+	fn     ChangeFunc
+	totals Totals
+	users  map[string]*Delta
+	growth *heap.KeyedInt64
+}
+
+// Databases compares the contents of two databases, starting at prefix,
+// which may be empty to compare their entire contents.
+func Databases(ctx context.Context, from, to filewalk.Database, prefix string, fn ChangeFunc, opts ...Option) (Totals, error) {
+	return Scan(ctx, from.NewScanner(prefix, 0), to.NewScanner(prefix, 0), fn, opts...)
+}
+
+// Scan compares the contents of two scanners, which must scan their keys
+// in ascending order, calling fn for every change found, and returns the
+// totals for those changes.
+func Scan(ctx context.Context, from, to filewalk.DatabaseScanner, fn ChangeFunc, opts ...Option) (Totals, error) {
+	o := options{topN: 10}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	d := &differ{
+		fn:     fn,
+		users:  map[string]*Delta{},
+		growth: heap.NewKeyedInt64(heap.Descending),
+	}
+	err := d.mergeJoin(ctx, from, to)
+	d.totals.Users = make(map[string]Delta, len(d.users))
+	for user, delta := range d.users {
+		d.totals.Users[user] = *delta
+	}
+	for _, m := range d.growth.TopN(o.topN) {
+		if m.V <= 0 {
+			break
+		}
+		d.totals.Growth = append(d.totals.Growth, filewalk.Metric{Prefix: m.K, Value: m.V})
+	}
+	return d.totals, err
+}
+
+func (d *differ) mergeJoin(ctx context.Context, from, to filewalk.DatabaseScanner) error {
+	fromOK, toOK := from.Scan(ctx), to.Scan(ctx)
+	for fromOK || toOK {
+		var err error
+		switch {
+		case !toOK:
+			p, pi := from.PrefixInfo()
+			err = d.prefix(ctx, PrefixRemoved, p, pi)
+			fromOK = from.Scan(ctx)
+		case !fromOK:
+			p, pi := to.PrefixInfo()
+			err = d.prefix(ctx, PrefixAdded, p, pi)
+			toOK = to.Scan(ctx)
+		default:
+			fp, fpi := from.PrefixInfo()
+			tp, tpi := to.PrefixInfo()
+			switch {
+			case fp < tp:
+				err = d.prefix(ctx, PrefixRemoved, fp, fpi)
+				fromOK = from.Scan(ctx)
+			case fp > tp:
+				err = d.prefix(ctx, PrefixAdded, tp, tpi)
+				toOK = to.Scan(ctx)
+			default:
+				err = d.files(ctx, fp, fpi, tpi)
+				fromOK, toOK = from.Scan(ctx), to.Scan(ctx)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	errs := &errors.M{}
+	errs.Append(from.Err())
+	errs.Append(to.Err())
+	return errs.Err()
+}
+
+func userID(pi *filewalk.PrefixInfo, file filewalk.Info) string {
+	if len(file.UserID) > 0 {
+		return file.UserID
+	}
+	return pi.UserID
+}
+
+func (d *differ) report(ctx context.Context, c Change) error {
+	switch c.Type {
+	case PrefixAdded:
+		d.totals.PrefixesAdded++
+		return d.fn(ctx, c)
+	case PrefixRemoved:
+		d.totals.PrefixesRemoved++
+		return d.fn(ctx, c)
+	case FileResized:
+		d.totals.FilesResized++
+	}
+	d.totals.update(c)
+	user := d.users[c.UserID]
+	if user == nil {
+		user = &Delta{}
+		d.users[c.UserID] = user
+	}
+	user.update(c)
+	return d.fn(ctx, c)
+}
+
+func (d *differ) prefix(ctx context.Context, ct ChangeType, prefix string, pi *filewalk.PrefixInfo) error {
+	if err := d.report(ctx, Change{Type: ct, Prefix: prefix, UserID: pi.UserID}); err != nil {
+		return err
+	}
+	var growth int64
+	for _, f := range pi.Files {
+		c := Change{Prefix: prefix, Name: f.Name, UserID: userID(pi, f)}
+		if ct == PrefixAdded {
+			c.Type, c.NewSize = FileAdded, f.Size
+		} else {
+			c.Type, c.OldSize = FileRemoved, f.Size
+		}
+		if err := d.report(ctx, c); err != nil {
+			return err
+		}
+		growth += c.Delta()
+	}
+	d.growth.Update(prefix, growth)
+	return nil
+}
+
+func (d *differ) files(ctx context.Context, prefix string, from, to *filewalk.PrefixInfo) error {
+	old := make(map[string]filewalk.Info, len(from.Files))
+	for _, f := range from.Files {
+		old[f.Name] = f
+	}
+	var changes []Change
+	for _, f := range to.Files {
+		prev, ok := old[f.Name]
+		delete(old, f.Name)
+		switch {
+		case !ok:
+			changes = append(changes, Change{Type: FileAdded, Prefix: prefix, Name: f.Name, UserID: userID(to, f), NewSize: f.Size})
+		case prev.Size != f.Size:
+			changes = append(changes, Change{Type: FileResized, Prefix: prefix, Name: f.Name, UserID: userID(to, f), OldSize: prev.Size, NewSize: f.Size})
+		}
+	}
+	for _, f := range old {
+		changes = append(changes, Change{Type: FileRemoved, Prefix: prefix, Name: f.Name, UserID: userID(from, f), OldSize: f.Size})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	var growth int64
+	for _, c := range changes {
+		if err := d.report(ctx, c); err != nil {
+			return err
+		}
+		growth += c.Delta()
+	}
+	if growth != 0 {
+		d.growth.Update(prefix, growth)
+	}
+	return nil
+}
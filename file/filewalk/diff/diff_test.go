@@ -0,0 +1,129 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package diff_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/diff"
+	"cloudeng.io/file/filewalk/localdb"
+)
+
+func files(userID string, nameSizes ...interface{}) []filewalk.Info {
+	var infos []filewalk.Info
+	for i := 0; i < len(nameSizes); i += 2 {
+		infos = append(infos, filewalk.Info{
+			Name:   nameSizes[i].(string),
+			Size:   int64(nameSizes[i+1].(int)),
+			UserID: userID,
+		})
+	}
+	return infos
+}
+
+func populate(ctx context.Context, t *testing.T, entries map[string]filewalk.PrefixInfo) filewalk.Database {
+	db, err := localdb.Open(ctx, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for prefix, pi := range entries {
+		pi := pi
+		if err := db.Set(ctx, prefix, &pi); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	from := populate(ctx, t, map[string]filewalk.PrefixInfo{
+		"/a":   {UserID: "u1", Files: files("u1", "f1", 10, "f2", 20, "f3", 30)},
+		"/a/b": {UserID: "u1", Files: files("u1", "f4", 40)},
+		"/c":   {UserID: "u2", Files: files("u2", "f5", 50)},
+	})
+	defer from.Close(ctx)
+	to := populate(ctx, t, map[string]filewalk.PrefixInfo{
+		"/a":   {UserID: "u1", Files: files("u1", "f1", 10, "f2", 25, "f6", 60)},
+		"/a/b": {UserID: "u1", Files: files("u1", "f4", 40)},
+		"/b":   {UserID: "u2", Files: files("u2", "f7", 70, "f8", 80)},
+	})
+	defer to.Close(ctx)
+
+	var changes []string
+	totals, err := diff.Databases(ctx, from, to, "", func(ctx context.Context, c diff.Change) error {
+		changes = append(changes, fmt.Sprintf("%v %v %v %v %+d", c.Type, c.Prefix, c.Name, c.UserID, c.Delta()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := changes, []string{
+		"file-resized /a f2 u1 +5",
+		"file-removed /a f3 u1 -30",
+		"file-added /a f6 u1 +60",
+		"prefix-added /b  u2 +0",
+		"file-added /b f7 u2 +70",
+		"file-added /b f8 u2 +80",
+		"prefix-removed /c  u2 +0",
+		"file-removed /c f5 u2 -50",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got, want := totals.Delta, (diff.Delta{FilesAdded: 3, FilesRemoved: 2, BytesAdded: 215, BytesRemoved: 80}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := totals.Net(), int64(135); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := [3]int64{totals.PrefixesAdded, totals.PrefixesRemoved, totals.FilesResized}, [3]int64{1, 1, 1}; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := totals.Users, map[string]diff.Delta{
+		"u1": {FilesAdded: 1, FilesRemoved: 1, BytesAdded: 65, BytesRemoved: 30},
+		"u2": {FilesAdded: 2, FilesRemoved: 1, BytesAdded: 150, BytesRemoved: 50},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := totals.Growth, []filewalk.Metric{{Prefix: "/b", Value: 150}, {Prefix: "/a", Value: 35}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	totals, err = diff.Databases(ctx, from, to, "", func(ctx context.Context, c diff.Change) error { return nil }, diff.TopN(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(totals.Growth), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Identical snapshots have no differences.
+	totals, err = diff.Databases(ctx, from, from, "", func(ctx context.Context, c diff.Change) error {
+		t.Errorf("unexpected change: %v", c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := totals.Net(), int64(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// An error returned by the ChangeFunc terminates the comparison.
+	stop := fmt.Errorf("stop")
+	n := 0
+	_, err = diff.Databases(ctx, from, to, "", func(ctx context.Context, c diff.Change) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("got %v, %v, want %v, 1", err, n, stop)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package digest provides support for computing digests, or checksums, of
+// the contents of the files encountered during a walk. Digests are computed
+// by a bounded pool of workers and are returned as filewalk.FileDigest
+// records which may be stored in a filewalk.PrefixInfo. Any hash.Hash may
+// be used; the default is SHA-256.
+package digest
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"runtime"
+	"sync"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	algorithm   string
+	newHash     func() hash.Hash
+	concurrency int
+}
+
+// Algorithm sets the hash algorithm to be used and the name to be recorded
+// for it in each filewalk.FileDigest. The default is SHA-256, named
+// sha256. Non-cryptographic hashes such as CRC-64 may be used to trade
+// collision resistance for speed, for example:
+//
+//	digest.Algorithm("crc64", func() hash.Hash {
+//	    return crc64.New(crc64.MakeTable(crc64.ECMA))
+//	})
+func Algorithm(name string, fn func() hash.Hash) Option {
+	return func(o *options) {
+		o.algorithm = name
+		o.newHash = fn
+	}
+}
+
+// Concurrency sets the maximum number of files that will be read and
+// hashed concurrently. The default is the number of available CPUs.
+func Concurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// Hasher computes digests for the files in a Filesystem that implements
+// filewalk.Reader. It is safe for concurrent use and the limit on the
+// number of files hashed concurrently applies across all callers.
+type Hasher struct {
+	opts    options
+	fs      filewalk.Filesystem
+	rd      filewalk.Reader
+	limitCh chan struct{}
+}
+
+// New returns a new Hasher for fs, which must implement filewalk.Reader.
+func New(fs filewalk.Filesystem, opts ...Option) (*Hasher, error) {
+	h := &Hasher{fs: fs}
+	h.opts.algorithm = "sha256"
+	h.opts.newHash = sha256.New
+	for _, fn := range opts {
+		fn(&h.opts)
+	}
+	if h.opts.concurrency <= 0 {
+		h.opts.concurrency = runtime.GOMAXPROCS(-1)
+	}
+	rd, ok := fs.(filewalk.Reader)
+	if !ok {
+		return nil, fmt.Errorf("filesystem does not support reading files")
+	}
+	h.rd = rd
+	h.limitCh = make(chan struct{}, h.opts.concurrency)
+	return h, nil
+}
+
+// Digest computes the digest of the file at path, which is described by
+// info.
+func (h *Hasher) Digest(ctx context.Context, path string, info filewalk.Info) (filewalk.FileDigest, error) {
+	select {
+	case h.limitCh <- struct{}{}:
+	case <-ctx.Done():
+		return filewalk.FileDigest{}, ctx.Err()
+	}
+	defer func() { <-h.limitCh }()
+	return h.digest(ctx, path, info)
+}
+
+func (h *Hasher) digest(ctx context.Context, path string, info filewalk.Info) (filewalk.FileDigest, error) {
+	rc, err := h.rd.Open(ctx, path)
+	if err != nil {
+		return filewalk.FileDigest{}, err
+	}
+	defer rc.Close()
+	hash := h.opts.newHash()
+	if _, err := io.Copy(hash, rc); err != nil {
+		return filewalk.FileDigest{}, err
+	}
+	return filewalk.FileDigest{
+		Name:      info.Name,
+		Size:      info.Size,
+		ModTime:   info.ModTime,
+		Algorithm: h.opts.algorithm,
+		Sum:       hash.Sum(nil),
+	}, nil
+}
+
+// Files computes digests for the supplied files, all of which are in
+// prefix, concurrently. The digests are returned in the same order as
+// files, with those files for which a digest could not be computed
+// being omitted, and with all of the errors encountered being returned
+// as an errors.M. Links and prefixes are ignored.
+func (h *Hasher) Files(ctx context.Context, prefix string, files []filewalk.Info) ([]filewalk.FileDigest, error) {
+	digests := make([]filewalk.FileDigest, len(files))
+	ok := make([]bool, len(files))
+	errs := &errors.M{}
+	var wg sync.WaitGroup
+	for i, file := range files {
+		if file.IsLink() || file.IsPrefix() {
+			continue
+		}
+		select {
+		case h.limitCh <- struct{}{}:
+		case <-ctx.Done():
+			errs.Append(ctx.Err())
+			wg.Wait()
+			return compact(digests, ok), errs.Err()
+		}
+		wg.Add(1)
+		go func(i int, file filewalk.Info) {
+			defer wg.Done()
+			defer func() { <-h.limitCh }()
+			digest, err := h.digest(ctx, h.fs.Join(prefix, file.Name), file)
+			if err != nil {
+				errs.Append(&filewalk.Error{Path: h.fs.Join(prefix, file.Name), Op: "digest", Err: err})
+				return
+			}
+			digests[i], ok[i] = digest, true
+		}(i, file)
+	}
+	wg.Wait()
+	return compact(digests, ok), errs.Err()
+}
+
+func compact(digests []filewalk.FileDigest, ok []bool) []filewalk.FileDigest {
+	n := 0
+	for i := range digests {
+		if ok[i] {
+			digests[n] = digests[i]
+			n++
+		}
+	}
+	return digests[:n]
+}
+
+// DigestsFunc is called by the ContentsFunc returned by Hasher.ContentsFunc
+// with the files in a prefix and their digests. err is non-nil if errors
+// were encountered listing the prefix or computing the digests.
+type DigestsFunc func(ctx context.Context, prefix string, info *filewalk.Info, files []filewalk.Info, digests []filewalk.FileDigest, err error) error
+
+// ContentsFunc returns a filewalk.ContentsFunc that computes digests for
+// all of the files in each prefix and then calls fn with them. The
+// returned ContentsFunc returns the children encountered, and the error
+// returned by fn.
+func (h *Hasher) ContentsFunc(fn DigestsFunc) filewalk.ContentsFunc {
+	return func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+		errs := &errors.M{}
+		children, files := []filewalk.Info{}, []filewalk.Info{}
+		for c := range ch {
+			if c.Err != nil {
+				errs.Append(c.Err)
+				continue
+			}
+			children = append(children, c.Children...)
+			files = append(files, c.Files...)
+		}
+		digests, err := h.Files(ctx, prefix, files)
+		errs.Append(err)
+		return children, fn(ctx, prefix, info, files, digests, errs.Err())
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package digest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/digest"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+const tree = `
+a0/
+  f0 data=hello
+  f1 data=world
+  f2 data=
+  l0 link
+b0/
+  f3 data=other
+  f4 data=bad open-err=permission
+`
+
+func sum(s string) []byte {
+	h := sha256.Sum256([]byte(s))
+	return h[:]
+}
+
+func TestContentsFunc(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := digest.New(fs, digest.Concurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	results := map[string][]filewalk.FileDigest{}
+	errs := map[string]error{}
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		return false, nil, err
+	}
+	contentsFn := hasher.ContentsFunc(func(ctx context.Context, prefix string, info *filewalk.Info, files []filewalk.Info, digests []filewalk.FileDigest, err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		results[prefix] = digests
+		errs[prefix] = err
+		return nil
+	})
+	if err := filewalk.New(fs).Walk(ctx, prefixFn, contentsFn, "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(prefix string, names ...string) {
+		digests := results[prefix]
+		if got, want := len(digests), len(names)/2; got != want {
+			t.Fatalf("%v: got %v, want %v", prefix, got, want)
+		}
+		for i, d := range digests {
+			name, contents := names[i*2], names[i*2+1]
+			if got, want := d.Name, name; got != want {
+				t.Errorf("%v: got %v, want %v", prefix, got, want)
+			}
+			if got, want := d.Sum, sum(contents); !bytes.Equal(got, want) {
+				t.Errorf("%v: %v: got %x, want %x", prefix, name, got, want)
+			}
+			if got, want := d.Size, int64(len(contents)); got != want {
+				t.Errorf("%v: %v: got %v, want %v", prefix, name, got, want)
+			}
+			if got, want := d.Algorithm, "sha256"; got != want {
+				t.Errorf("%v: got %v, want %v", prefix, got, want)
+			}
+		}
+	}
+	expect("/a0", "f0", "hello", "f1", "world", "f2", "")
+	expect("/b0", "f3", "other")
+	if err := errs["/a0"]; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := errs["/b0"]; err == nil || !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+
+	// Digests may be stored alongside the PrefixInfo for a prefix.
+	info, err := fs.Stat(ctx, "/a0/f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi := filewalk.PrefixInfo{Digests: results["/a0"]}
+	if !pi.Digests[0].Matches(info) {
+		t.Errorf("digest should match %v", info)
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := crc64.MakeTable(crc64.ECMA)
+	hasher, err := digest.New(fs, digest.Algorithm("crc64", func() hash.Hash {
+		return crc64.New(table)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(ctx, "/a0/f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := hasher.Digest(ctx, "/a0/f0", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := crc64.New(table)
+	h.Write([]byte("hello"))
+	if got, want := d.Sum, h.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+	if got, want := d.Algorithm, "crc64"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := hasher.Digest(ctx, "/a0/nothere", info); !os.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+// counter tracks the number of concurrent calls to Open.
+type counter struct {
+	*memfs.FS
+	mu              sync.Mutex
+	current, maxima int
+}
+
+func (c *counter) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	c.current++
+	if c.current > c.maxima {
+		c.maxima = c.current
+	}
+	c.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	defer func() {
+		c.mu.Lock()
+		c.current--
+		c.mu.Unlock()
+	}()
+	return c.FS.Open(ctx, path)
+}
+
+func TestConcurrency(t *testing.T) {
+	ctx := context.Background()
+	fs := memfs.New()
+	var files []filewalk.Info
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if err := fs.AddFile("/"+name, filewalk.Info{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.SetContents("/"+name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := fs.Stat(ctx, "/"+name)
+		files = append(files, info)
+	}
+	cfs := &counter{FS: fs}
+	hasher, err := digest.New(cfs, digest.Concurrency(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests, err := hasher.Files(ctx, "/", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(digests), len(files); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, d := range digests {
+		if got, want := d.Sum, sum(files[i].Name); !bytes.Equal(got, want) {
+			t.Errorf("%v: got %x, want %x", files[i].Name, got, want)
+		}
+	}
+	if got, want := cfs.maxima, 3; got > want {
+		t.Errorf("got %v, want <= %v", got, want)
+	}
+
+	if _, err := digest.New(struct{ filewalk.Filesystem }{fs}); err == nil {
+		t.Errorf("expected an error for a filesystem that does not support Open")
+	}
+}
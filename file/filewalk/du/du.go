@@ -0,0 +1,188 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package du provides disk usage accounting that, like du(1), is aware of
+// hard links and sparse files. The usage of a file is taken to be the
+// storage allocated to it, as per st_blocks, when extended metadata is
+// available, see filewalk.Metadata, and is otherwise computed from its
+// logical size using a diskusage.Calculator. Files with multiple hard links
+// are accounted for only once per walk, with their usage being attributed
+// either to the first prefix in which they are encountered or split across
+// all of their links.
+package du
+
+import (
+	"fmt"
+	"sync"
+
+	"cloudeng.io/file/diskusage"
+	"cloudeng.io/file/filewalk"
+)
+
+// BlockSize is the size of the blocks reported by filewalk.Metadata.Blocks.
+const BlockSize = 512
+
+// Attribution determines how the usage of files with multiple hard links
+// is attributed to the prefixes that contain them.
+type Attribution int
+
+const (
+	// FirstSeen attributes all of the usage of a file to the first prefix
+	// in which it is encountered, as does du(1). Note that for concurrent
+	// walks the first prefix encountered will vary from walk to walk, an
+	// ordered traversal, see filewalk.Order, can be used to avoid this.
+	FirstSeen Attribution = iota
+	// Split attributes an equal share of the usage of a file to each of
+	// its links, with any remainder being attributed to the first. Shares
+	// attributable to links that are not encountered, for example because
+	// they lie outside of the hierarchy being walked, are not counted.
+	Split
+)
+
+// String implements stringer.
+func (a Attribution) String() string {
+	switch a {
+	case FirstSeen:
+		return "first-seen"
+	case Split:
+		return "split"
+	}
+	return fmt.Sprintf("unknown attribution: %d", int(a))
+}
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	calculator  diskusage.Calculator
+	attribution Attribution
+}
+
+// Calculator sets the diskusage.Calculator used for files for which
+// the allocated storage is not available. The default is
+// diskusage.NewIdentity.
+func Calculator(calc diskusage.Calculator) Option {
+	return func(o *options) {
+		o.calculator = calc
+	}
+}
+
+// Attribute sets the attribution used for files with multiple hard links,
+// the default is FirstSeen.
+func Attribute(a Attribution) Option {
+	return func(o *options) {
+		o.attribution = a
+	}
+}
+
+type fileID struct {
+	device, inode uint64
+}
+
+// Accountant accumulates disk usage over the course of a walk. It is safe
+// for concurrent use.
+type Accountant struct {
+	opts      options
+	mu        sync.Mutex
+	seen      map[fileID]bool
+	total     int64
+	links     int64
+	sparse    int64
+	allocated int64
+}
+
+// New returns a new Accountant.
+func New(opts ...Option) *Accountant {
+	a := &Accountant{seen: map[fileID]bool{}}
+	a.opts.calculator = diskusage.NewIdentity()
+	for _, fn := range opts {
+		fn(&a.opts)
+	}
+	return a
+}
+
+// usage returns the storage allocated to the file described by info
+// and whether that was obtained from its extended metadata.
+func (a *Accountant) usage(info filewalk.Info) (int64, bool) {
+	if md := info.Metadata; md != nil {
+		return md.Blocks * BlockSize, true
+	}
+	return a.opts.calculator.Calculate(info.Size), false
+}
+
+// Add accounts for the file, or prefix, described by info and returns the
+// usage attributable to the prefix that contains it.
+func (a *Accountant) Add(info filewalk.Info) int64 {
+	usage, allocated := a.usage(info)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if allocated {
+		a.allocated++
+		if usage < info.Size {
+			a.sparse++
+		}
+	}
+	md := info.Metadata
+	if md == nil || md.Links < 2 || info.IsPrefix() {
+		a.total += usage
+		return usage
+	}
+	id := fileID{device: info.Device, inode: md.Inode}
+	first := !a.seen[id]
+	if first {
+		a.seen[id] = true
+		a.total += usage
+	} else {
+		a.links++
+	}
+	switch a.opts.attribution {
+	case Split:
+		share := usage / int64(md.Links)
+		if first {
+			share += usage % int64(md.Links)
+		}
+		return share
+	default:
+		if first {
+			return usage
+		}
+		return 0
+	}
+}
+
+// AddFiles is like Add, but for all of the files in a single prefix and
+// it returns the total usage attributable to that prefix.
+func (a *Accountant) AddFiles(files []filewalk.Info) int64 {
+	var total int64
+	for _, f := range files {
+		total += a.Add(f)
+	}
+	return total
+}
+
+// Total returns the total usage of everything accounted for so far with
+// every file being counted once regardless of the number of its hard links
+// that were encountered.
+func (a *Accountant) Total() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.total
+}
+
+// Stats records the number of files whose usage was computed from the
+// storage allocated to them, the number of those that were sparse, that is,
+// had less storage allocated than their size, and the number of additional
+// hard links that were encountered.
+type Stats struct {
+	Allocated int64
+	Sparse    int64
+	HardLinks int64
+}
+
+// Stats returns the current statistics for the Accountant.
+func (a *Accountant) Stats() Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Stats{Allocated: a.allocated, Sparse: a.sparse, HardLinks: a.links}
+}
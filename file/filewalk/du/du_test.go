@@ -0,0 +1,98 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package du_test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"cloudeng.io/file/diskusage"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/du"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+// h0 and h1 are hard links to the same file, which has a third link that
+// is not in the tree, sp is a sparse file and f0, f1 have no metadata.
+const tree = `
+a/
+  h0 size=4096 dev=1 ino=10 links=3 blocks=8
+  sp size=1048576 dev=1 ino=11 blocks=16
+  f0 size=100
+b/
+  h1 size=4096 dev=1 ino=10 links=3 blocks=8
+  f1 size=1000
+c/
+  h2 size=4096 dev=2 ino=10 links=2 blocks=8
+`
+
+func walk(t *testing.T, acc *du.Accountant) map[string]int64 {
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	usage := map[string]int64{}
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		return false, nil, err
+	}
+	contentsFn := func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+		var children, files []filewalk.Info
+		for c := range ch {
+			children = append(children, c.Children...)
+			files = append(files, c.Files...)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		usage[prefix] = acc.AddFiles(files)
+		return children, nil
+	}
+	wk := filewalk.New(fs, filewalk.Order(filewalk.DepthFirst))
+	if err := wk.Walk(context.Background(), prefixFn, contentsFn, "/"); err != nil {
+		t.Fatal(err)
+	}
+	return usage
+}
+
+func TestFirstSeen(t *testing.T) {
+	acc := du.New(du.Calculator(diskusage.NewSimple(512)))
+	usage := walk(t, acc)
+	if got, want := usage, map[string]int64{
+		"/":  0,
+		"/a": 4096 + 8192 + 512,
+		"/b": 1024,
+		"/c": 4096,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := acc.Total(), int64(4096+8192+512+1024+4096); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := acc.Stats(), (du.Stats{Allocated: 4, Sparse: 1, HardLinks: 1}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	acc := du.New(du.Attribute(du.Split))
+	usage := walk(t, acc)
+	// h0's usage is split three ways, with the remainder attributed to
+	// the first link, and the share for the link outside of the tree is
+	// not counted.
+	if got, want := usage, map[string]int64{
+		"/":  0,
+		"/a": 1366 + 8192 + 100,
+		"/b": 1365 + 1000,
+		"/c": 2048,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The total is independent of attribution.
+	if got, want := acc.Total(), int64(4096+8192+100+1000+4096); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package filter provides gitignore-style include/exclude filtering for
+// use with filewalk. Patterns are compiled into Rules which may be used
+// directly or via a Filter which provides a PrefixFunc that prunes
+// excluded prefixes from a walk and a helper for removing excluded files
+// from within a ContentsFunc. A Filter may also be configured to read
+// nested ignore files, such as .gitignore, as they are encountered during
+// a walk, with the patterns in each such file applying to the prefix that
+// contains it and all of the prefixes below it.
+package filter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	ignoreFile string
+}
+
+// IgnoreFile requests that a file with the specified name, for example
+// .gitignore, be read from every prefix traversed and the patterns that it
+// contains be applied to that prefix and the prefixes below it. Patterns
+// in more deeply nested files take precedence. The Filesystem being walked
+// must implement filewalk.Reader.
+func IgnoreFile(name string) Option {
+	return func(o *options) {
+		o.ignoreFile = name
+	}
+}
+
+// scoped represents a set of rules and the location, relative to the root
+// of the walk, that they apply to.
+type scoped struct {
+	base  string
+	rules *Rules
+}
+
+// level represents the state for a single prefix.
+type level struct {
+	rel   string
+	rules []scoped
+}
+
+// Filter provides gitignore-style filtering for a walk. It is safe for
+// concurrent use. Note that a Filter retains the relative path and rules
+// in effect for every prefix that it has seen and hence a new Filter
+// should be used for each walk.
+type Filter struct {
+	opts   options
+	fs     filewalk.Filesystem
+	rd     filewalk.Reader
+	rules  *Rules
+	mu     sync.Mutex
+	levels map[string]*level
+}
+
+// New returns a new Filter for walks of fs using the supplied rules, which
+// may be nil, and which are interpreted relative to the root of each walk.
+func New(fs filewalk.Filesystem, rules *Rules, opts ...Option) (*Filter, error) {
+	f := &Filter{fs: fs, rules: rules, levels: map[string]*level{}}
+	for _, fn := range opts {
+		fn(&f.opts)
+	}
+	if len(f.opts.ignoreFile) > 0 {
+		rd, ok := fs.(filewalk.Reader)
+		if !ok {
+			return nil, fmt.Errorf("filesystem does not support reading files, which is required for reading %v files", f.opts.ignoreFile)
+		}
+		f.rd = rd
+	}
+	return f, nil
+}
+
+const separators = "/" + string(filepath.Separator)
+
+// key returns p without any trailing separators, unless p consists
+// solely of separators.
+func key(p string) string {
+	if k := strings.TrimRight(p, separators); len(k) > 0 {
+		return k
+	}
+	return p
+}
+
+// parentOf returns the parent of p.
+func parentOf(p string) string {
+	p = key(p)
+	idx := strings.LastIndexAny(p, separators)
+	if idx < 0 {
+		return ""
+	}
+	return key(p[:idx+1])
+}
+
+// nameOf returns the last component of p.
+func nameOf(p string) string {
+	p = key(p)
+	return p[strings.LastIndexAny(p, separators)+1:]
+}
+
+func joinRel(rel, name string) string {
+	if len(rel) == 0 {
+		return name
+	}
+	return rel + "/" + name
+}
+
+func excluded(rules []scoped, rel string, isPrefix bool) bool {
+	result := false
+	for _, s := range rules {
+		r := rel
+		if len(s.base) > 0 {
+			r = strings.TrimPrefix(rel, s.base+"/")
+		}
+		if matched, excl := s.rules.match(r, isPrefix); matched {
+			result = excl
+		}
+	}
+	return result
+}
+
+// lookup returns the level for the specified prefix, or nil if the
+// prefix has not been seen.
+func (f *Filter) lookup(prefix string) *level {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.levels[key(prefix)]
+}
+
+// Excluded returns true if the specified path is excluded. The path's
+// parent must already have been passed to the PrefixFunc returned by
+// PrefixFunc, if not, the path is assumed to be the root of a walk and
+// is never excluded.
+func (f *Filter) Excluded(path string, isPrefix bool) bool {
+	parent := f.lookup(parentOf(path))
+	if parent == nil {
+		return false
+	}
+	return excluded(parent.rules, joinRel(parent.rel, nameOf(path)), isPrefix)
+}
+
+func (f *Filter) readIgnoreFile(ctx context.Context, prefix string) (*Rules, error) {
+	rc, err := f.rd.Open(ctx, f.fs.Join(prefix, f.opts.ignoreFile))
+	if err != nil {
+		if f.fs.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	rules, err := Parse(rc)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", f.fs.Join(prefix, f.opts.ignoreFile), err)
+	}
+	return rules, nil
+}
+
+// enter creates the level for prefix. It returns true if prefix is
+// excluded.
+func (f *Filter) enter(ctx context.Context, prefix string, isPrefix bool) (bool, error) {
+	parent := f.lookup(parentOf(prefix))
+	var l *level
+	if parent == nil {
+		l = &level{}
+		if f.rules != nil {
+			l.rules = []scoped{{rules: f.rules}}
+		}
+	} else {
+		l = &level{rel: joinRel(parent.rel, nameOf(prefix)), rules: parent.rules}
+		if excluded(parent.rules, l.rel, isPrefix) {
+			return true, nil
+		}
+	}
+	if f.rd != nil {
+		rules, err := f.readIgnoreFile(ctx, prefix)
+		if err != nil {
+			return false, err
+		}
+		if rules != nil {
+			l.rules = append(append([]scoped{}, l.rules...), scoped{base: l.rel, rules: rules})
+		}
+	}
+	f.mu.Lock()
+	f.levels[key(prefix)] = l
+	f.mu.Unlock()
+	return false, nil
+}
+
+// PrefixFunc returns a filewalk.PrefixFunc that stops the traversal of
+// excluded prefixes and otherwise calls next, which may be nil. Errors
+// encountered reading ignore files are returned by the PrefixFunc.
+func (f *Filter) PrefixFunc(next filewalk.PrefixFunc) filewalk.PrefixFunc {
+	return func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		if err == nil {
+			excl, ferr := f.enter(ctx, prefix, info.IsPrefix())
+			if ferr != nil {
+				return true, nil, ferr
+			}
+			if excl {
+				return true, nil, nil
+			}
+		}
+		if next == nil {
+			return false, nil, err
+		}
+		return next(ctx, prefix, info, err)
+	}
+}
+
+// Files returns the subset of files, which must be the contents of
+// prefix, that are not excluded. It is intended to be called from within
+// a ContentsFunc. The supplied slice is modified in place.
+func (f *Filter) Files(prefix string, files []filewalk.Info) []filewalk.Info {
+	l := f.lookup(prefix)
+	if l == nil {
+		return files
+	}
+	included := files[:0]
+	for _, file := range files {
+		if !excluded(l.rules, joinRel(l.rel, file.Name), false) {
+			included = append(included, file)
+		}
+	}
+	return included
+}
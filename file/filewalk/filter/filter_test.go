@@ -0,0 +1,144 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filter_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/filter"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+func walk(t *testing.T, fs filewalk.Filesystem, flt *filter.Filter, root string) []string {
+	var mu sync.Mutex
+	var lines []string
+	wk := filewalk.New(fs, filewalk.Concurrency(2))
+	err := wk.Walk(context.Background(),
+		flt.PrefixFunc(func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, prefix+"*")
+			return false, nil, err
+		}),
+		func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+			children := []filewalk.Info{}
+			for c := range ch {
+				if c.Err != nil {
+					return nil, c.Err
+				}
+				mu.Lock()
+				for _, f := range flt.Files(prefix, c.Files) {
+					lines = append(lines, fs.Join(prefix, f.Name))
+				}
+				mu.Unlock()
+				children = append(children, c.Children...)
+			}
+			return children, nil
+		},
+		root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestFilter(t *testing.T) {
+	fs, err := memfs.Parse(`
+src/
+  a.go
+  a.o
+  build/
+    out
+  vendor/
+    v.go
+docs/
+  build/
+    index.html
+  notes.txt
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := filter.Compile("*.o", "/src/build/", "vendor", "docs/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flt, err := filter.New(fs, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := walk(t, fs, flt, "/"), []string{
+		"/*",
+		"/docs*",
+		"/docs/build*",
+		"/docs/build/index.html",
+		"/src*",
+		"/src/a.go",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Rules are relative to the root of the walk.
+	flt, _ = filter.New(fs, rules)
+	if got, want := walk(t, fs, flt, "/src"), []string{
+		"/src*",
+		"/src/a.go",
+		"/src/build*",
+		"/src/build/out",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := filter.New(struct{ filewalk.Filesystem }{fs}, rules, filter.IgnoreFile(".gitignore")); err == nil || !strings.Contains(err.Error(), "does not support reading") {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		".gitignore":          {Data: []byte("*.log\ntmp/\n")},
+		"a.log":               {},
+		"a.txt":               {},
+		"tmp/x":               {},
+		"sub/.gitignore":      {Data: []byte("!keep.log\n/local\n")},
+		"sub/keep.log":        {},
+		"sub/drop.log":        {},
+		"sub/local/x":         {},
+		"sub/deeper/local/y":  {},
+		"sub/deeper/keep.log": {},
+		"other/keep.log":      {},
+	}
+	fs := filewalk.FromFS(fsys, 10)
+	flt, err := filter.New(fs, nil, filter.IgnoreFile(".gitignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := walk(t, fs, flt, "/"), []string{
+		"/*",
+		"/.gitignore",
+		"/a.txt",
+		"/other*",
+		"/sub*",
+		"/sub/.gitignore",
+		"/sub/deeper*",
+		"/sub/deeper/keep.log",
+		"/sub/deeper/local*",
+		"/sub/deeper/local/y",
+		"/sub/keep.log",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !flt.Excluded("/sub/local", true) || flt.Excluded("/sub/other", true) {
+		t.Errorf("unexpected result")
+	}
+}
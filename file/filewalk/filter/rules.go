@@ -0,0 +1,189 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// pattern represents a single compiled rule.
+type pattern struct {
+	text     string
+	negate   bool
+	dirOnly  bool
+	segments []string
+}
+
+// Rules represents an ordered set of gitignore-style patterns. Later
+// patterns take precedence over earlier ones.
+type Rules struct {
+	patterns []pattern
+}
+
+// Compile compiles the supplied patterns, each of which is interpreted
+// as per a single line of a .gitignore file:
+//
+//   - blank lines and lines starting with # are ignored; use \# for
+//     a pattern that starts with #.
+//   - trailing spaces are ignored unless escaped with \.
+//   - a leading ! negates the pattern so that any matching entry that was
+//     excluded by a previous pattern is included again; use \! for a
+//     pattern that starts with !.
+//   - a trailing / restricts the pattern to matching prefixes only.
+//   - a pattern that contains a / other than a trailing one is anchored,
+//     that is, matched against the full path relative to the location of
+//     the rules, otherwise it is matched against the name of the entry
+//     at any level.
+//   - *, ? and [...] are interpreted as per path.Match and never match /.
+//   - a leading **/ matches in all prefixes, a trailing /** matches
+//     everything within a prefix and /**/ matches zero or more prefixes.
+func Compile(patterns ...string) (*Rules, error) {
+	r := &Rules{}
+	for i, p := range patterns {
+		pat, ok, err := compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %v: %q: %v", i, p, err)
+		}
+		if ok {
+			r.patterns = append(r.patterns, pat)
+		}
+	}
+	return r, nil
+}
+
+// Parse reads patterns, one per line, from rd and compiles them as
+// per Compile.
+func Parse(rd io.Reader) (*Rules, error) {
+	var lines []string
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return Compile(lines...)
+}
+
+// trimTrailingSpace removes unescaped trailing spaces.
+func trimTrailingSpace(p string) string {
+	for strings.HasSuffix(p, " ") && !strings.HasSuffix(p, `\ `) {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+func compile(text string) (pattern, bool, error) {
+	p := strings.TrimSuffix(text, "\r")
+	p = trimTrailingSpace(p)
+	if len(p) == 0 || strings.HasPrefix(p, "#") {
+		return pattern{}, false, nil
+	}
+	pat := pattern{text: p}
+	switch {
+	case strings.HasPrefix(p, "!"):
+		pat.negate = true
+		p = p[1:]
+	case strings.HasPrefix(p, `\!`), strings.HasPrefix(p, `\#`):
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		pat.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if len(p) == 0 {
+		return pattern{}, false, fmt.Errorf("empty pattern")
+	}
+	if strings.Contains(p, "/") {
+		p = strings.TrimPrefix(p, "/")
+	} else {
+		p = "**/" + p
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if len(seg) == 0 {
+			continue
+		}
+		if seg != "**" {
+			if _, err := path.Match(seg, ""); err != nil {
+				return pattern{}, false, err
+			}
+		}
+		pat.segments = append(pat.segments, seg)
+	}
+	return pat, true, nil
+}
+
+// matchSegments returns true if the pattern segments match the path
+// components.
+func matchSegments(segments, components []string) bool {
+	for len(segments) > 0 {
+		seg := segments[0]
+		if seg == "**" {
+			if len(segments) == 1 {
+				// A trailing ** matches one or more components.
+				return len(components) > 0
+			}
+			for i := 0; i <= len(components); i++ {
+				if matchSegments(segments[1:], components[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(components) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(seg, components[0]); !ok {
+			return false
+		}
+		segments, components = segments[1:], components[1:]
+	}
+	return len(components) == 0
+}
+
+func (p pattern) match(components []string, isPrefix bool) bool {
+	if p.dirOnly && !isPrefix {
+		return false
+	}
+	return matchSegments(p.segments, components)
+}
+
+// match returns whether any pattern matched rel and if so, whether
+// the last such pattern excludes it.
+func (r *Rules) match(rel string, isPrefix bool) (matched, excluded bool) {
+	if r == nil {
+		return false, false
+	}
+	components := strings.Split(strings.Trim(rel, "/"), "/")
+	for i := len(r.patterns) - 1; i >= 0; i-- {
+		if p := r.patterns[i]; p.match(components, isPrefix) {
+			return true, !p.negate
+		}
+	}
+	return false, false
+}
+
+// Excluded returns true if the / separated path rel, interpreted relative
+// to the location of the rules, is excluded by them. isPrefix indicates
+// whether rel refers to a prefix or a file. Note that it is the caller's
+// responsibility to exclude the contents of excluded prefixes.
+func (r *Rules) Excluded(rel string, isPrefix bool) bool {
+	_, excluded := r.match(rel, isPrefix)
+	return excluded
+}
+
+// String implements stringer.
+func (r *Rules) String() string {
+	var out strings.Builder
+	for _, p := range r.patterns {
+		out.WriteString(p.text)
+		out.WriteString("\n")
+	}
+	return out.String()
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filter_test
+
+import (
+	"strings"
+	"testing"
+
+	"cloudeng.io/file/filewalk/filter"
+)
+
+func TestRules(t *testing.T) {
+	for i, tc := range []struct {
+		patterns []string
+		path     string
+		isPrefix bool
+		excluded bool
+	}{
+		// Unanchored patterns match at any level.
+		{[]string{"*.o"}, "a.o", false, true},
+		{[]string{"*.o"}, "x/y/a.o", false, true},
+		{[]string{"*.o"}, "a.c", false, false},
+		{[]string{"build"}, "x/build", true, true},
+		{[]string{"build"}, "x/build", false, true},
+		// Anchored patterns.
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "x/build", true, false},
+		{[]string{"x/*.o"}, "x/a.o", false, true},
+		{[]string{"x/*.o"}, "y/x/a.o", false, false},
+		{[]string{"x/*.o"}, "x/y/a.o", false, false},
+		// Directory only patterns.
+		{[]string{"build/"}, "x/build", true, true},
+		{[]string{"build/"}, "x/build", false, false},
+		// ** patterns.
+		{[]string{"**/logs"}, "logs", true, true},
+		{[]string{"**/logs"}, "a/b/logs", true, true},
+		{[]string{"**/logs/debug.log"}, "a/logs/debug.log", false, true},
+		{[]string{"logs/**"}, "logs", true, false},
+		{[]string{"logs/**"}, "logs/a", false, true},
+		{[]string{"logs/**"}, "logs/a/b", false, true},
+		{[]string{"a/**/b"}, "a/b", true, true},
+		{[]string{"a/**/b"}, "a/x/y/b", true, true},
+		{[]string{"a/**/b"}, "a/x/y/c", true, false},
+		// Negation, the last matching pattern wins.
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "other.log", false, true},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		// Comments, blank lines and escapes.
+		{[]string{"# comment", "", `\#file`}, "#file", false, true},
+		{[]string{`\!important`}, "!important", false, true},
+		{[]string{"trailing   "}, "trailing", false, true},
+		{[]string{`space\ `}, "space ", false, true},
+		// Character classes.
+		{[]string{"f[0-9]"}, "f1", false, true},
+		{[]string{"f[0-9]"}, "fa", false, false},
+		{[]string{"f?"}, "f/", true, false},
+	} {
+		rules, err := filter.Compile(tc.patterns...)
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if got, want := rules.Excluded(tc.path, tc.isPrefix), tc.excluded; got != want {
+			t.Errorf("%v: %v: %q: got %v, want %v", i, tc.patterns, tc.path, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	rules, err := filter.Parse(strings.NewReader("# comment\n*.o\r\n\n!a.o\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rules.String(), "*.o\n!a.o\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !rules.Excluded("b.o", false) || rules.Excluded("a.o", false) {
+		t.Errorf("unexpected result")
+	}
+	for _, p := range []string{"[", "/", "!"} {
+		if _, err := filter.Compile(p); err == nil {
+			t.Errorf("%q: expected an error", p)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package gcsfs provides an implementation of filewalk.Filesystem for
+// Google Cloud Storage. It uses the JSON API's objects.list method with
+// / as the delimiter so that prefixes are returned as Contents.Children
+// and objects as Contents.Files. Each page of results is sent as a separate
+// Contents message. Paths are parsed using
+// cloudpath.GoogleCloudStorageMatcher and hence may be of the form
+// gs://bucket/object, https://storage.cloud.google.com/bucket/object or
+// any of the other forms it recognises. Object names are used verbatim,
+// that is, they are not URL decoded and may contain any of the characters,
+// such as #, ? or %, that are special to URLs.
+//
+// Authentication is the responsibility of the http.Client supplied via
+// the HTTPClient option, for example one created using golang.org/x/oauth2.
+package gcsfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/path/cloudpath"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	endpoint    string
+	client      *http.Client
+	scanSize    int
+	userProject string
+}
+
+// Endpoint sets the endpoint to use for all requests, for example the
+// URL of a local fake GCS server. The default is
+// https://storage.googleapis.com.
+func Endpoint(endpoint string) Option {
+	return func(o *options) {
+		o.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// HTTPClient sets the http.Client to use, the default is http.DefaultClient
+// which will only allow access to publicly readable buckets.
+func HTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// ScanSize sets the maximum number of results to be returned by each call
+// to objects.list and hence in each Contents message. The default, 0,
+// uses the server's default (1000 for GCS).
+func ScanSize(n int) Option {
+	return func(o *options) {
+		o.scanSize = n
+	}
+}
+
+// UserProject sets the project to be billed for requests made to
+// requester-pays buckets.
+func UserProject(project string) Option {
+	return func(o *options) {
+		o.userProject = project
+	}
+}
+
+type gcsfs struct {
+	opts options
+}
+
+// New returns a new instance of filewalk.Filesystem for Google Cloud Storage.
+func New(opts ...Option) filewalk.Filesystem {
+	fs := &gcsfs{}
+	fs.opts.endpoint = "https://storage.googleapis.com"
+	fs.opts.client = http.DefaultClient
+	for _, fn := range opts {
+		fn(&fs.opts)
+	}
+	return fs
+}
+
+// Owner represents the owner of an object.
+type Owner struct {
+	Entity   string `json:"entity"`
+	EntityID string `json:"entityId"`
+}
+
+// Object represents the metadata for an object as returned by the
+// JSON API. It is available via the Sys method of the filewalk.Info
+// instances returned by Stat and List.
+type Object struct {
+	Bucket       string    `json:"bucket"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size,string"`
+	Generation   int64     `json:"generation,string"`
+	ContentType  string    `json:"contentType"`
+	StorageClass string    `json:"storageClass"`
+	TimeCreated  time.Time `json:"timeCreated"`
+	Updated      time.Time `json:"updated"`
+	MD5Hash      string    `json:"md5Hash"`
+	CRC32C       string    `json:"crc32c"`
+	Owner        *Owner    `json:"owner,omitempty"`
+}
+
+type listResult struct {
+	NextPageToken string    `json:"nextPageToken"`
+	Prefixes      []string  `json:"prefixes"`
+	Items         []*Object `json:"items"`
+}
+
+// Error represents an error returned by the GCS JSON API.
+type Error struct {
+	StatusCode int    `json:"code"`
+	Message    string `json:"message"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("gcs: %v: %v", e.StatusCode, e.Message)
+}
+
+// Temporary returns true for the status codes that the GCS documentation
+// recommends be retried with exponential backoff.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func newError(resp *http.Response) error {
+	var body struct {
+		Error *Error `json:"error"`
+	}
+	buf, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(buf, &body); err != nil || body.Error == nil {
+		return &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+	body.Error.StatusCode = resp.StatusCode
+	return body.Error
+}
+
+// urlEscaper escapes the characters that are special to URLs so that
+// object names containing them survive being parsed by
+// cloudpath.GoogleCloudStorageMatcher, which decodes them again.
+var urlEscaper = strings.NewReplacer("%", "%25", "#", "%23", "?", "%3F")
+
+// bucketAndObject returns the bucket and object name encoded in p as
+// determined by cloudpath.GoogleCloudStorageMatcher. The object name is
+// returned verbatim.
+func bucketAndObject(p string) (string, string, error) {
+	match := cloudpath.GoogleCloudStorageMatcher(urlEscaper.Replace(p))
+	if match == nil || len(match.Volume) == 0 {
+		return "", "", fmt.Errorf("not a google cloud storage path: %v", p)
+	}
+	object := strings.TrimPrefix(strings.TrimPrefix(match.Path, "/"), match.Volume)
+	object = strings.TrimPrefix(object, "/")
+	if strings.HasSuffix(match.Host, "googleapis.com") {
+		// JSON API URLs are of the form .../b/<bucket>/o/<object>.
+		if object == "o" {
+			object = ""
+		}
+		object = strings.TrimPrefix(object, "o/")
+	}
+	return match.Volume, object, nil
+}
+
+func (fs *gcsfs) do(ctx context.Context, u string, query url.Values) (*http.Response, error) {
+	if len(fs.opts.userProject) > 0 {
+		query.Set("userProject", fs.opts.userProject)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fs.opts.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newError(resp)
+	}
+	return resp, nil
+}
+
+func (fs *gcsfs) get(ctx context.Context, u string, query url.Values, result interface{}) error {
+	resp, err := fs.do(ctx, u, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (fs *gcsfs) bucketURL(bucket string) string {
+	return fs.opts.endpoint + "/storage/v1/b/" + url.PathEscape(bucket)
+}
+
+func (fs *gcsfs) listObjects(ctx context.Context, bucket, prefix, token string, maxResults int) (*listResult, error) {
+	query := url.Values{}
+	query.Set("delimiter", "/")
+	query.Set("prefix", prefix)
+	query.Set("projection", "full")
+	if len(token) > 0 {
+		query.Set("pageToken", token)
+	}
+	if maxResults > 0 {
+		query.Set("maxResults", strconv.Itoa(maxResults))
+	}
+	result := &listResult{}
+	if err := fs.get(ctx, fs.bucketURL(bucket)+"/o", query, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func prefixInfo(name string) filewalk.Info {
+	return filewalk.Info{
+		Name: name,
+		Mode: filewalk.ModePrefix | 0700,
+	}
+}
+
+func objectInfo(name string, obj *Object) filewalk.Info {
+	info := filewalk.NewInfo(name, obj.Size, 0600, obj.Updated, obj)
+	if owner := obj.Owner; owner != nil {
+		info.UserID = owner.EntityID
+		if len(info.UserID) == 0 {
+			info.UserID = owner.Entity
+		}
+	}
+	return info
+}
+
+// Stat implements filewalk.Filesystem.
+func (fs *gcsfs) Stat(ctx context.Context, p string) (filewalk.Info, error) {
+	bucket, object, err := bucketAndObject(p)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	if len(object) == 0 {
+		var result struct{}
+		if err := fs.get(ctx, fs.bucketURL(bucket), url.Values{}, &result); err != nil {
+			return filewalk.Info{}, err
+		}
+		return prefixInfo(bucket), nil
+	}
+	trimmed := strings.TrimSuffix(object, "/")
+	name := trimmed[strings.LastIndex(trimmed, "/")+1:]
+	// The Walker calls Stat for prefixes far more often than for objects.
+	result, err := fs.listObjects(ctx, bucket, trimmed+"/", "", 1)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	if len(result.Items) > 0 || len(result.Prefixes) > 0 {
+		return prefixInfo(name), nil
+	}
+	obj := &Object{}
+	query := url.Values{}
+	query.Set("projection", "full")
+	if err := fs.get(ctx, fs.bucketURL(bucket)+"/o/"+url.PathEscape(object), query, obj); err != nil {
+		return filewalk.Info{}, err
+	}
+	return objectInfo(name, obj), nil
+}
+
+// List implements filewalk.Filesystem.
+func (fs *gcsfs) List(ctx context.Context, p string, ch chan<- filewalk.Contents) {
+	bucket, object, err := bucketAndObject(p)
+	if err != nil {
+		ch <- filewalk.Contents{Path: p, Err: err}
+		return
+	}
+	prefix := object
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	token := ""
+	for {
+		select {
+		case <-ctx.Done():
+			ch <- filewalk.Contents{Path: p, Err: ctx.Err()}
+			return
+		default:
+		}
+		result, err := fs.listObjects(ctx, bucket, prefix, token, fs.opts.scanSize)
+		if err != nil {
+			ch <- filewalk.Contents{Path: p, Err: err}
+			return
+		}
+		contents := filewalk.Contents{Path: p}
+		for _, cp := range result.Prefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(cp, prefix), "/")
+			if len(name) == 0 {
+				continue
+			}
+			contents.Children = append(contents.Children, prefixInfo(name))
+		}
+		for _, obj := range result.Items {
+			name := strings.TrimPrefix(obj.Name, prefix)
+			if len(name) == 0 {
+				// Ignore 'directory' placeholder objects.
+				continue
+			}
+			contents.Files = append(contents.Files, objectInfo(name, obj))
+		}
+		if len(contents.Children) > 0 || len(contents.Files) > 0 {
+			ch <- contents
+		}
+		if len(result.NextPageToken) == 0 {
+			return
+		}
+		token = result.NextPageToken
+	}
+}
+
+// Open implements filewalk.Reader by downloading the object's data,
+// ie. using alt=media.
+func (fs *gcsfs) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	bucket, object, err := bucketAndObject(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(object) == 0 || strings.HasSuffix(object, "/") {
+		return nil, fmt.Errorf("%v: is not an object", p)
+	}
+	query := url.Values{}
+	query.Set("alt", "media")
+	resp, err := fs.do(ctx, fs.bucketURL(bucket)+"/o/"+url.PathEscape(object), query)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Join implements filewalk.Filesystem.
+func (fs *gcsfs) Join(components ...string) string {
+	if len(components) == 0 {
+		return ""
+	}
+	p := strings.TrimSuffix(components[0], "/")
+	for _, c := range components[1:] {
+		c = strings.Trim(c, "/")
+		if len(c) == 0 {
+			continue
+		}
+		p += "/" + c
+	}
+	return p
+}
+
+func statusCode(err error) int {
+	var gcsErr *Error
+	if errors.As(err, &gcsErr) {
+		return gcsErr.StatusCode
+	}
+	return 0
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (fs *gcsfs) IsPermissionError(err error) bool {
+	code := statusCode(err)
+	return code == http.StatusForbidden || code == http.StatusUnauthorized
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (fs *gcsfs) IsNotExist(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
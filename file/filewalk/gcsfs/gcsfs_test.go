@@ -0,0 +1,403 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package gcsfs_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/gcsfs"
+)
+
+// fakeGCS implements the subset of the GCS JSON API used by gcsfs.
+type fakeGCS struct {
+	sync.Mutex
+	buckets   map[string][]*gcsfs.Object
+	forbidden map[string]bool
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error": {"code": %v, "message": %q}}`, status, msg)
+}
+
+func (s *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/b/")
+	parts := strings.SplitN(path, "/", 3)
+	bucket, _ := url.PathUnescape(parts[0])
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such bucket")
+		return
+	}
+	if s.forbidden[bucket] {
+		writeError(w, http.StatusForbidden, "access denied")
+		return
+	}
+	enc := json.NewEncoder(w)
+	switch {
+	case len(parts) == 1:
+		enc.Encode(map[string]string{"name": bucket})
+		return
+	case len(parts) == 3:
+		name, _ := url.PathUnescape(parts[2])
+		for _, obj := range objects {
+			if obj.Name != name {
+				continue
+			}
+			if r.URL.Query().Get("alt") == "media" {
+				w.Write([]byte(strings.Repeat("x", int(obj.Size))))
+				return
+			}
+			enc.Encode(obj)
+			return
+		}
+		writeError(w, http.StatusNotFound, "no such object")
+		return
+	}
+	q := r.URL.Query()
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	maxResults := 1000
+	if mr := q.Get("maxResults"); len(mr) > 0 {
+		maxResults, _ = strconv.Atoi(mr)
+	}
+	start, _ := strconv.Atoi(q.Get("pageToken"))
+	type entry struct {
+		name string
+		obj  *gcsfs.Object
+	}
+	seen := map[string]bool{}
+	var entries []entry
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Name, prefix) {
+			continue
+		}
+		rest := obj.Name[len(prefix):]
+		if idx := strings.Index(rest, delim); len(delim) > 0 && idx >= 0 {
+			cp := prefix + rest[:idx+1]
+			if !seen[cp] {
+				seen[cp] = true
+				entries = append(entries, entry{name: cp})
+			}
+			continue
+		}
+		entries = append(entries, entry{name: obj.Name, obj: obj})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	result := map[string]interface{}{"kind": "storage#objects"}
+	end := start + maxResults
+	if end < len(entries) {
+		result["nextPageToken"] = strconv.Itoa(end)
+	} else {
+		end = len(entries)
+	}
+	var prefixes []string
+	var items []*gcsfs.Object
+	for _, e := range entries[start:end] {
+		if e.obj == nil {
+			prefixes = append(prefixes, e.name)
+			continue
+		}
+		items = append(items, e.obj)
+	}
+	if len(prefixes) > 0 {
+		result["prefixes"] = prefixes
+	}
+	if len(items) > 0 {
+		result["items"] = items
+	}
+	enc.Encode(result)
+}
+
+var updated = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func newFakeGCS() *fakeGCS {
+	obj := func(name string, size int64) *gcsfs.Object {
+		return &gcsfs.Object{
+			Bucket:       "bucket",
+			Name:         name,
+			Size:         size,
+			StorageClass: "NEARLINE",
+			Updated:      updated,
+			Owner:        &gcsfs.Owner{Entity: "user-someone", EntityID: "1234"},
+		}
+	}
+	return &fakeGCS{
+		buckets: map[string][]*gcsfs.Object{
+			"bucket": {
+				obj("f0", 1),
+				obj("a/", 0),
+				obj("a/f1", 2),
+				obj("a/f2", 3),
+				obj("a/b/f3", 4),
+				obj("a/c/d/f4", 5),
+			},
+			"private": {obj("f0", 1)},
+			"special": {
+				obj("a#b/c", 1),
+				obj("x?y/z", 2),
+				obj("50%/z", 3),
+			},
+		},
+		forbidden: map[string]bool{"private": true},
+	}
+}
+
+func listAll(fs filewalk.Filesystem, p string) []filewalk.Contents {
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		fs.List(context.Background(), p, ch)
+		close(ch)
+	}()
+	var all []filewalk.Contents
+	for c := range ch {
+		all = append(all, c)
+	}
+	return all
+}
+
+func TestList(t *testing.T) {
+	srv := httptest.NewServer(newFakeGCS())
+	defer srv.Close()
+	fs := gcsfs.New(gcsfs.Endpoint(srv.URL), gcsfs.ScanSize(2))
+
+	contents := listAll(fs, "gs://bucket/a/")
+	if got, want := len(contents), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var children []string
+	var files []filewalk.Info
+	for _, c := range contents {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		for _, child := range c.Children {
+			children = append(children, child.Name)
+		}
+		files = append(files, c.Files...)
+	}
+	if got, want := children, []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(files), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	f2 := files[1]
+	if got, want := f2.Name, "f2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f2.Size, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f2.UserID, "1234"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f2.ModTime, updated; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	obj, ok := f2.Sys().(*gcsfs.Object)
+	if !ok {
+		t.Fatalf("wrong type for Sys: %T", f2.Sys())
+	}
+	if got, want := obj.StorageClass, "NEARLINE"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	contents = listAll(fs, "gs://private")
+	if err := contents[0].Err; err == nil || !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	contents = listAll(fs, "gs://nowhere")
+	if err := contents[0].Err; err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(newFakeGCS())
+	defer srv.Close()
+	fs := gcsfs.New(gcsfs.Endpoint(srv.URL))
+
+	for _, tc := range []struct {
+		path   string
+		name   string
+		prefix bool
+		size   int64
+	}{
+		{"gs://bucket", "bucket", true, 0},
+		{"gs://bucket/a", "a", true, 0},
+		{"gs://bucket/a/c/", "c", true, 0},
+		{"gs://bucket/a/b/f3", "f3", false, 4},
+		{"https://storage.cloud.google.com/bucket/a/f1", "f1", false, 2},
+		{"https://storage.googleapis.com/storage/v1/b/bucket/o/a/f1", "f1", false, 2},
+	} {
+		info, err := fs.Stat(ctx, tc.path)
+		if err != nil {
+			t.Errorf("%v: %v", tc.path, err)
+			continue
+		}
+		if got, want := info.Name, tc.name; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+		if got, want := info.IsPrefix(), tc.prefix; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+		if got, want := info.Size, tc.size; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+	if _, err := fs.Stat(ctx, "gs://bucket/a/nowhere"); err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := fs.Stat(ctx, "gs://nowhere"); err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := fs.Stat(ctx, "s3://bucket/a"); err == nil {
+		t.Errorf("expected an error for a non-gcs path")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(newFakeGCS())
+	defer srv.Close()
+	fs := gcsfs.New(gcsfs.Endpoint(srv.URL))
+	rd, ok := fs.(filewalk.Reader)
+	if !ok {
+		t.Fatalf("%T does not implement filewalk.Reader", fs)
+	}
+	rc, err := rd.Open(ctx, "gs://bucket/a/f2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf), "xxx"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := rd.Open(ctx, "gs://bucket/a/nowhere"); !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := rd.Open(ctx, "gs://private/f0"); !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := rd.Open(ctx, "gs://bucket/a/"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(newFakeGCS())
+	defer srv.Close()
+	fs := gcsfs.New(gcsfs.Endpoint(srv.URL), gcsfs.ScanSize(1))
+
+	var mu sync.Mutex
+	var lines []string
+	wk := filewalk.New(fs, filewalk.Concurrency(2))
+	err := wk.Walk(ctx,
+		func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+			return false, nil, err
+		},
+		func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+			children := []filewalk.Info{}
+			for c := range ch {
+				mu.Lock()
+				for _, f := range c.Files {
+					lines = append(lines, fs.Join(prefix, f.Name))
+				}
+				mu.Unlock()
+				children = append(children, c.Children...)
+			}
+			return children, nil
+		},
+		"gs://bucket",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	if got, want := lines, []string{
+		"gs://bucket/a/b/f3",
+		"gs://bucket/a/c/d/f4",
+		"gs://bucket/a/f1",
+		"gs://bucket/a/f2",
+		"gs://bucket/f0",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSpecialCharacters(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(newFakeGCS())
+	defer srv.Close()
+	fs := gcsfs.New(gcsfs.Endpoint(srv.URL))
+
+	for _, tc := range []struct {
+		prefix, file string
+		size         int64
+	}{
+		{"a#b", "c", 1},
+		{"x?y", "z", 2},
+		{"50%", "z", 3},
+	} {
+		prefix := fs.Join("gs://special", tc.prefix)
+		info, err := fs.Stat(ctx, prefix)
+		if err != nil {
+			t.Errorf("%v: %v", prefix, err)
+			continue
+		}
+		if got, want := info.Name, tc.prefix; got != want || !info.IsPrefix() {
+			t.Errorf("%v: got %v, want %v", prefix, got, want)
+		}
+		contents := listAll(fs, prefix)
+		if got, want := len(contents), 1; got != want {
+			t.Errorf("%v: got %v, want %v", prefix, got, want)
+			continue
+		}
+		if files := contents[0].Files; len(files) != 1 || files[0].Name != tc.file {
+			t.Errorf("%v: got %v, want %v", prefix, files, tc.file)
+		}
+		file := fs.Join(prefix, tc.file)
+		info, err = fs.Stat(ctx, file)
+		if err != nil {
+			t.Errorf("%v: %v", file, err)
+			continue
+		}
+		if got, want := info.Size, tc.size; got != want {
+			t.Errorf("%v: got %v, want %v", file, got, want)
+		}
+		rc, err := fs.(filewalk.Reader).Open(ctx, file)
+		if err != nil {
+			t.Errorf("%v: %v", file, err)
+			continue
+		}
+		buf, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if got, want := len(buf), int(tc.size); got != want {
+			t.Errorf("%v: got %v, want %v", file, got, want)
+		}
+	}
+}
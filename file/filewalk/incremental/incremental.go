@@ -0,0 +1,186 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package incremental provides a filewalk.PrefixFunc and filewalk.ContentsFunc
+// pair that use a filewalk.Database to avoid listing prefixes that have not
+// changed since they were last scanned. A prefix is considered unchanged if
+// its modification time is the same as that stored in the database, in
+// which case the children and files stored for it are reused. Note that
+// this is only effective for filesystems, such as local ones, that update
+// the modification time of a prefix when entries are added to or removed
+// from it, and that changes to the contents of files within an unchanged
+// prefix will not be detected.
+package incremental
+
+import (
+	"context"
+	"sync/atomic"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/diskusage"
+	"cloudeng.io/file/filewalk"
+)
+
+// VisitFunc is called for every prefix encountered with the PrefixInfo
+// that is stored for it in the database, either as reused or as newly
+// refreshed.
+type VisitFunc func(ctx context.Context, prefix string, info *filewalk.PrefixInfo, refreshed bool) error
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	visit      VisitFunc
+	calculator diskusage.Calculator
+}
+
+// Visit sets the function to be called for every prefix.
+func Visit(fn VisitFunc) Option {
+	return func(o *options) {
+		o.visit = fn
+	}
+}
+
+// Calculator sets the diskusage.Calculator used to compute the disk usage
+// of refreshed prefixes. The default is diskusage.NewIdentity.
+func Calculator(calc diskusage.Calculator) Option {
+	return func(o *options) {
+		o.calculator = calc
+	}
+}
+
+// Stats records the number of prefixes that were skipped, that is, not
+// listed because they were unchanged, and refreshed, that is, listed and
+// updated in the database.
+type Stats struct {
+	Skipped   int64
+	Refreshed int64
+}
+
+// Updater provides methods that may be passed to filewalk.Walker.Walk
+// as its PrefixFunc and ContentsFunc in order to update a database
+// incrementally.
+type Updater struct {
+	opts      options
+	fs        filewalk.Filesystem
+	db        filewalk.Database
+	separator string
+	skipped   int64
+	refreshed int64
+}
+
+// New returns a new Updater for fs and db.
+func New(fs filewalk.Filesystem, db filewalk.Database, opts ...Option) *Updater {
+	u := &Updater{fs: fs, db: db}
+	u.opts.calculator = diskusage.NewIdentity()
+	for _, fn := range opts {
+		fn(&u.opts)
+	}
+	u.separator = filewalk.Separator(fs)
+	return u
+}
+
+// Stats returns the number of prefixes skipped and refreshed so far.
+func (u *Updater) Stats() Stats {
+	return Stats{
+		Skipped:   atomic.LoadInt64(&u.skipped),
+		Refreshed: atomic.LoadInt64(&u.refreshed),
+	}
+}
+
+// PrefixFunc implements filewalk.PrefixFunc. It returns the children stored
+// in the database for prefixes whose modification time is unchanged so
+// that they are traversed without the prefix being listed.
+func (u *Updater) PrefixFunc(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+	if err != nil {
+		return true, nil, err
+	}
+	var stored filewalk.PrefixInfo
+	ok, err := u.db.Get(ctx, prefix, &stored)
+	if err != nil || !ok || len(stored.Err) > 0 || !stored.ModTime.Equal(info.ModTime) {
+		return false, nil, err
+	}
+	atomic.AddInt64(&u.skipped, 1)
+	if u.opts.visit != nil {
+		if err := u.opts.visit(ctx, prefix, &stored, false); err != nil {
+			return true, nil, err
+		}
+	}
+	var children []filewalk.Info
+	for _, child := range stored.Children {
+		if child.IsPrefix() {
+			children = append(children, child)
+		}
+	}
+	// There is nothing to traverse, and returning no children would
+	// otherwise result in the prefix being listed.
+	return len(children) == 0, children, nil
+}
+
+// ContentsFunc implements filewalk.ContentsFunc. It records the contents
+// of each prefix that is listed in the database and deletes any children
+// previously stored for it that no longer exist.
+func (u *Updater) ContentsFunc(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+	pi := filewalk.PrefixInfo{
+		ModTime:  info.ModTime,
+		Size:     info.Size,
+		UserID:   info.UserID,
+		GroupID:  info.GroupID,
+		Mode:     info.Mode,
+		Children: []filewalk.Info{},
+		Files:    []filewalk.Info{},
+	}
+	listErrs := &errors.M{}
+	for c := range ch {
+		if c.Err != nil {
+			listErrs.Append(c.Err)
+			continue
+		}
+		pi.Children = append(pi.Children, c.Children...)
+		pi.Files = append(pi.Files, c.Files...)
+	}
+	for _, f := range pi.Files {
+		pi.DiskUsage += u.opts.calculator.Calculate(f.Size)
+	}
+	if err := listErrs.Err(); err != nil {
+		pi.Err = err.Error()
+	}
+	errs := &errors.M{}
+	errs.Append(u.deleteRemoved(ctx, prefix, pi.Children))
+	errs.Append(u.db.Set(ctx, prefix, &pi))
+	atomic.AddInt64(&u.refreshed, 1)
+	if u.opts.visit != nil {
+		errs.Append(u.opts.visit(ctx, prefix, &pi, true))
+	}
+	return pi.Children, errs.Err()
+}
+
+func (u *Updater) deleteRemoved(ctx context.Context, prefix string, children []filewalk.Info) error {
+	var stored filewalk.PrefixInfo
+	ok, err := u.db.Get(ctx, prefix, &stored)
+	if err != nil || !ok {
+		return err
+	}
+	current := make(map[string]bool, len(children))
+	for _, child := range children {
+		current[child.Name] = true
+	}
+	var removed []string
+	for _, child := range stored.Children {
+		if current[child.Name] || !child.IsPrefix() {
+			continue
+		}
+		// Only children that were themselves stored can be deleted.
+		p := u.fs.Join(prefix, child.Name)
+		var existing filewalk.PrefixInfo
+		if ok, _ := u.db.Get(ctx, p, &existing); ok {
+			removed = append(removed, p)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	_, err = u.db.Delete(ctx, u.separator, removed, true)
+	return err
+}
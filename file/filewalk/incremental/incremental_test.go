@@ -0,0 +1,130 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package incremental_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/diskusage"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/incremental"
+	"cloudeng.io/file/filewalk/localdb"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+const tree = `
+a0/
+  f0 size=10
+  a0.0/
+    f1 size=20
+b0/
+  b0.0/
+    f2 size=30
+  b0.1/
+c0/
+`
+
+type visits struct {
+	sync.Mutex
+	files     []string
+	refreshed []string
+}
+
+func (v *visits) visit(ctx context.Context, prefix string, pi *filewalk.PrefixInfo, refreshed bool) error {
+	v.Lock()
+	defer v.Unlock()
+	for _, f := range pi.Files {
+		v.files = append(v.files, prefix+"/"+f.Name)
+	}
+	if refreshed {
+		v.refreshed = append(v.refreshed, prefix)
+	}
+	return nil
+}
+
+func (v *visits) sorted() ([]string, []string) {
+	sort.Strings(v.files)
+	sort.Strings(v.refreshed)
+	return v.files, v.refreshed
+}
+
+func TestIncremental(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := localdb.Open(ctx, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+
+	walk := func(stats incremental.Stats, files, refreshed []string) {
+		t.Helper()
+		v := &visits{}
+		u := incremental.New(fs, db,
+			incremental.Visit(v.visit),
+			incremental.Calculator(diskusage.NewSimple(16)))
+		if err := filewalk.New(fs).Walk(ctx, u.PrefixFunc, u.ContentsFunc, "/"); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := u.Stats(), stats; got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+		gotFiles, gotRefreshed := v.sorted()
+		if got, want := gotFiles, files; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := gotRefreshed, refreshed; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	all := []string{"/", "/a0", "/a0/a0.0", "/b0", "/b0/b0.0", "/b0/b0.1", "/c0"}
+	files := []string{"/a0/a0.0/f1", "/a0/f0", "/b0/b0.0/f2"}
+	walk(incremental.Stats{Refreshed: 7}, files, all)
+	walk(incremental.Stats{Skipped: 7}, files, nil)
+
+	var pi filewalk.PrefixInfo
+	if ok, err := db.Get(ctx, "/b0/b0.0", &pi); !ok || err != nil {
+		t.Fatalf("missing entry: %v", err)
+	}
+	if got, want := pi.DiskUsage, int64(32); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Only prefixes whose modification time changes are refreshed.
+	now := time.Now()
+	if err := fs.AddFile("/a0/f3", filewalk.Info{Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.AddPrefix("/a0", filewalk.Info{ModTime: now}); err != nil {
+		t.Fatal(err)
+	}
+	files = []string{"/a0/a0.0/f1", "/a0/f0", "/a0/f3", "/b0/b0.0/f2"}
+	walk(incremental.Stats{Skipped: 6, Refreshed: 1}, files, []string{"/a0"})
+	walk(incremental.Stats{Skipped: 7}, files, nil)
+
+	// Removed prefixes are deleted from the database.
+	if err := fs.Remove("/b0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.AddPrefix("/", filewalk.Info{ModTime: now}); err != nil {
+		t.Fatal(err)
+	}
+	files = []string{"/a0/a0.0/f1", "/a0/f0", "/a0/f3"}
+	walk(incremental.Stats{Skipped: 3, Refreshed: 1}, files, []string{"/"})
+	for _, p := range []string{"/b0", "/b0/b0.0", "/b0/b0.1"} {
+		if ok, err := db.Get(ctx, p, &pi); ok || err != nil {
+			t.Errorf("%v: entry should have been deleted: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloudeng.io/errors"
+)
+
+// Reader is an optional interface that may be implemented by a Filesystem
+// that can provide access to the contents of files.
+type Reader interface {
+	// Open returns a reader for the contents of the specified file.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+type fromFS struct {
+	fsys     fs.FS
+	scanSize int
+}
+
+// FromFS returns a Filesystem that traverses the supplied fs.FS, for example
+// an embed.FS, zip.Reader or fstest.MapFS. Paths are interpreted as per
+// fs.ValidPath with the root of the fs.FS being named "."; any leading /
+// is ignored. scanSize determines the number of entries sent in each
+// Contents message by List if the fs.FS supports incremental reads of
+// directories via fs.ReadDirFile. The returned Filesystem also implements
+// Reader.
+func FromFS(fsys fs.FS, scanSize int) Filesystem {
+	return &fromFS{fsys: fsys, scanSize: scanSize}
+}
+
+func fsPath(p string) string {
+	p = strings.TrimPrefix(path.Clean(p), "/")
+	if len(p) == 0 {
+		return "."
+	}
+	return p
+}
+
+func (f *fromFS) Stat(ctx context.Context, p string) (Info, error) {
+	info, err := fs.Stat(f.fsys, fsPath(p))
+	if err != nil {
+		return Info{}, err
+	}
+	return createInfo(info), nil
+}
+
+func (f *fromFS) entriesToContents(p string, entries []fs.DirEntry) Contents {
+	contents := Contents{Path: p}
+	errs := &errors.M{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		if entry.IsDir() {
+			contents.Children = append(contents.Children, createInfo(info))
+			continue
+		}
+		contents.Files = append(contents.Files, createInfo(info))
+	}
+	contents.Err = errs.Err()
+	return contents
+}
+
+func (f *fromFS) List(ctx context.Context, p string, ch chan<- Contents) {
+	name := fsPath(p)
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		ch <- Contents{Path: p, Err: err}
+		return
+	}
+	defer file.Close()
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok || f.scanSize <= 0 {
+		entries, err := fs.ReadDir(f.fsys, name)
+		if len(entries) > 0 {
+			ch <- f.entriesToContents(p, entries)
+		}
+		if err != nil {
+			ch <- Contents{Path: p, Err: err}
+		}
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			ch <- Contents{Path: p, Err: ctx.Err()}
+			return
+		default:
+		}
+		entries, err := dir.ReadDir(f.scanSize)
+		if len(entries) > 0 {
+			ch <- f.entriesToContents(p, entries)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			ch <- Contents{Path: p, Err: err}
+			return
+		}
+		if len(entries) == 0 {
+			return
+		}
+	}
+}
+
+func (f *fromFS) Join(components ...string) string {
+	return path.Join(components...)
+}
+
+func (f *fromFS) IsPermissionError(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+func (f *fromFS) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+func (f *fromFS) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	return f.fsys.Open(fsPath(p))
+}
+
+type toFS struct {
+	ctx  context.Context
+	fs   Filesystem
+	root string
+}
+
+// ToFS returns an fs.FS that provides access to the supplied Filesystem
+// rooted at root, ie. the fs.FS name "a/b" refers to Join(root, "a", "b").
+// The returned fs.FS implements fs.StatFS and fs.ReadDirFS. Open
+// may always be used for prefixes, but will only succeed for files
+// if the Filesystem also implements Reader.
+func ToFS(filesystem Filesystem, root string) fs.FS {
+	return &toFS{ctx: context.Background(), fs: filesystem, root: root}
+}
+
+func (t *toFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return t.root, nil
+	}
+	return t.fs.Join(append([]string{t.root}, strings.Split(name, "/")...)...), nil
+}
+
+func (t *toFS) translateError(op, name string, err error) error {
+	switch {
+	case t.fs.IsNotExist(err):
+		err = fs.ErrNotExist
+	case t.fs.IsPermissionError(err):
+		err = fs.ErrPermission
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Stat implements fs.StatFS.
+func (t *toFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := t.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := t.fs.Stat(t.ctx, p)
+	if err != nil {
+		return nil, t.translateError("stat", name, err)
+	}
+	if name == "." {
+		info.Name = "."
+	}
+	return &fileInfo{info}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (t *toFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := t.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	d := newDirFile(t, name, p, Info{})
+	defer d.Close()
+	return d.ReadDir(-1)
+}
+
+// Open implements fs.FS.
+func (t *toFS) Open(name string) (fs.File, error) {
+	p, err := t.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := t.fs.Stat(t.ctx, p)
+	if err != nil {
+		return nil, t.translateError("open", name, err)
+	}
+	if name == "." {
+		info.Name = "."
+	}
+	if info.IsPrefix() {
+		return newDirFile(t, name, p, info), nil
+	}
+	rd, ok := t.fs.(Reader)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("filesystem does not support reading files")}
+	}
+	rc, err := rd.Open(t.ctx, p)
+	if err != nil {
+		return nil, t.translateError("open", name, err)
+	}
+	return &file{info: info, rc: rc}, nil
+}
+
+type fileInfo struct {
+	info Info
+}
+
+func (fi *fileInfo) Name() string       { return fi.info.Name }
+func (fi *fileInfo) Size() int64        { return fi.info.Size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fs.FileMode(fi.info.Mode) }
+func (fi *fileInfo) ModTime() time.Time { return fi.info.ModTime }
+func (fi *fileInfo) IsDir() bool        { return fi.info.IsPrefix() }
+func (fi *fileInfo) Sys() interface{}   { return fi.info.Sys() }
+
+func (fi *fileInfo) Type() fs.FileMode          { return fs.FileMode(fi.info.Mode).Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+type file struct {
+	info Info
+	rc   io.ReadCloser
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return &fileInfo{f.info}, nil }
+func (f *file) Read(b []byte) (int, error) { return f.rc.Read(b) }
+func (f *file) Close() error               { return f.rc.Close() }
+
+// dirFile implements fs.ReadDirFile by consuming the Contents sent
+// by Filesystem.List.
+type dirFile struct {
+	t       *toFS
+	name    string
+	path    string
+	info    Info
+	cancel  func()
+	ch      chan Contents
+	pending []fs.DirEntry
+	err     error
+	done    bool
+}
+
+func newDirFile(t *toFS, name, p string, info Info) *dirFile {
+	ctx, cancel := context.WithCancel(t.ctx)
+	d := &dirFile{t: t, name: name, path: p, info: info, cancel: cancel, ch: make(chan Contents, 1)}
+	go func() {
+		t.fs.List(ctx, p, d.ch)
+		close(d.ch)
+	}()
+	return d
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return &fileInfo{d.info}, nil
+}
+
+func (d *dirFile) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) Close() error {
+	d.cancel()
+	for range d.ch {
+	}
+	return nil
+}
+
+// fill reads from the List channel until at least n entries are pending,
+// or until the channel is closed or an error is encountered. n <= 0 reads
+// all entries.
+func (d *dirFile) fill(n int) {
+	for !d.done && d.err == nil && (n <= 0 || len(d.pending) < n) {
+		c, ok := <-d.ch
+		if !ok {
+			d.done = true
+			return
+		}
+		for _, i := range c.Children {
+			d.pending = append(d.pending, &fileInfo{i})
+		}
+		for _, i := range c.Files {
+			d.pending = append(d.pending, &fileInfo{i})
+		}
+		if c.Err != nil {
+			d.err = d.t.translateError("readdir", d.name, c.Err)
+		}
+	}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	d.fill(n)
+	if n <= 0 {
+		entries := d.pending
+		d.pending = nil
+		if entries == nil {
+			entries = []fs.DirEntry{}
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+		return entries, d.err
+	}
+	if len(d.pending) == 0 {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, io.EOF
+	}
+	if n > len(d.pending) {
+		n = len(d.pending)
+	}
+	entries := d.pending[:n]
+	d.pending = d.pending[n:]
+	return entries, nil
+}
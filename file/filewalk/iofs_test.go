@@ -0,0 +1,162 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+var mapFS = fstest.MapFS{
+	"f0":          {Data: []byte("0")},
+	"a0/f1":       {Data: []byte("11")},
+	"a0/f2":       {Data: []byte("222")},
+	"a0/a1/f3":    {Data: []byte("3333")},
+	"b0/b1/b2/f4": {Data: []byte("44444")},
+}
+
+func walkFS(t *testing.T, fs filewalk.Filesystem, root string) string {
+	lg := &logger{prefix: root, children: map[string][]filewalk.Info{}}
+	wk := filewalk.New(fs, filewalk.Concurrency(2))
+	if err := wk.Walk(context.Background(), lg.dirsFunc, lg.filesFunc, root); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lg.lines)
+	return strings.Join(lg.lines, "")
+}
+
+const expectedMapFSWalk = `*
+a0*
+a0/a1*
+a0/a1/f3: 4
+a0/f1: 2
+a0/f2: 3
+b0*
+b0/b1*
+b0/b1/b2*
+b0/b1/b2/f4: 5
+f0: 1
+`
+
+func TestFromFS(t *testing.T) {
+	ctx := context.Background()
+	fs := filewalk.FromFS(mapFS, 1)
+	if got, want := walkFS(t, fs, "/"), expectedMapFSWalk; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	info, err := fs.Stat(ctx, "a0/f2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Size, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := fs.Stat(ctx, "a0/nowhere"); err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+
+	rc, err := fs.(filewalk.Reader).Open(ctx, "/a0/a1/f3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf), "3333"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	zbuf := &bytes.Buffer{}
+	zw := zip.NewWriter(zbuf)
+	for _, name := range []string{"f0", "a0/f1", "a0/f2", "a0/a1/f3", "b0/b1/b2/f4"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(mapFS[name].Data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := walkFS(t, filewalk.FromFS(zr, 0), "/"), expectedMapFSWalk; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestToFS(t *testing.T) {
+	// A round trip via FromFS supports reading files.
+	fsys := filewalk.ToFS(filewalk.FromFS(mapFS, 2), ".")
+	if err := fstest.TestFS(fsys, "f0", "a0/f1", "a0/a1/f3", "b0/b1/b2/f4"); err != nil {
+		t.Fatal(err)
+	}
+
+	mfs, err := memfs.Parse(`
+a0/
+  f0 3
+  f1 4
+b0/ list-err=permission
+`, memfs.ListBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys = filewalk.ToFS(mfs, "/")
+	var found []string
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			found = append(found, path+": "+err.Error())
+			return nil
+		}
+		found = append(found, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found, []string{".", "a0", "a0/f0", "a0/f1", "b0", "b0: readdir b0: permission denied"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	fi, err := fs.Stat(fsys, "a0/f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fi.Size(), int64(4); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := fs.Stat(fsys, "a0/nowhere"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	buf, err := fs.ReadFile(fsys, "a0/f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(buf), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Open fails for a Filesystem that does not implement Reader.
+	fsys = filewalk.ToFS(struct{ filewalk.Filesystem }{mfs}, "/")
+	if _, err := fsys.Open("a0/f1"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
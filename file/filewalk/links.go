@@ -0,0 +1,102 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"context"
+
+	"cloudeng.io/errors"
+)
+
+// ErrLinkCycle is wrapped by the error passed to PrefixFunc for a symbolic
+// link that refers to one of its own ancestors when the FollowLinks
+// option is in effect.
+var ErrLinkCycle = errors.New("symbolic link cycle")
+
+// ancestor records the device and inode numbers of the prefixes between
+// a root and the prefix currently being walked.
+type ancestor struct {
+	device, inode uint64
+	parent        *ancestor
+}
+
+func (a *ancestor) contains(device, inode uint64) bool {
+	for ; a != nil; a = a.parent {
+		if a.device == device && a.inode == inode {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Walker) linkFollower() (LinkFollower, bool) {
+	if !w.opts.followLinks {
+		return nil, false
+	}
+	lf, ok := w.fs.(LinkFollower)
+	return lf, ok
+}
+
+// linkedInfo returns the Info to be used for a link to a prefix, ie. that
+// of its target, but with the link's name and ModeLink set.
+func linkedInfo(link, target Info) Info {
+	target.Name = link.Name
+	target.Mode |= ModeLink
+	return target
+}
+
+// followLink returns the Info for the target of path if it is a symbolic
+// link to a prefix, along with the ancestors to be used for its children.
+// It returns an error wrapping ErrLinkCycle if path refers to one of
+// its own ancestors.
+func (w *Walker) followLink(ctx context.Context, lf LinkFollower, path string, info Info, parents *ancestor) (Info, *ancestor, error) {
+	if info.IsLink() {
+		target, err := lf.StatFollow(ctx, path)
+		if err != nil || !target.IsPrefix() {
+			return info, parents, nil
+		}
+		info = linkedInfo(info, target)
+	}
+	device, inode, ok := lf.FileID(info)
+	if !ok {
+		return info, parents, nil
+	}
+	if parents.contains(device, inode) {
+		return info, parents, &Error{Path: path, Op: "follow", Err: ErrLinkCycle}
+	}
+	return info, &ancestor{device: device, inode: inode, parent: parents}, nil
+}
+
+// listFollowingLinks is like Filesystem.List except that symbolic links
+// to prefixes are returned as children with both ModePrefix and ModeLink
+// set.
+func (w *Walker) listFollowingLinks(ctx context.Context, lf LinkFollower, path string, ch chan<- Contents) {
+	lch := make(chan Contents, cap(ch))
+	go func() {
+		w.fs.List(ctx, path, lch)
+		close(lch)
+	}()
+	for contents := range lch {
+		// The slices may be retained by the underlying Filesystem, eg.
+		// if it is a cache, and hence new ones are created here rather
+		// than modifying them in place.
+		children := append([]Info{}, contents.Children...)
+		files := make([]Info, 0, len(contents.Files))
+		for _, file := range contents.Files {
+			if !file.IsLink() {
+				files = append(files, file)
+				continue
+			}
+			target, err := lf.StatFollow(ctx, w.fs.Join(path, file.Name))
+			if err != nil || !target.IsPrefix() {
+				files = append(files, file)
+				continue
+			}
+			children = append(children, linkedInfo(file, target))
+		}
+		contents.Children, contents.Files = children, files
+		ch <- contents
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 type local struct {
 	scanSize int
+	xattrs   bool
 }
 
 func createInfo(i os.FileInfo) Info {
@@ -23,6 +24,8 @@ func createInfo(i os.FileInfo) Info {
 		sys:     i,
 	}
 	info.UserID, info.GroupID = getUserAndGroupID(i.Sys())
+	info.Device, _, _ = getDeviceAndInode(i.Sys())
+	info.Metadata = getMetadata(i.Sys())
 	m := i.Mode()
 	info.Mode = FileMode(m&os.ModePerm | m&os.ModeSymlink | m&os.ModeDir)
 	return info
@@ -47,10 +50,10 @@ func (l *local) List(ctx context.Context, path string, ch chan<- Contents) {
 			dirs := make([]Info, 0, 10)
 			for _, info := range infos {
 				if info.IsDir() {
-					dirs = append(dirs, createInfo(info))
+					dirs = append(dirs, l.createInfo(filepath.Join(path, info.Name()), info))
 					continue
 				}
-				files = append(files, createInfo(info))
+				files = append(files, l.createInfo(filepath.Join(path, info.Name()), info))
 			}
 			ch <- Contents{
 				Path:     path,
@@ -69,12 +72,45 @@ func (l *local) List(ctx context.Context, path string, ch chan<- Contents) {
 	}
 }
 
+// createInfo is like the createInfo function but will also read extended
+// attributes if requested.
+func (l *local) createInfo(path string, i os.FileInfo) Info {
+	info := createInfo(i)
+	if l.xattrs && info.Metadata != nil && !info.IsLink() {
+		info.Metadata.XAttrs = xattrs(path)
+	}
+	return info
+}
+
 func (l *local) Stat(ctx context.Context, path string) (Info, error) {
 	info, err := os.Lstat(path)
 	if err != nil {
 		return Info{}, err
 	}
-	return createInfo(info), nil
+	return l.createInfo(path, info), nil
+}
+
+// StatFollow implements LinkFollower.
+func (l *local) StatFollow(ctx context.Context, path string) (Info, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return l.createInfo(path, info), nil
+}
+
+// FileID implements LinkFollower.
+func (l *local) FileID(info Info) (uint64, uint64, bool) {
+	fi, ok := info.Sys().(os.FileInfo)
+	if !ok {
+		return 0, 0, false
+	}
+	return getDeviceAndInode(fi.Sys())
+}
+
+// Open implements Reader.
+func (l *local) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(path)
 }
 
 func (l *local) Join(components ...string) string {
@@ -89,6 +125,13 @@ func (l *local) IsNotExist(err error) bool {
 	return os.IsNotExist(err)
 }
 
-func LocalFilesystem(scanSize int) Filesystem {
-	return &local{scanSize: scanSize}
+// LocalFilesystem returns a Filesystem for the local filesystem that lists
+// at most scanSize entries at a time. The Info for each file and prefix
+// includes extended POSIX metadata on systems that support it.
+func LocalFilesystem(scanSize int, opts ...LocalOption) Filesystem {
+	l := &local{scanSize: scanSize}
+	for _, fn := range opts {
+		fn(l)
+	}
+	return l
 }
@@ -0,0 +1,41 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+)
+
+func TestLocalXAttrs(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "f")
+	if err := ioutil.WriteFile(name, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(name, "user.test", []byte("value"), 0); err != nil {
+		t.Skipf("extended attributes are not supported: %v", err)
+	}
+	info, err := filewalk.LocalFilesystem(10).Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata.XAttrs != nil {
+		t.Errorf("extended attributes should not be read by default")
+	}
+	info, err = filewalk.LocalFilesystem(10, filewalk.ReadXAttrs(true)).Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Metadata.XAttrs, map[string][]byte{"user.test": []byte("value")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
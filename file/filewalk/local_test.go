@@ -13,6 +13,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"syscall"
 	"testing"
 
 	"cloudeng.io/errors"
@@ -90,6 +91,9 @@ func TestLocalFilesystem(t *testing.T) {
 		if got, want := info[d].IsLink(), false; got != want {
 			t.Errorf("%v: got %v, want %v", d, got, want)
 		}
+		if info[d].Device == 0 {
+			t.Errorf("%v: missing device id", d)
+		}
 	}
 
 	for _, f := range expectedFileNames {
@@ -149,3 +153,65 @@ func createTestDir(tmpDir string) error {
 	errs.Append(err)
 	return errs.Err()
 }
+
+func TestLocalOpen(t *testing.T) {
+	ctx := context.Background()
+	sc := filewalk.LocalFilesystem(1)
+	rd, ok := sc.(filewalk.Reader)
+	if !ok {
+		t.Fatalf("%T does not implement filewalk.Reader", sc)
+	}
+	rc, err := rd.Open(ctx, sc.Join(localTestTree, "a0", "f0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf), "123"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := rd.Open(ctx, sc.Join(localTestTree, "nowhere")); err == nil || !sc.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+func TestLocalMetadata(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "f")
+	if err := ioutil.WriteFile(name, make([]byte, 8192), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(name, filepath.Join(dir, "g")); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := filewalk.LocalFilesystem(10)
+	info, err := sc.Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := info.Metadata
+	if md == nil {
+		t.Fatalf("missing metadata")
+	}
+	if got, want := md.Inode, fi.Sys().(*syscall.Stat_t).Ino; got != uint64(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := md.Links, uint64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if md.Blocks == 0 || md.AccessTime.IsZero() || md.ChangeTime.IsZero() {
+		t.Errorf("missing metadata: %+v", md)
+	}
+	_, _, _, listed := scan(sc, make(chan filewalk.Contents, 1), dir)
+	if got, want := listed["g"].Metadata, md; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
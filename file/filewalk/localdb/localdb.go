@@ -30,6 +30,7 @@ const (
 	globalStatsKey   = "__globalStats"
 	usersListKey     = "__userList"
 	groupsListKey    = "__groupList"
+	checkpointKey    = "__checkpoint"
 	prefixdbFilename = "prefix.pudge"
 	statsdbFilename  = "stats.pudge"
 	userdbFilename   = "users.pudge"
@@ -425,6 +426,26 @@ func (db *Database) Get(ctx context.Context, prefix string, info *filewalk.Prefi
 	return true, nil
 }
 
+// SaveCheckpoint implements filewalk.Checkpointer.
+func (db *Database) SaveCheckpoint(ctx context.Context, cp *filewalk.Checkpoint) error {
+	if db.opts.readOnly {
+		return ErrReadonly
+	}
+	return db.statsdb.Set(checkpointKey, cp)
+}
+
+// LoadCheckpoint implements filewalk.Checkpointer.
+func (db *Database) LoadCheckpoint(ctx context.Context) (*filewalk.Checkpoint, error) {
+	cp := &filewalk.Checkpoint{}
+	if err := db.statsdb.Get(checkpointKey, cp); err != nil {
+		if err == pudge.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cp, nil
+}
+
 func (db *Database) Delete(ctx context.Context, separator string, prefixes []string, recurse bool) (int, error) {
 	errs := &errors.M{}
 	deletions := make([]interface{}, 0, len(prefixes))
@@ -261,3 +261,80 @@ func TestScanning(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dbDir := filepath.Join(t.TempDir(), "checkpoint")
+	db, err := localdb.Open(ctx, dbDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpr, ok := db.(filewalk.Checkpointer)
+	if !ok {
+		t.Fatalf("%T does not implement filewalk.Checkpointer", db)
+	}
+	cp, err := cpr.LoadCheckpoint(ctx)
+	if err != nil || cp != nil {
+		t.Fatalf("unexpected checkpoint or error: %v, %v", cp, err)
+	}
+	saved := &filewalk.Checkpoint{
+		Roots:      []string{"/a"},
+		Pending:    []string{"/a/b", "/a/c"},
+		InProgress: []string{"/a/d"},
+		Completed:  3,
+		When:       time.Now().Round(0),
+	}
+	if err := cpr.SaveCheckpoint(ctx, saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = localdb.Open(ctx, dbDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+	cp, err = db.(filewalk.Checkpointer).LoadCheckpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cp, saved; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	db, err := localdb.Open(ctx, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+	now := time.Now().Round(0)
+	file := filewalk.Info{
+		Name:    "f",
+		ModTime: now,
+		Device:  3,
+		Metadata: &filewalk.Metadata{
+			Inode:      42,
+			Links:      1,
+			Blocks:     8,
+			AccessTime: now,
+			ChangeTime: now,
+		},
+	}
+	pi := filewalk.PrefixInfo{ModTime: now, Files: []filewalk.Info{file}}
+	if err := db.Set(ctx, "/a", &pi); err != nil {
+		t.Fatal(err)
+	}
+	sc := db.NewScanner("", 0)
+	if !sc.Scan(ctx) {
+		t.Fatalf("scan failed: %v", sc.Err())
+	}
+	_, scanned := sc.PrefixInfo()
+	if got, want := scanned.Files, pi.Files; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
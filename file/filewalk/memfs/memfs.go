@@ -0,0 +1,414 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package memfs provides an in-memory implementation of filewalk.Filesystem.
+// It is intended both for testing PrefixFunc and ContentsFunc
+// implementations without having to create real directory trees and for
+// production use as an index of hierarchies that are read into memory,
+// such as the contents of the archives walked by
+// cloudeng.io/file/filewalk/archivefs. The contents of the filesystem may
+// be specified via a map, a builder API or a textual description of the
+// tree. Errors may be injected for any path and the number of entries
+// returned by each Contents message sent by List is configurable so that
+// paging and error handling can be reproduced exactly.
+package memfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+)
+
+// Op identifies the Filesystem operation that an injected error applies to.
+type Op int
+
+const (
+	// Stat refers to Filesystem.Stat.
+	Stat Op = iota
+	// List refers to Filesystem.List.
+	List
+	// Open refers to filewalk.Reader.Open.
+	Open
+)
+
+// String implements stringer.
+func (op Op) String() string {
+	switch op {
+	case Stat:
+		return "stat"
+	case List:
+		return "list"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("unknown op: %d", int(op))
+}
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	batchSize int
+}
+
+// ListBatchSize sets the maximum number of entries, children and files
+// combined, returned in each Contents message sent by List. A size of
+// zero or less will result in all entries being returned in a single
+// message. The default is zero.
+func ListBatchSize(n int) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
+
+type node struct {
+	info     filewalk.Info
+	data     []byte
+	children map[string]*node
+}
+
+// FS represents an in-memory filesystem. It is safe for concurrent use.
+type FS struct {
+	opts     options
+	mu       sync.RWMutex
+	root     *node
+	injected map[Op]map[string]error
+}
+
+// New creates a new, empty, instance of FS that contains only the root
+// prefix, "/".
+func New(opts ...Option) *FS {
+	fs := &FS{
+		root: &node{
+			info: filewalk.Info{
+				Name: "/",
+				Mode: filewalk.ModePrefix | 0700,
+			},
+			children: map[string]*node{},
+		},
+		injected: map[Op]map[string]error{
+			Stat: {},
+			List: {},
+			Open: {},
+		},
+	}
+	for _, fn := range opts {
+		fn(&fs.opts)
+	}
+	return fs
+}
+
+// NewFromMap creates a new instance of FS populated with the supplied
+// entries which are keyed by their full path. Entries whose Info has
+// ModePrefix set are created as prefixes, all others as files. Any
+// intermediate prefixes that are not explicitly specified are created
+// automatically.
+func NewFromMap(entries map[string]filewalk.Info, opts ...Option) (*FS, error) {
+	fs := New(opts...)
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	// Add entries in lexical order so that explicitly specified prefixes
+	// are created before the entries they contain.
+	sort.Strings(paths)
+	for _, p := range paths {
+		info := entries[p]
+		var err error
+		if info.IsPrefix() {
+			err = fs.AddPrefix(p, info)
+		} else {
+			err = fs.AddFile(p, info)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// lookup returns the node for the specified path; the caller must hold
+// fs.mu.
+func (fs *FS) lookup(p string) *node {
+	n := fs.root
+	for _, c := range strings.Split(strings.TrimPrefix(cleanPath(p), "/"), "/") {
+		if len(c) == 0 {
+			continue
+		}
+		if n = n.children[c]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// parent returns the node for the parent of the specified path, creating
+// any intermediate prefixes as required; the caller must hold fs.mu.
+func (fs *FS) parent(p string) (*node, error) {
+	dir := path.Dir(p)
+	n := fs.root
+	for _, c := range strings.Split(strings.TrimPrefix(dir, "/"), "/") {
+		if len(c) == 0 {
+			continue
+		}
+		next := n.children[c]
+		if next == nil {
+			next = &node{
+				info: filewalk.Info{
+					Name: c,
+					Mode: filewalk.ModePrefix | 0700,
+				},
+				children: map[string]*node{},
+			}
+			n.children[c] = next
+		}
+		if !next.info.IsPrefix() {
+			return nil, fmt.Errorf("%v: %v is not a prefix", p, c)
+		}
+		n = next
+	}
+	return n, nil
+}
+
+func (fs *FS) add(p string, info filewalk.Info, prefix bool) error {
+	p = cleanPath(p)
+	if p == "/" {
+		if !prefix {
+			return fmt.Errorf("root must be a prefix")
+		}
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		info.Name = "/"
+		info.Mode |= filewalk.ModePrefix
+		fs.root.info = info
+		return nil
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	parent, err := fs.parent(p)
+	if err != nil {
+		return err
+	}
+	name := path.Base(p)
+	info.Name = name
+	existing := parent.children[name]
+	if prefix {
+		info.Mode |= filewalk.ModePrefix
+		if existing != nil {
+			if !existing.info.IsPrefix() {
+				return fmt.Errorf("%v: already exists as a file", p)
+			}
+			existing.info = info
+			return nil
+		}
+		parent.children[name] = &node{info: info, children: map[string]*node{}}
+		return nil
+	}
+	info.Mode &^= filewalk.ModePrefix
+	if existing != nil && existing.info.IsPrefix() {
+		return fmt.Errorf("%v: already exists as a prefix", p)
+	}
+	parent.children[name] = &node{info: info}
+	return nil
+}
+
+// AddPrefix adds a prefix with the specified info, creating any intermediate
+// prefixes as required. info.Name is ignored and ModePrefix is always set.
+// If the prefix already exists its info is replaced.
+func (fs *FS) AddPrefix(p string, info filewalk.Info) error {
+	return fs.add(p, info, true)
+}
+
+// AddFile adds a file with the specified info, creating any intermediate
+// prefixes as required. info.Name is ignored and ModePrefix is always
+// cleared.
+func (fs *FS) AddFile(p string, info filewalk.Info) error {
+	return fs.add(p, info, false)
+}
+
+// Remove removes the file or prefix at the specified path, including,
+// for a prefix, everything below it.
+func (fs *FS) Remove(p string) error {
+	p = cleanPath(p)
+	if p == "/" {
+		return fmt.Errorf("root cannot be removed")
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	parent := fs.lookup(path.Dir(p))
+	if parent == nil || parent.children[path.Base(p)] == nil {
+		return &os.PathError{Op: "remove", Path: p, Err: os.ErrNotExist}
+	}
+	delete(parent.children, path.Base(p))
+	return nil
+}
+
+// SetContents sets the contents of an existing file and updates its size
+// accordingly. The contents of files for which SetContents has not been
+// called consist of as many zero bytes as their size.
+func (fs *FS) SetContents(p string, data []byte) error {
+	p = cleanPath(p)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := fs.lookup(p)
+	if n == nil {
+		return &os.PathError{Op: "set", Path: p, Err: os.ErrNotExist}
+	}
+	if n.info.IsPrefix() {
+		return fmt.Errorf("%v: is a prefix", p)
+	}
+	n.data = append([]byte{}, data...)
+	n.info.Size = int64(len(data))
+	return nil
+}
+
+// SetError arranges for the specified operation on the specified path
+// to fail with err. A nil error removes any previously injected error.
+// The error is returned wrapped in an *os.PathError and hence errors such
+// as os.ErrPermission and os.ErrNotExist will be recognised by
+// IsPermissionError and IsNotExist.
+func (fs *FS) SetError(op Op, p string, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p = cleanPath(p)
+	if err == nil {
+		delete(fs.injected[op], p)
+		return
+	}
+	fs.injected[op][p] = err
+}
+
+// injectedError returns any injected error for op and p; the caller must
+// hold fs.mu.
+func (fs *FS) injectedError(op Op, p string) error {
+	if err := fs.injected[op][p]; err != nil {
+		return &os.PathError{Op: op.String(), Path: p, Err: err}
+	}
+	return nil
+}
+
+// Stat implements filewalk.Filesystem.
+func (fs *FS) Stat(ctx context.Context, p string) (filewalk.Info, error) {
+	p = cleanPath(p)
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if err := fs.injectedError(Stat, p); err != nil {
+		return filewalk.Info{}, err
+	}
+	n := fs.lookup(p)
+	if n == nil {
+		return filewalk.Info{}, &os.PathError{Op: Stat.String(), Path: p, Err: os.ErrNotExist}
+	}
+	return n.info, nil
+}
+
+// List implements filewalk.Filesystem.
+func (fs *FS) List(ctx context.Context, p string, ch chan<- filewalk.Contents) {
+	p = cleanPath(p)
+	fs.mu.RLock()
+	err := fs.injectedError(List, p)
+	n := fs.lookup(p)
+	var entries []filewalk.Info
+	if err == nil && n != nil {
+		entries = make([]filewalk.Info, 0, len(n.children))
+		for _, c := range n.children {
+			entries = append(entries, c.info)
+		}
+	}
+	fs.mu.RUnlock()
+
+	if err == nil {
+		switch {
+		case n == nil:
+			err = &os.PathError{Op: List.String(), Path: p, Err: os.ErrNotExist}
+		case !n.info.IsPrefix():
+			err = &os.PathError{Op: List.String(), Path: p, Err: fmt.Errorf("not a prefix")}
+		}
+	}
+	if err != nil {
+		ch <- filewalk.Contents{Path: p, Err: err}
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	batch := fs.opts.batchSize
+	if batch <= 0 {
+		batch = len(entries)
+	}
+	for len(entries) > 0 {
+		select {
+		case <-ctx.Done():
+			ch <- filewalk.Contents{Path: p, Err: ctx.Err()}
+			return
+		default:
+		}
+		size := batch
+		if size > len(entries) {
+			size = len(entries)
+		}
+		contents := filewalk.Contents{Path: p}
+		for _, info := range entries[:size] {
+			if info.IsPrefix() {
+				contents.Children = append(contents.Children, info)
+				continue
+			}
+			contents.Files = append(contents.Files, info)
+		}
+		entries = entries[size:]
+		ch <- contents
+	}
+}
+
+// Open implements filewalk.Reader.
+func (fs *FS) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	p = cleanPath(p)
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if err := fs.injectedError(Open, p); err != nil {
+		return nil, err
+	}
+	n := fs.lookup(p)
+	switch {
+	case n == nil:
+		return nil, &os.PathError{Op: Open.String(), Path: p, Err: os.ErrNotExist}
+	case n.info.IsPrefix():
+		return nil, &os.PathError{Op: Open.String(), Path: p, Err: fmt.Errorf("is a prefix")}
+	}
+	data := n.data
+	if data == nil {
+		data = make([]byte, n.info.Size)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Join implements filewalk.Filesystem.
+func (fs *FS) Join(components ...string) string {
+	return path.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (fs *FS) IsPermissionError(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (fs *FS) IsNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,312 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package memfs_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+const testTree = `
+# comment
+a0/ uid=500
+  f0 3
+  f1 size=4 gid=20
+  a0.0/
+    f2 5 mode=0644
+    f4 size=4096 ino=7 links=2 blocks=1
+b0/ list-err=permission
+c0/ stat-err=notexist
+  f3 1
+lf0 link
+`
+
+func list(fs filewalk.Filesystem, path string) (batches []filewalk.Contents) {
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		fs.List(context.Background(), path, ch)
+		close(ch)
+	}()
+	for c := range ch {
+		batches = append(batches, c)
+	}
+	return
+}
+
+func names(infos []filewalk.Info) []string {
+	n := []string{}
+	for _, i := range infos {
+		n = append(n, i.Name)
+	}
+	return n
+}
+
+func TestParse(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(testTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := list(fs, "/")
+	if got, want := len(batches), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := names(batches[0].Children), []string{"a0", "b0", "c0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := names(batches[0].Files), []string{"lf0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := batches[0].Files[0].IsLink(), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	info, err := fs.Stat(ctx, "/a0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.UserID, "500"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := info.IsPrefix(), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	info, err = fs.Stat(ctx, fs.Join("/a0", "a0.0", "f2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Size, int64(5); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := info.Perms(), filewalk.FileMode(0644); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	info, err = fs.Stat(ctx, fs.Join("/a0", "a0.0", "f4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *info.Metadata, (filewalk.Metadata{Inode: 7, Links: 2, Blocks: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	_, err = fs.Stat(ctx, "/c0")
+	if err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	_, err = fs.Stat(ctx, "/nowhere")
+	if err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+
+	batches = list(fs, "/b0")
+	if got, want := len(batches), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := batches[0].Err; err == nil || !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+
+	for _, tc := range []string{
+		"a//",
+		"a/b",
+		"a size=x",
+		"a ino=x",
+		"a unknown=x",
+		"a stat-err=other",
+		"a\n  b",
+	} {
+		if _, err := memfs.Parse(tc); err == nil {
+			t.Errorf("%q: expected an error", tc)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.NewFromMap(map[string]filewalk.Info{
+		"/a/b/c": {Size: 3},
+		"/a/b":   {UserID: "600", Mode: filewalk.ModePrefix},
+		"/d":     {Size: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(ctx, "/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.UserID, "600"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := fs.AddPrefix("/d", filewalk.Info{}); err == nil {
+		t.Errorf("expected an error")
+	}
+	if err := fs.AddFile("/a", filewalk.Info{}); err == nil {
+		t.Errorf("expected an error")
+	}
+	if err := fs.AddFile("/d/e", filewalk.Info{}); err == nil {
+		t.Errorf("expected an error")
+	}
+	fs.SetError(memfs.Stat, "/d", os.ErrPermission)
+	if _, err := fs.Stat(ctx, "/d"); err == nil || !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	fs.SetError(memfs.Stat, "/d", nil)
+	if _, err := fs.Stat(ctx, "/d"); err != nil {
+		t.Error(err)
+	}
+	if err := fs.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(ctx, "/a/b/c"); !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if err := fs.Remove("/a"); !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	fs := memfs.New(memfs.ListBatchSize(3))
+	for i := 0; i < 5; i++ {
+		fs.AddPrefix(fmt.Sprintf("/d%v", i), filewalk.Info{})
+		fs.AddFile(fmt.Sprintf("/f%v", i), filewalk.Info{})
+	}
+	batches := list(fs, "/")
+	if got, want := len(batches), 4; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sizes := []int{}
+	for _, b := range batches {
+		sizes = append(sizes, len(b.Children)+len(b.Files))
+	}
+	if got, want := sizes, []int{3, 3, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+type logger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *logger) log(format string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) prefixFunc(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+	if err != nil {
+		l.log("%v: stat error", prefix)
+		return true, nil, nil
+	}
+	l.log("%v*", prefix)
+	return false, nil, nil
+}
+
+func (l *logger) contentsFunc(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+	children := []filewalk.Info{}
+	for c := range ch {
+		if c.Err != nil {
+			l.log("%v: list error", prefix)
+			continue
+		}
+		for _, f := range c.Files {
+			l.log("%v/%v: %v", strings.TrimSuffix(prefix, "/"), f.Name, f.Size)
+		}
+		children = append(children, c.Children...)
+	}
+	return children, nil
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(testTree, memfs.ListBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := &logger{}
+	wk := filewalk.New(fs, filewalk.Concurrency(2))
+	if err := wk.Walk(ctx, lg.prefixFunc, lg.contentsFunc, "/"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lg.lines)
+	expected := `/*
+/a0*
+/a0/a0.0*
+/a0/a0.0/f2: 5
+/a0/a0.0/f4: 4096
+/a0/f0: 3
+/a0/f1: 4
+/b0*
+/b0: list error
+/c0: stat error
+/lf0: 0`
+	if got, want := strings.Join(lg.lines, "\n"), expected; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(`
+a/
+  f0 data=hello
+  f1 3
+  f2 open-err=permission
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(p string) (string, error) {
+		rc, err := fs.Open(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		buf, err := ioutil.ReadAll(rc)
+		return string(buf), err
+	}
+	if got, err := read("/a/f0"); err != nil || got != "hello" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if info, _ := fs.Stat(ctx, "/a/f0"); info.Size != 5 {
+		t.Errorf("got %v, want 5", info.Size)
+	}
+	if got, err := read("/a/f1"); err != nil || got != "\x00\x00\x00" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if err := fs.SetContents("/a/f1", []byte("updated")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := read("/a/f1"); err != nil || got != "updated" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := read("/a/f2"); !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := read("/a/f3"); !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := read("/a"); err == nil {
+		t.Errorf("expected an error")
+	}
+	if err := fs.SetContents("/a", nil); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package memfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Parse creates a new instance of FS from a textual description of a tree.
+// Each non-blank line, other than comments which start with #, names a
+// single entry. Names that end in / are prefixes and the nesting of
+// entries is determined by their indentation relative to the preceding
+// prefixes. A name may be followed by a size and/or key=value attributes.
+// The supported attributes are:
+//
+//	size=<int>                       size in bytes
+//	data=<string>                    file contents, the size is set to match
+//	uid=<string>, gid=<string>       user and group ids
+//	dev=<int>                        device id
+//	ino=<int>, links=<int>           inode number and hard link count
+//	blocks=<int>                     number of 512 byte blocks allocated
+//	mode=<octal>                     permissions, the default is 0700
+//	time=<RFC3339>                   modification time
+//	link                             the entry is a link
+//	stat-err=permission|notexist     inject an error for Stat
+//	list-err=permission|notexist     inject an error for List
+//	open-err=permission|notexist     inject an error for Open
+//
+// For example:
+//
+//	a0/ uid=500
+//	  f0 3
+//	  f1 size=10 mode=0600
+//	b0/ list-err=permission
+//	lf0 link
+func Parse(description string, opts ...Option) (*FS, error) {
+	fs := New(opts...)
+	type level struct {
+		indent int
+		path   string
+	}
+	stack := []level{}
+	fileIndent := -1
+	sc := bufio.NewScanner(strings.NewReader(description))
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line := sc.Text()
+		trimmed := strings.TrimLeft(line, " \t")
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		if fileIndent >= 0 && indent > fileIndent {
+			return nil, fmt.Errorf("line %v: only prefixes may contain other entries", lineno)
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := "/"
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].path
+		}
+		fields := strings.Fields(trimmed)
+		name := fields[0]
+		prefix := strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		if len(name) == 0 || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("line %v: invalid name: %q", lineno, fields[0])
+		}
+		p := path.Join(parent, name)
+		info, data, errs, err := parseAttributes(fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineno, err)
+		}
+		if prefix {
+			err = fs.AddPrefix(p, info)
+			stack = append(stack, level{indent: indent, path: p})
+			fileIndent = -1
+		} else {
+			err = fs.AddFile(p, info)
+			if err == nil && data != nil {
+				err = fs.SetContents(p, data)
+			}
+			fileIndent = indent
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineno, err)
+		}
+		for op, err := range errs {
+			fs.SetError(op, p, err)
+		}
+	}
+	return fs, sc.Err()
+}
+
+func parseError(v string) (error, error) {
+	switch v {
+	case "permission":
+		return os.ErrPermission, nil
+	case "notexist":
+		return os.ErrNotExist, nil
+	}
+	return nil, fmt.Errorf("unrecognised error: %q", v)
+}
+
+func metadata(md *filewalk.Metadata) *filewalk.Metadata {
+	if md == nil {
+		return &filewalk.Metadata{Links: 1}
+	}
+	return md
+}
+
+func parseAttributes(fields []string) (filewalk.Info, []byte, map[Op]error, error) {
+	var info filewalk.Info
+	var data []byte
+	errs := map[Op]error{}
+	info.Mode = 0700
+	for _, field := range fields {
+		if size, err := strconv.ParseInt(field, 10, 64); err == nil {
+			info.Size = size
+			continue
+		}
+		if field == "link" {
+			info.Mode |= filewalk.ModeLink
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return info, nil, nil, fmt.Errorf("invalid attribute: %q", field)
+		}
+		var err error
+		switch k, v := kv[0], kv[1]; k {
+		case "size":
+			info.Size, err = strconv.ParseInt(v, 10, 64)
+		case "data":
+			data = []byte(v)
+		case "uid":
+			info.UserID = v
+		case "gid":
+			info.GroupID = v
+		case "dev":
+			info.Device, err = strconv.ParseUint(v, 10, 64)
+		case "ino":
+			info.Metadata = metadata(info.Metadata)
+			info.Metadata.Inode, err = strconv.ParseUint(v, 10, 64)
+		case "links":
+			info.Metadata = metadata(info.Metadata)
+			info.Metadata.Links, err = strconv.ParseUint(v, 10, 64)
+		case "blocks":
+			info.Metadata = metadata(info.Metadata)
+			info.Metadata.Blocks, err = strconv.ParseInt(v, 10, 64)
+		case "mode":
+			var mode uint64
+			mode, err = strconv.ParseUint(v, 8, 32)
+			info.Mode = (info.Mode &^ filewalk.ModePerm) | (filewalk.FileMode(mode) & filewalk.ModePerm)
+		case "time":
+			info.ModTime, err = time.Parse(time.RFC3339, v)
+		case "stat-err":
+			errs[Stat], err = parseError(v)
+		case "list-err":
+			errs[List], err = parseError(v)
+		case "open-err":
+			errs[Open], err = parseError(v)
+		default:
+			err = fmt.Errorf("unrecognised attribute: %q", k)
+		}
+		if err != nil {
+			return info, nil, nil, err
+		}
+	}
+	return info, data, errs, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"time"
+)
+
+// Metadata represents the extended POSIX metadata available for files and
+// prefixes on local filesystems.
+type Metadata struct {
+	Inode      uint64
+	Links      uint64            // number of hard links
+	Blocks     int64             // number of 512 byte blocks allocated, as per st_blocks
+	AccessTime time.Time         // time of last access
+	ChangeTime time.Time         // time of last status change
+	XAttrs     map[string][]byte // extended attributes, if requested, see ReadXAttrs
+}
+
+// LocalOption represents an option accepted by LocalFilesystem.
+type LocalOption func(o *local)
+
+// ReadXAttrs requests that the extended attributes of files and prefixes
+// be read and made available via Info.Metadata.XAttrs. Doing so requires
+// additional system calls for every entry and hence is disabled by default.
+// Extended attributes are only supported on Linux.
+func ReadXAttrs(v bool) LocalOption {
+	return func(o *local) {
+		o.xattrs = v
+	}
+}
+
+// xattrs returns the extended attributes for the supplied path, returning
+// nil if there are none or they cannot be read.
+func xattrs(path string) map[string][]byte {
+	names, err := listXAttrs(path)
+	if err != nil || len(names) == 0 {
+		return nil
+	}
+	attrs := make(map[string][]byte, len(names))
+	for _, name := range names {
+		if v, err := getXAttr(path, name); err == nil {
+			attrs[name] = v
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"syscall"
+	"time"
+)
+
+func getMetadata(sys interface{}) *Metadata {
+	si, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &Metadata{
+		Inode:      uint64(si.Ino),
+		Links:      uint64(si.Nlink),
+		Blocks:     int64(si.Blocks),
+		AccessTime: time.Unix(si.Atimespec.Unix()),
+		ChangeTime: time.Unix(si.Ctimespec.Unix()),
+	}
+}
+
+func listXAttrs(path string) ([]string, error) {
+	return nil, nil
+}
+
+func getXAttr(path, name string) ([]byte, error) {
+	return nil, nil
+}
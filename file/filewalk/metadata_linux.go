@@ -0,0 +1,57 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"bytes"
+	"syscall"
+	"time"
+)
+
+func getMetadata(sys interface{}) *Metadata {
+	si, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &Metadata{
+		Inode:      uint64(si.Ino),
+		Links:      uint64(si.Nlink),
+		Blocks:     int64(si.Blocks),
+		AccessTime: time.Unix(si.Atim.Unix()),
+		ChangeTime: time.Unix(si.Ctim.Unix()),
+	}
+}
+
+func listXAttrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+func getXAttr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return []byte{}, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
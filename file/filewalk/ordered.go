@@ -0,0 +1,204 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"context"
+	"sync"
+)
+
+// Traversal represents the order in which a walk visits prefixes.
+type Traversal int
+
+const (
+	// Concurrent visits prefixes concurrently and in no particular order.
+	Concurrent Traversal = iota
+	// DepthFirst visits prefixes one at a time in lexical, depth-first,
+	// pre-order, ie. a prefix is visited before its children and all
+	// of the prefixes below a child are visited before its next sibling.
+	DepthFirst
+	// BreadthFirst visits prefixes one at a time, level by level, in
+	// lexical order within each level.
+	BreadthFirst
+)
+
+// Order can be used to request that prefixes be visited in a specific
+// order, that is, that PrefixFunc and ContentsFunc be called for one
+// prefix at a time and in that order. Prefixes are still stat'ed and
+// listed concurrently, ahead of being visited, with the results being
+// buffered until they are required. The listing obtained for a prefix
+// is discarded, and any listing still in progress is canceled, if
+// PrefixFunc stops the walk at that prefix or returns children for it.
+// The default is Concurrent. Children are ordered by name regardless
+// of whether they were obtained from a listing or returned by PrefixFunc,
+// and roots are visited in the order supplied to Walk.
+func Order(t Traversal) Option {
+	return func(o *options) {
+		o.order = t
+	}
+}
+
+// prefetched represents the results of stat'ing and listing a prefix
+// before it is visited.
+type prefetched struct {
+	done     chan struct{}
+	cancel   func()
+	info     Info
+	err      error
+	listed   bool
+	contents []Contents
+}
+
+type prefetcher struct {
+	sync.Mutex
+	lookahead int
+	limitCh   chan struct{}
+	entries   map[string]*prefetched
+}
+
+func newPrefetcher(concurrency int) *prefetcher {
+	return &prefetcher{
+		lookahead: concurrency,
+		limitCh:   make(chan struct{}, concurrency),
+		entries:   map[string]*prefetched{},
+	}
+}
+
+// prefetch starts stat'ing and listing path, unless it has already been
+// started, and returns the entry that will contain the results.
+func (w *Walker) prefetch(ctx context.Context, path string) *prefetched {
+	p := w.prefetcher
+	p.Lock()
+	if e := p.entries[path]; e != nil {
+		p.Unlock()
+		return e
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	e := &prefetched{done: make(chan struct{}), cancel: cancel}
+	p.entries[path] = e
+	p.Unlock()
+	go func() {
+		defer close(e.done)
+		select {
+		case p.limitCh <- struct{}{}:
+		case <-ctx.Done():
+			e.err = ctx.Err()
+			return
+		}
+		defer func() { <-p.limitCh }()
+		e.info, e.err = w.fs.Stat(ctx, path)
+		if e.err != nil || !e.info.IsPrefix() {
+			// Leave it to the visit to decide whether to list path.
+			return
+		}
+		ch := make(chan Contents, w.opts.concurrency)
+		go func() {
+			w.listContents(ctx, path, ch)
+			close(ch)
+		}()
+		for c := range ch {
+			e.contents = append(e.contents, c)
+		}
+		e.listed = ctx.Err() == nil
+	}()
+	return e
+}
+
+// take removes and returns the entry, if any, for path.
+func (p *prefetcher) take(path string) *prefetched {
+	p.Lock()
+	defer p.Unlock()
+	e := p.entries[path]
+	delete(p.entries, path)
+	return e
+}
+
+// discard discards any prefetched results for path and cancels any
+// listing that is still in progress.
+func (p *prefetcher) discard(path string) {
+	if e := p.take(path); e != nil {
+		e.cancel()
+	}
+}
+
+// stat is like Filesystem.Stat but will use prefetched results if an
+// ordered traversal is being performed.
+func (w *Walker) stat(ctx context.Context, path string) (Info, error) {
+	if w.prefetcher == nil {
+		return w.fs.Stat(ctx, path)
+	}
+	e := w.prefetch(ctx, path)
+	<-e.done
+	return e.info, e.err
+}
+
+// listPrefetched sends the prefetched contents of path, if any, to ch and
+// returns true if it did so.
+func (w *Walker) listPrefetched(path string, ch chan<- Contents) bool {
+	if w.prefetcher == nil {
+		return false
+	}
+	e := w.prefetcher.take(path)
+	if e == nil {
+		return false
+	}
+	<-e.done
+	e.cancel()
+	if !e.listed {
+		return false
+	}
+	for _, c := range e.contents {
+		ch <- c
+	}
+	return true
+}
+
+// walkOrdered visits each of the prefixes below roots, one at a time, in
+// the order requested via the Order option.
+func (w *Walker) walkOrdered(ctx context.Context, roots []startingPoint) {
+	depthFirst := w.opts.order == DepthFirst
+	// work is used as a stack, whose top is the last element, for
+	// depth-first traversals and as a queue otherwise.
+	work := make([]startingPoint, 0, len(roots))
+	for i := range roots {
+		if depthFirst {
+			work = append(work, roots[len(roots)-1-i])
+			continue
+		}
+		work = append(work, roots[i])
+	}
+	next := func(i int) startingPoint {
+		if depthFirst {
+			return work[len(work)-1-i]
+		}
+		return work[i]
+	}
+	for len(work) > 0 && ctx.Err() == nil {
+		// Prefetch the prefixes that are next in line to be visited.
+		for i := 0; i < len(work) && i < w.prefetcher.lookahead; i++ {
+			w.prefetch(ctx, next(i).path)
+		}
+		item := next(0)
+		if depthFirst {
+			work = work[:len(work)-1]
+		} else {
+			work = work[1:]
+		}
+		children, state := w.step(ctx, "0", item.path, item.state)
+		// Discard the listing if it was not used, ie. if PrefixFunc
+		// stopped the walk at item.path or returned children for it.
+		w.prefetcher.discard(item.path)
+		sortInfos(children)
+		if depthFirst {
+			for i := len(children) - 1; i >= 0; i-- {
+				work = append(work, startingPoint{path: w.fs.Join(item.path, children[i].Name), state: state})
+			}
+			continue
+		}
+		for _, child := range children {
+			work = append(work, startingPoint{path: w.fs.Join(item.path, child.Name), state: state})
+		}
+	}
+}
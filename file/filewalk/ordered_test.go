@@ -0,0 +1,204 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+type sequence struct {
+	events []string
+	skip   string
+}
+
+func (s *sequence) prefixFunc(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+	s.events = append(s.events, "+"+prefix)
+	return prefix == s.skip, nil, err
+}
+
+func (s *sequence) contentsFunc(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+	children := []filewalk.Info{}
+	for c := range ch {
+		for _, f := range c.Files {
+			s.events = append(s.events, prefix+":"+f.Name)
+		}
+		children = append(children, c.Children...)
+	}
+	return children, nil
+}
+
+func TestOrdered(t *testing.T) {
+	fs, err := memfs.Parse(checkpointTree, memfs.ListBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		order filewalk.Traversal
+		skip  string
+		want  []string
+	}{
+		{filewalk.DepthFirst, "", []string{
+			"+/",
+			"+/a0", "/a0:f0",
+			"+/a0/a0.0", "/a0/a0.0:f1",
+			"+/a0/a0.0/a1.0", "/a0/a0.0/a1.0:f2",
+			"+/a0/a0.1", "/a0/a0.1:f3",
+			"+/b0",
+			"+/b0/b0.0", "/b0/b0.0:f4",
+			"+/b0/b0.1", "/b0/b0.1:f5",
+			"+/c0", "/c0:f6",
+		}},
+		{filewalk.BreadthFirst, "", []string{
+			"+/",
+			"+/a0", "/a0:f0",
+			"+/b0",
+			"+/c0", "/c0:f6",
+			"+/a0/a0.0", "/a0/a0.0:f1",
+			"+/a0/a0.1", "/a0/a0.1:f3",
+			"+/b0/b0.0", "/b0/b0.0:f4",
+			"+/b0/b0.1", "/b0/b0.1:f5",
+			"+/a0/a0.0/a1.0", "/a0/a0.0/a1.0:f2",
+		}},
+		{filewalk.DepthFirst, "/a0/a0.0", []string{
+			"+/",
+			"+/a0", "/a0:f0",
+			"+/a0/a0.0",
+			"+/a0/a0.1", "/a0/a0.1:f3",
+			"+/b0",
+			"+/b0/b0.0", "/b0/b0.0:f4",
+			"+/b0/b0.1", "/b0/b0.1:f5",
+			"+/c0", "/c0:f6",
+		}},
+	} {
+		// Repeat to give any non-determinism a chance to show itself.
+		for i := 0; i < 10; i++ {
+			seq := &sequence{skip: tc.skip}
+			wk := filewalk.New(fs, filewalk.Order(tc.order), filewalk.Concurrency(4))
+			if err := wk.Walk(context.Background(), seq.prefixFunc, seq.contentsFunc, "/"); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := seq.events, tc.want; !reflect.DeepEqual(got, want) {
+				t.Fatalf("%v: %v: got %v, want %v", tc.order, i, got, want)
+			}
+		}
+	}
+
+	// Multiple roots are visited in the order supplied.
+	seq := &sequence{}
+	wk := filewalk.New(fs, filewalk.Order(filewalk.DepthFirst))
+	if err := wk.Walk(context.Background(), seq.prefixFunc, seq.contentsFunc, "/c0", "/b0/b0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := seq.events, []string{"+/c0", "/c0:f6", "+/b0/b0.1", "/b0/b0.1:f5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// listed records the prefixes that are listed. If barrier is non-empty,
+// listings of the prefixes it contains wait until all of them have been
+// started, and hence will only complete if they are performed
+// concurrently.
+type listed struct {
+	*memfs.FS
+	mu       sync.Mutex
+	paths    []string
+	barrier  map[string]bool
+	started  int
+	ready    chan struct{}
+	timedOut bool
+}
+
+func (l *listed) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	l.mu.Lock()
+	l.paths = append(l.paths, path)
+	wait := l.barrier[path]
+	if wait {
+		if l.started++; l.started == len(l.barrier) {
+			close(l.ready)
+		}
+	}
+	l.mu.Unlock()
+	if wait {
+		select {
+		case <-l.ready:
+		case <-time.After(5 * time.Second):
+			l.mu.Lock()
+			l.timedOut = true
+			l.mu.Unlock()
+		}
+	}
+	l.FS.List(ctx, path, ch)
+}
+
+func TestOrderedPrefetch(t *testing.T) {
+	ctx := context.Background()
+	mfs, err := memfs.Parse(checkpointTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := mfs.Stat(ctx, "/b0/b0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range []filewalk.Traversal{filewalk.DepthFirst, filewalk.BreadthFirst} {
+		// The children of / are listed concurrently.
+		fs := &listed{
+			FS:      mfs,
+			barrier: map[string]bool{"/a0": true, "/b0": true, "/c0": true},
+			ready:   make(chan struct{}),
+		}
+		seq := &sequence{}
+		wk := filewalk.New(fs, filewalk.Order(order), filewalk.Concurrency(4))
+		if err := wk.Walk(ctx, seq.prefixFunc, seq.contentsFunc, "/"); err != nil {
+			t.Fatal(err)
+		}
+		if fs.timedOut {
+			t.Errorf("%v: prefixes were not listed concurrently", order)
+		}
+		if got, want := len(seq.events), 16; got != want {
+			t.Errorf("%v: got %v, want %v", order, got, want)
+		}
+
+		fs = &listed{FS: mfs}
+		prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+			seq.events = append(seq.events, "+"+prefix)
+			switch prefix {
+			case "/a0":
+				return true, nil, nil
+			case "/b0":
+				return false, []filewalk.Info{child}, nil
+			}
+			return false, nil, err
+		}
+		seq = &sequence{}
+		wk = filewalk.New(fs, filewalk.Order(order), filewalk.Concurrency(4))
+		if err := wk.Walk(ctx, prefixFn, seq.contentsFunc, "/"); err != nil {
+			t.Fatal(err)
+		}
+		// The prefetched listings of the pruned prefix and of the prefix
+		// whose children were supplied are discarded and nothing below
+		// them, other than the supplied children, is listed.
+		want := []string{"+/", "+/a0", "+/b0", "+/c0", "/c0:f6", "+/b0/b0.1", "/b0/b0.1:f5"}
+		if order == filewalk.DepthFirst {
+			want = []string{"+/", "+/a0", "+/b0", "+/b0/b0.1", "/b0/b0.1:f5", "+/c0", "/c0:f6"}
+		}
+		if got := seq.events; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", order, got, want)
+		}
+		for _, p := range fs.paths {
+			if strings.HasPrefix(p, "/a0/") || p == "/b0/b0.0" {
+				t.Errorf("%v: %v should not have been listed", order, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/file/diskusage"
+)
+
+// ErrorCounts represents the number of errors, by category, returned by
+// a Filesystem's Stat and List methods.
+type ErrorCounts struct {
+	Permission int64 // errors for which IsPermissionError returns true.
+	NotExist   int64 // errors for which IsNotExist returns true.
+	Other      int64 // all other errors.
+}
+
+// Total returns the total number of errors.
+func (ec ErrorCounts) Total() int64 {
+	return ec.Permission + ec.NotExist + ec.Other
+}
+
+// ProgressSnapshot represents the progress of a walk at a point in time.
+type ProgressSnapshot struct {
+	When    time.Time     // time at which the snapshot was taken.
+	Elapsed time.Duration // time since the walk started.
+
+	Stated int64 // number of prefixes stat'ed.
+	Listed int64 // number of prefixes whose listing has completed.
+	Files  int64 // number of files seen.
+	Bytes  int64 // total size of the files seen.
+	Errors ErrorCounts
+
+	Queued        int64 // number of prefixes waiting to be visited.
+	Frontier      int64 // number of prefixes waiting to be visited or being visited.
+	ActiveListers int64 // number of List calls in progress.
+
+	// Throughput, per second, measured over the interval since the
+	// previous snapshot.
+	PrefixesPerSecond float64
+	FilesPerSecond    float64
+	BytesPerSecond    float64
+
+	Done bool // true for the final snapshot of a walk.
+}
+
+// Progress requests that a snapshot of the walk's progress be passed to
+// fn at the specified interval, which defaults to one second, and once
+// more, with Done set, when the walk completes. Note that counting files
+// and bytes requires that every Contents message be inspected before
+// it is passed to ContentsFunc. fn is called from a single goroutine.
+func Progress(interval time.Duration, fn func(ProgressSnapshot)) Option {
+	return func(o *options) {
+		o.progressFn = fn
+		o.progressInterval = interval
+	}
+}
+
+// progress maintains the counters used to create ProgressSnapshots.
+type progress struct {
+	start                        time.Time
+	stated, listed, files, bytes int64
+	permission, notExist, other  int64
+	queued, visiting, listers    int64
+}
+
+func (p *progress) error(fs Filesystem, err error) {
+	switch {
+	case err == nil:
+	case fs.IsPermissionError(err):
+		atomic.AddInt64(&p.permission, 1)
+	case fs.IsNotExist(err):
+		atomic.AddInt64(&p.notExist, 1)
+	default:
+		atomic.AddInt64(&p.other, 1)
+	}
+}
+
+func (p *progress) contents(fs Filesystem, c Contents) {
+	p.error(fs, c.Err)
+	var size int64
+	for _, f := range c.Files {
+		size += f.Size
+	}
+	atomic.AddInt64(&p.files, int64(len(c.Files)))
+	atomic.AddInt64(&p.bytes, size)
+}
+
+func (p *progress) snapshot(now time.Time, prev ProgressSnapshot) ProgressSnapshot {
+	s := ProgressSnapshot{
+		When:    now,
+		Elapsed: now.Sub(p.start),
+		Stated:  atomic.LoadInt64(&p.stated),
+		Listed:  atomic.LoadInt64(&p.listed),
+		Files:   atomic.LoadInt64(&p.files),
+		Bytes:   atomic.LoadInt64(&p.bytes),
+		Errors: ErrorCounts{
+			Permission: atomic.LoadInt64(&p.permission),
+			NotExist:   atomic.LoadInt64(&p.notExist),
+			Other:      atomic.LoadInt64(&p.other),
+		},
+		Queued:        atomic.LoadInt64(&p.queued),
+		ActiveListers: atomic.LoadInt64(&p.listers),
+	}
+	s.Frontier = s.Queued + atomic.LoadInt64(&p.visiting)
+	since := prev.When
+	if since.IsZero() {
+		since = p.start
+	}
+	if secs := now.Sub(since).Seconds(); secs > 0 {
+		s.PrefixesPerSecond = float64(s.Listed-prev.Listed) / secs
+		s.FilesPerSecond = float64(s.Files-prev.Files) / secs
+		s.BytesPerSecond = float64(s.Bytes-prev.Bytes) / secs
+	}
+	return s
+}
+
+// countingList calls list and records the files, bytes and
+// errors that it returns.
+func (w *Walker) countingList(list func(ch chan<- Contents), ch chan<- Contents) {
+	atomic.AddInt64(&w.progress.listers, 1)
+	defer atomic.AddInt64(&w.progress.listers, -1)
+	if w.opts.progressFn == nil {
+		list(ch)
+		return
+	}
+	lch := make(chan Contents, cap(ch))
+	go func() {
+		list(lch)
+		close(lch)
+	}()
+	for c := range lch {
+		w.progress.contents(w.fs, c)
+		ch <- c
+	}
+}
+
+// reportProgress periodically calls the Progress function until doneCh
+// is closed, at which point it reports the final snapshot.
+func (w *Walker) reportProgress(doneCh <-chan struct{}) {
+	if w.opts.progressFn == nil {
+		return
+	}
+	interval := w.opts.progressInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var prev ProgressSnapshot
+	for {
+		select {
+		case now := <-ticker.C:
+			prev = w.progress.snapshot(now, prev)
+			w.opts.progressFn(prev)
+		case <-doneCh:
+			final := w.progress.snapshot(time.Now(), prev)
+			final.Done = true
+			w.opts.progressFn(final)
+			return
+		}
+	}
+}
+
+func formatBytes(n float64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	v, unit := diskusage.Base2Bytes(n).Standardize()
+	return fmt.Sprintf("%.1f %s", v, unit)
+}
+
+// String returns a single line summary of the snapshot.
+func (s ProgressSnapshot) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%v: prefixes %v/%v (%.0f/s), files %v (%.0f/s), %v (%v/s), queued %v, listing %v",
+		s.Elapsed.Truncate(time.Second),
+		s.Listed, s.Stated, s.PrefixesPerSecond,
+		s.Files, s.FilesPerSecond,
+		formatBytes(float64(s.Bytes)), formatBytes(s.BytesPerSecond),
+		s.Queued, s.ActiveListers)
+	if s.Errors.Total() > 0 {
+		fmt.Fprintf(&out, ", errors %v (permission %v, not found %v)",
+			s.Errors.Total(), s.Errors.Permission, s.Errors.NotExist)
+	}
+	return out.String()
+}
+
+// ProgressLine displays ProgressSnapshots as a single line on a terminal
+// that is overwritten by each subsequent snapshot.
+type ProgressLine struct {
+	mu   sync.Mutex
+	out  io.Writer
+	last int
+}
+
+// NewProgressLine returns a new ProgressLine that writes to out.
+func NewProgressLine(out io.Writer) *ProgressLine {
+	return &ProgressLine{out: out}
+}
+
+// Update displays the supplied snapshot, replacing the one previously
+// displayed. A newline is written after a snapshot with Done set.
+// Update is intended to be used as the function passed to Progress.
+func (pl *ProgressLine) Update(s ProgressSnapshot) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	line := s.String()
+	pad := ""
+	if n := pl.last - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	pl.last = len(line)
+	fmt.Fprintf(pl.out, "\r%s%s", line, pad)
+	if s.Done {
+		fmt.Fprintln(pl.out)
+		pl.last = 0
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+func TestProgress(t *testing.T) {
+	fs, err := memfs.Parse(checkpointTree, memfs.ListBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetError(memfs.List, "/c0", os.ErrPermission)
+	var snapshots []filewalk.ProgressSnapshot
+	rec := &recorder{}
+	wk := filewalk.New(fs, filewalk.Progress(time.Millisecond, func(s filewalk.ProgressSnapshot) {
+		snapshots = append(snapshots, s)
+	}))
+	if err := wk.Walk(context.Background(), rec.prefixFunc, rec.contentsFunc, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) == 0 {
+		t.Fatal("no snapshots")
+	}
+	final := snapshots[len(snapshots)-1]
+	if !final.Done {
+		t.Errorf("final snapshot is not marked as done")
+	}
+	for _, s := range snapshots[:len(snapshots)-1] {
+		if s.Done {
+			t.Errorf("intermediate snapshot is marked as done")
+		}
+	}
+	for i, tc := range []struct {
+		got, want int64
+	}{
+		{final.Stated, 9},
+		{final.Listed, 9},
+		{final.Files, 6},
+		{final.Bytes, 6},
+		{final.Errors.Permission, 1},
+		{final.Errors.Total(), 1},
+		{final.Queued, 0},
+		{final.Frontier, 0},
+		{final.ActiveListers, 0},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%v: got %v, want %v", i, tc.got, tc.want)
+		}
+	}
+	if final.Elapsed <= 0 {
+		t.Errorf("elapsed time was not recorded")
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	s := filewalk.ProgressSnapshot{
+		Elapsed:           90 * time.Second,
+		Stated:            11,
+		Listed:            10,
+		Files:             100,
+		Bytes:             3 * 1024 * 1024,
+		Queued:            4,
+		ActiveListers:     2,
+		PrefixesPerSecond: 2,
+		FilesPerSecond:    20,
+		BytesPerSecond:    512,
+		Errors:            filewalk.ErrorCounts{Permission: 1, Other: 1},
+	}
+	if got, want := s.String(), "1m30s: prefixes 10/11 (2/s), files 100 (20/s), 3.0 MiB (512 B/s), queued 4, listing 2, errors 2 (permission 1, not found 0)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	out := &bytes.Buffer{}
+	pl := filewalk.NewProgressLine(out)
+	pl.Update(s)
+	s.Errors = filewalk.ErrorCounts{}
+	s.Done = true
+	pl.Update(s)
+	lines := strings.Split(out.String(), "\r")
+	if got, want := len(lines), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The second line must overwrite all of the first.
+	if got, want := len(lines[2]), len(lines[1])+1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !strings.HasSuffix(lines[2], "\n") {
+		t.Errorf("missing newline")
+	}
+}
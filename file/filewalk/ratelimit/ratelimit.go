@@ -0,0 +1,308 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package ratelimit provides a filewalk.Filesystem that wraps another
+// filewalk.Filesystem in order to limit the rate at which Stat and List
+// calls are made to it. Rate limiting is implemented using token buckets
+// and paths may be grouped, for example by host or bucket, or by the root
+// of a walk, with each group having its own token bucket.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	rate   float64
+	burst  int
+	keyFn  func(path string) string
+	perKey map[string]limit
+}
+
+type limit struct {
+	rate  float64
+	burst int
+}
+
+// Rate sets the default rate, in requests per second, and burst size for
+// all token buckets. The default is 10 requests per second with a burst
+// of 1. A rate of zero or less disables rate limiting.
+func Rate(perSecond float64, burst int) Option {
+	return func(o *options) {
+		o.rate = perSecond
+		o.burst = burst
+	}
+}
+
+// KeyRate sets the rate and burst size for the token bucket used for the
+// specified key, overriding the default set via Rate.
+func KeyRate(key string, perSecond float64, burst int) Option {
+	return func(o *options) {
+		o.perKey[key] = limit{rate: perSecond, burst: burst}
+	}
+}
+
+// Key sets the function used to determine which token bucket is used for
+// a given path. The default is to use a single token bucket for all paths.
+func Key(fn func(path string) string) Option {
+	return func(o *options) {
+		o.keyFn = fn
+	}
+}
+
+// HostKey returns the host component of paths that are URLs, for example
+// the bucket for s3://bucket/object or the server for
+// https://storage.googleapis.com/bucket/object, and the empty string for
+// all other paths. It is intended for use with the Key option.
+func HostKey(path string) string {
+	if !strings.Contains(path, "://") {
+		return ""
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// RootKey returns a function, for use with the Key option, that returns
+// the longest of the supplied roots that is a prefix of a given path, or
+// the empty string if there is no such root.
+func RootKey(roots ...string) func(path string) string {
+	return func(path string) string {
+		key := ""
+		for _, root := range roots {
+			if strings.HasPrefix(path, root) && len(root) > len(key) {
+				key = root
+			}
+		}
+		return key
+	}
+}
+
+// Stats represents the counters maintained by a rate limited Filesystem.
+type Stats struct {
+	// Calls is the number of tokens requested.
+	Calls int64
+	// Throttled is the number of tokens that had to be waited for.
+	Throttled int64
+	// Delay is the total time spent waiting for tokens.
+	Delay time.Duration
+}
+
+// bucket implements a token bucket.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(l limit) *bucket {
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	return &bucket{rate: l.rate, burst: float64(l.burst), tokens: float64(l.burst)}
+}
+
+// reserve takes a token from the bucket and returns how long the caller
+// must wait before using it.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns an unused token to the bucket.
+func (b *bucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// FS is a rate limited filewalk.Filesystem. It also implements
+// filewalk.Reader and filewalk.LinkFollower by forwarding them, subject to
+// the same rate limits as Stat, to the Filesystem it wraps; see
+// filewalk.Open and filewalk.StatFollow.
+type FS struct {
+	fs      filewalk.Filesystem
+	opts    options
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	calls, throttled, delay int64
+}
+
+// New returns a filewalk.Filesystem that limits the rate at which calls
+// are made to the supplied Filesystem. Each call to Stat and List
+// requires a token, as does each Contents message after the first sent
+// by List since each typically corresponds to an additional request
+// for the next page of results. Note that since a subsequent page can
+// only be requested by the underlying Filesystem once the preceding page
+// has been received, the rate at which pages are requested is limited by
+// delaying the delivery of each page, other than the first, until a
+// token is available. Hence at most one page is requested ahead of the
+// tokens obtained.
+func New(fs filewalk.Filesystem, opts ...Option) *FS {
+	r := &FS{fs: fs, buckets: map[string]*bucket{}}
+	r.opts.rate = 10
+	r.opts.burst = 1
+	r.opts.keyFn = func(string) string { return "" }
+	r.opts.perKey = map[string]limit{}
+	for _, fn := range opts {
+		fn(&r.opts)
+	}
+	return r
+}
+
+func (r *FS) bucketFor(path string) *bucket {
+	key := r.opts.keyFn(path)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b := r.buckets[key]; b != nil {
+		return b
+	}
+	l, ok := r.opts.perKey[key]
+	if !ok {
+		l = limit{rate: r.opts.rate, burst: r.opts.burst}
+	}
+	if l.rate <= 0 {
+		r.buckets[key] = nil
+		return nil
+	}
+	b := newBucket(l)
+	r.buckets[key] = b
+	return b
+}
+
+// wait waits for a token for the specified path to become available.
+func (r *FS) wait(ctx context.Context, path string) error {
+	atomic.AddInt64(&r.calls, 1)
+	b := r.bucketFor(path)
+	if b == nil {
+		return nil
+	}
+	d := b.reserve(time.Now())
+	if d == 0 {
+		return nil
+	}
+	atomic.AddInt64(&r.throttled, 1)
+	atomic.AddInt64(&r.delay, int64(d))
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Stats returns the current values of the counters maintained by r.
+func (r *FS) Stats() Stats {
+	return Stats{
+		Calls:     atomic.LoadInt64(&r.calls),
+		Throttled: atomic.LoadInt64(&r.throttled),
+		Delay:     time.Duration(atomic.LoadInt64(&r.delay)),
+	}
+}
+
+// Stat implements filewalk.Filesystem.
+func (r *FS) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	if err := r.wait(ctx, path); err != nil {
+		return filewalk.Info{}, err
+	}
+	return r.fs.Stat(ctx, path)
+}
+
+// StatFollow implements filewalk.LinkFollower.
+func (r *FS) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	if err := r.wait(ctx, path); err != nil {
+		return filewalk.Info{}, err
+	}
+	return filewalk.StatFollow(ctx, r.fs, path)
+}
+
+// FileID implements filewalk.LinkFollower.
+func (r *FS) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return filewalk.FileID(r.fs, info)
+}
+
+// Open implements filewalk.Reader.
+func (r *FS) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := r.wait(ctx, path); err != nil {
+		return nil, err
+	}
+	return filewalk.Open(ctx, r.fs, path)
+}
+
+// List implements filewalk.Filesystem.
+func (r *FS) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	if err := r.wait(ctx, path); err != nil {
+		ch <- filewalk.Contents{Path: path, Err: err}
+		return
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lch := make(chan filewalk.Contents)
+	go func() {
+		r.fs.List(lctx, path, lch)
+		close(lch)
+	}()
+	first := true
+	for contents := range lch {
+		// Only wait for a token once another page has actually been
+		// received so that the end of the listing does not consume one.
+		if !first {
+			if err := r.wait(ctx, path); err != nil {
+				ch <- filewalk.Contents{Path: path, Err: err}
+				cancel()
+				for range lch {
+				}
+				return
+			}
+		}
+		first = false
+		ch <- contents
+	}
+}
+
+// Join implements filewalk.Filesystem.
+func (r *FS) Join(components ...string) string {
+	return r.fs.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (r *FS) IsPermissionError(err error) bool {
+	return r.fs.IsPermissionError(err)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (r *FS) IsNotExist(err error) bool {
+	return r.fs.IsNotExist(err)
+}
@@ -0,0 +1,236 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+	"cloudeng.io/file/filewalk/ratelimit"
+)
+
+const tree = `
+a/
+  f0
+  f1
+  f2
+b/
+  f3
+`
+
+func newFS(t *testing.T) filewalk.Filesystem {
+	fs, err := memfs.Parse(tree, memfs.ListBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	fs := ratelimit.New(newFS(t), ratelimit.Rate(100, 1))
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := fs.Stat(ctx, "/a/f0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := time.Since(start), 35*time.Millisecond; got < want {
+		t.Errorf("took %v, which is less than %v", got, want)
+	}
+	stats := fs.Stats()
+	if got, want := stats.Calls, int64(5); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats.Throttled, int64(4); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if stats.Delay == 0 {
+		t.Errorf("delay was not recorded")
+	}
+
+	// Each page, other than the first, returned by List requires a token.
+	fs = ratelimit.New(newFS(t), ratelimit.Rate(1000, 10))
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		fs.List(ctx, "/a", ch)
+		close(ch)
+	}()
+	files := 0
+	for c := range ch {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		files += len(c.Files)
+	}
+	if got, want := files, 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := fs.Stats().Calls, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSinglePageList(t *testing.T) {
+	ctx := context.Background()
+	mfs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A List that returns a single page requires a single token.
+	fs := ratelimit.New(mfs, ratelimit.Rate(2, 1))
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		ch := make(chan filewalk.Contents, 10)
+		go func() {
+			fs.List(ctx, "/a", ch)
+			close(ch)
+		}()
+		pages := 0
+		for c := range ch {
+			if c.Err != nil {
+				t.Fatal(c.Err)
+			}
+			pages++
+		}
+		if got, want := pages, 1; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	stats := fs.Stats()
+	if got, want := stats.Calls, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats.Throttled, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, limit := time.Since(start), time.Second; got >= limit {
+		t.Errorf("took %v, which is not less than %v", got, limit)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	fs := ratelimit.New(newFS(t),
+		ratelimit.Rate(10, 1),
+		ratelimit.Key(ratelimit.RootKey("/a", "/b")),
+		ratelimit.KeyRate("/a", 0, 0),
+	)
+	for i := 0; i < 10; i++ {
+		if _, err := fs.Stat(ctx, "/a/f0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := fs.Stats().Throttled, int64(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := fs.Stat(ctx, "/b/f3"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := fs.Stats().Throttled, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		path, key string
+	}{
+		{"s3://bucket/a/b", "bucket"},
+		{"https://storage.googleapis.com/bucket", "storage.googleapis.com"},
+		{"/local/path", ""},
+	} {
+		if got, want := ratelimit.HostKey(tc.path), tc.key; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+	if got, want := ratelimit.RootKey("/a", "/a/b")("/a/b/c"), "/a/b"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	fs := ratelimit.New(newFS(t), ratelimit.Rate(0.01, 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := fs.Stat(ctx, "/a/f0"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := fs.Stat(ctx, "/a/f0"); !errors.Is(err, context.Canceled) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	ch := make(chan filewalk.Contents, 1)
+	fs.List(ctx, "/a", ch)
+	if c := <-ch; !errors.Is(c.Err, context.Canceled) {
+		t.Errorf("missing or wrong error: %v", c.Err)
+	}
+}
+
+// linkFS adds a trivial implementation of filewalk.LinkFollower to memfs.
+type linkFS struct {
+	*memfs.FS
+}
+
+func (l *linkFS) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	return l.Stat(ctx, path)
+}
+
+func (l *linkFS) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return 1, uint64(len(info.Name)), true
+}
+
+func TestForwarding(t *testing.T) {
+	ctx := context.Background()
+	mfs := newFS(t).(*memfs.FS)
+	if err := mfs.SetContents("/a/f0", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	fs := ratelimit.New(&linkFS{mfs}, ratelimit.Rate(100, 1))
+	start := time.Now()
+	rc, err := fs.Open(ctx, "/a/f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if got, want := string(buf), "hello"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	info, err := fs.StatFollow(ctx, "/a/f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, inode, ok := fs.FileID(info); !ok || inode != 2 {
+		t.Errorf("got %v, %v, want 2, true", inode, ok)
+	}
+	// Both Open and StatFollow require a token.
+	if got, want := time.Since(start), 5*time.Millisecond; got < want {
+		t.Errorf("took %v, which is less than %v", got, want)
+	}
+	if got, want := fs.Stats().Calls, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// A Filesystem that implements neither interface.
+	fs = ratelimit.New(struct{ filewalk.Filesystem }{mfs})
+	if _, err := fs.Open(ctx, "/a/f0"); !errors.Is(err, filewalk.ErrNotSupported) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	info, err = fs.StatFollow(ctx, "/a/f1")
+	if err != nil || info.Name != "f1" {
+		t.Errorf("unexpected result: %v, %v", info, err)
+	}
+	if _, _, ok := fs.FileID(info); ok {
+		t.Errorf("unexpected file id")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package record provides support for recording the Stat, List and, for
+// filesystems that follow links, StatFollow calls made on a
+// filewalk.Filesystem, including their responses, errors,
+// the batching of Contents and their timing, and for subsequently
+// replaying them without access to the original filesystem. This allows
+// walks of production systems to be reproduced offline.
+//
+// Recordings are gob encoded streams consisting of a header followed by
+// one entry per call, written in the order in which the calls complete.
+package record
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"sync"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+const version = 1
+
+type op int
+
+const (
+	opStat op = iota
+	opList
+	opStatFollow
+)
+
+type header struct {
+	Version   int
+	Separator string // the separator used by the recorded filesystem.
+}
+
+// recordedError records the text of an error and how it was classified
+// by the recorded filesystem.
+type recordedError struct {
+	Msg        string
+	NotExist   bool
+	Permission bool
+}
+
+type batch struct {
+	Delay    time.Duration // since the start of the call.
+	Path     string
+	Children []filewalk.Info
+	Files    []filewalk.Info
+	Err      *recordedError
+}
+
+type entry struct {
+	Op      op
+	Path    string
+	Latency time.Duration
+	Info    filewalk.Info
+	Err     *recordedError
+	Batches []batch
+}
+
+// Recorder is a filewalk.Filesystem that records all calls to Stat and List
+// on the filesystem it wraps. It also implements filewalk.LinkFollower,
+// recording calls to StatFollow, and filewalk.Reader by forwarding them to
+// the Filesystem it wraps; see filewalk.StatFollow and filewalk.Open.
+// Calls to Open are not recorded. It is safe for concurrent use.
+type Recorder struct {
+	fs filewalk.Filesystem
+
+	mu  sync.Mutex
+	enc *gob.Encoder
+	err error
+}
+
+// NewRecorder returns a Recorder that writes its recording to w.
+func NewRecorder(fs filewalk.Filesystem, w io.Writer) (*Recorder, error) {
+	r := &Recorder{
+		fs:  fs,
+		enc: gob.NewEncoder(w),
+	}
+	if err := r.enc.Encode(header{Version: version, Separator: filewalk.Separator(fs)}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Err returns the first error encountered writing the recording, if any.
+// Once an error is encountered no further entries are written.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) write(e *entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(e)
+	}
+}
+
+func (r *Recorder) recordError(err error) *recordedError {
+	if err == nil {
+		return nil
+	}
+	return &recordedError{
+		Msg:        err.Error(),
+		NotExist:   r.fs.IsNotExist(err),
+		Permission: r.fs.IsPermissionError(err),
+	}
+}
+
+func (r *Recorder) stat(ctx context.Context, o op, path string, fn func(context.Context, string) (filewalk.Info, error)) (filewalk.Info, error) {
+	start := time.Now()
+	info, err := fn(ctx, path)
+	r.write(&entry{
+		Op:      o,
+		Path:    path,
+		Latency: time.Since(start),
+		Info:    info,
+		Err:     r.recordError(err),
+	})
+	return info, err
+}
+
+// Stat implements filewalk.Filesystem.
+func (r *Recorder) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	return r.stat(ctx, opStat, path, r.fs.Stat)
+}
+
+// StatFollow implements filewalk.LinkFollower.
+func (r *Recorder) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	return r.stat(ctx, opStatFollow, path, func(ctx context.Context, path string) (filewalk.Info, error) {
+		return filewalk.StatFollow(ctx, r.fs, path)
+	})
+}
+
+// FileID implements filewalk.LinkFollower.
+func (r *Recorder) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return filewalk.FileID(r.fs, info)
+}
+
+// Open implements filewalk.Reader.
+func (r *Recorder) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return filewalk.Open(ctx, r.fs, path)
+}
+
+// List implements filewalk.Filesystem.
+func (r *Recorder) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	start := time.Now()
+	lch := make(chan filewalk.Contents)
+	go func() {
+		r.fs.List(ctx, path, lch)
+		close(lch)
+	}()
+	e := &entry{
+		Op:   opList,
+		Path: path,
+	}
+	for contents := range lch {
+		e.Batches = append(e.Batches, batch{
+			Delay:    time.Since(start),
+			Path:     contents.Path,
+			Children: contents.Children,
+			Files:    contents.Files,
+			Err:      r.recordError(contents.Err),
+		})
+		ch <- contents
+	}
+	e.Latency = time.Since(start)
+	r.write(e)
+}
+
+// Join implements filewalk.Filesystem. Calls to Join are not recorded,
+// rather the separator used by the recorded filesystem is recorded once.
+func (r *Recorder) Join(components ...string) string {
+	return r.fs.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (r *Recorder) IsPermissionError(err error) bool {
+	return r.fs.IsPermissionError(err)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (r *Recorder) IsNotExist(err error) bool {
+	return r.fs.IsNotExist(err)
+}
@@ -0,0 +1,260 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package record_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+	"cloudeng.io/file/filewalk/record"
+)
+
+const tree = `
+a/
+  f0 size=1
+  f1 size=2
+  b/
+    f2 size=3
+    f3 size=4
+c/
+  f4 size=5
+d/
+  e/
+`
+
+// walk walks fs and returns a sorted log of the prefixes, files and
+// errors encountered and the error returned by the walk.
+func walk(t *testing.T, fs filewalk.Filesystem) ([]string, string) {
+	var mu sync.Mutex
+	var lines []string
+	log := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		if err != nil {
+			log("%v: stat: %v %v", prefix, fs.IsNotExist(err), fs.IsPermissionError(err))
+			return true, nil, nil
+		}
+		log("%v*", prefix)
+		return false, nil, nil
+	}
+	contentsFn := func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+		var children []filewalk.Info
+		for c := range ch {
+			if c.Err != nil {
+				log("%v: list: %v %v", prefix, fs.IsNotExist(c.Err), fs.IsPermissionError(c.Err))
+				continue
+			}
+			for _, f := range c.Files {
+				log("%v: %v", fs.Join(prefix, f.Name), f.Size)
+			}
+			children = append(children, c.Children...)
+		}
+		return children, nil
+	}
+	err := filewalk.New(fs).Walk(context.Background(), prefixFn, contentsFn, "/")
+	errStr := ""
+	if err != nil {
+		errStr = err.Error()
+	}
+	sort.Strings(lines)
+	return lines, errStr
+}
+
+func TestRecordReplay(t *testing.T) {
+	fs, err := memfs.Parse(tree, memfs.ListBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetError(memfs.List, "/c", os.ErrPermission)
+	fs.SetError(memfs.Stat, "/d/e", os.ErrNotExist)
+
+	buf := &bytes.Buffer{}
+	rec, err := record.NewRecorder(fs, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, recordedErr := walk(t, rec)
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	direct, directErr := walk(t, fs)
+	if !reflect.DeepEqual(recorded, direct) || recordedErr != directErr {
+		t.Errorf("got %v, %v, want %v, %v", recorded, recordedErr, direct, directErr)
+	}
+
+	rp, err := record.NewReplayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, replayedErr := walk(t, rp)
+	if !reflect.DeepEqual(replayed, recorded) || replayedErr != recordedErr {
+		t.Errorf("got %v, %v, want %v, %v", replayed, replayedErr, recorded, recordedErr)
+	}
+	if got, want := len(replayed), 11; got != want {
+		t.Errorf("got %v, want %v: %v", got, want, replayed)
+	}
+
+	// Batching is preserved.
+	ch := make(chan filewalk.Contents, 10)
+	rp.List(context.Background(), "/a", ch)
+	close(ch)
+	if got, want := len(ch), 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := rp.Stat(context.Background(), "/x"); !errors.Is(err, record.ErrNotRecorded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Joins are not recorded, only the separator.
+	for _, tc := range []struct {
+		components []string
+		want       string
+	}{
+		{[]string{"/"}, "/"},
+		{[]string{"/", "a"}, "/a"},
+		{[]string{"/a/", "/b/", "", "c"}, "/a/b/c"},
+		{[]string{"s3://bucket", "a"}, "s3://bucket/a"},
+	} {
+		if got, want := rp.Join(tc.components...), tc.want; got != want {
+			t.Errorf("%v: got %v, want %v", tc.components, got, want)
+		}
+	}
+}
+
+// slow delays every call by a fixed amount.
+type slow struct {
+	*memfs.FS
+	delay time.Duration
+}
+
+func (s *slow) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	time.Sleep(s.delay)
+	return s.FS.Stat(ctx, path)
+}
+
+func (s *slow) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	time.Sleep(s.delay)
+	s.FS.List(ctx, path, ch)
+}
+
+func TestLatency(t *testing.T) {
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay := 20 * time.Millisecond
+	buf := &bytes.Buffer{}
+	rec, err := record.NewRecorder(&slow{FS: fs, delay: delay}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := rec.Stat(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan filewalk.Contents, 10)
+	rec.List(ctx, "/a", ch)
+
+	for _, preserve := range []bool{false, true} {
+		rp, err := record.NewReplayer(bytes.NewReader(buf.Bytes()), record.PreserveLatency(preserve))
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if _, err := rp.Stat(ctx, "/a"); err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan filewalk.Contents, 10)
+		rp.List(ctx, "/a", ch)
+		took := time.Since(start)
+		if preserve && took < 2*delay {
+			t.Errorf("replay was too fast: %v", took)
+		}
+		if !preserve && took >= 2*delay {
+			t.Errorf("replay was too slow: %v", took)
+		}
+	}
+}
+
+// follower adds a trivial implementation of filewalk.LinkFollower to memfs.
+type follower struct {
+	*memfs.FS
+}
+
+func (f *follower) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	info, err := f.Stat(ctx, path)
+	info.Name = "target"
+	return info, err
+}
+
+func (f *follower) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return 1, 2, true
+}
+
+func TestForwarding(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetContents("/a/f0", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	rec, err := record.NewRecorder(&follower{fs}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := rec.Open(ctx, "/a/f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if got, want := string(data), "x"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	info, err := rec.StatFollow(ctx, "/a/f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, inode, ok := rec.FileID(info); !ok || inode != 2 {
+		t.Errorf("got %v, %v, want 2, true", inode, ok)
+	}
+
+	rp, err := record.NewReplayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := rp.StatFollow(ctx, "/a/f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := replayed, info; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// StatFollow and Stat are recorded separately.
+	if _, err := rp.Stat(ctx, "/a/f1"); !errors.Is(err, record.ErrNotRecorded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, ok := rp.FileID(info); ok {
+		t.Errorf("unexpected file id")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package record
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// ErrNotRecorded is returned, wrapped, by a Replayer for calls that do not
+// appear in its recording.
+var ErrNotRecorded = errors.New("not recorded")
+
+// Error represents an error returned by the recorded filesystem.
+type Error struct {
+	Msg        string
+	NotExist   bool // true if IsNotExist returned true for the original error.
+	Permission bool // true if IsPermissionError returned true for the original error.
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *recordedError) error() error {
+	if e == nil {
+		return nil
+	}
+	return &Error{Msg: e.Msg, NotExist: e.NotExist, Permission: e.Permission}
+}
+
+// Option represents an option accepted by NewReplayer.
+type Option func(o *options)
+
+type options struct {
+	latency bool
+}
+
+// PreserveLatency controls whether the recorded latencies of Stat calls
+// and of each Contents returned by List calls are reproduced when they
+// are replayed. Doing so preserves the relative timing of concurrent calls
+// and hence may be used to reproduce timing dependent behaviour.
+func PreserveLatency(v bool) Option {
+	return func(o *options) {
+		o.latency = v
+	}
+}
+
+type callKey struct {
+	op   op
+	path string
+}
+
+// Replayer is a filewalk.Filesystem that replays a recording created by a
+// Recorder. Calls for the same path are replayed in the order in which they
+// were recorded with the last recorded call being replayed once all others
+// have been. It also implements filewalk.LinkFollower so that recorded
+// StatFollow calls may be replayed. It is safe for concurrent use.
+type Replayer struct {
+	opts options
+	sep  string
+
+	mu    sync.Mutex
+	calls map[callKey][]*entry
+	next  map[callKey]int
+}
+
+// NewReplayer returns a Replayer for the recording read from rd.
+func NewReplayer(rd io.Reader, opts ...Option) (*Replayer, error) {
+	r := &Replayer{
+		calls: map[callKey][]*entry{},
+		next:  map[callKey]int{},
+	}
+	for _, fn := range opts {
+		fn(&r.opts)
+	}
+	dec := gob.NewDecoder(rd)
+	var hdr header
+	if err := dec.Decode(&hdr); err != nil {
+		return nil, fmt.Errorf("failed to read recording header: %w", err)
+	}
+	if hdr.Version != version {
+		return nil, fmt.Errorf("unsupported recording version: %v", hdr.Version)
+	}
+	r.sep = hdr.Separator
+	for {
+		e := &entry{}
+		if err := dec.Decode(e); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to read recording: %w", err)
+		}
+		key := callKey{e.Op, e.Path}
+		r.calls[key] = append(r.calls[key], e)
+	}
+	return r, nil
+}
+
+func (r *Replayer) lookup(o op, path string) *entry {
+	key := callKey{o, path}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls[key]
+	if len(calls) == 0 {
+		return nil
+	}
+	i := r.next[key]
+	if i < len(calls)-1 {
+		r.next[key] = i + 1
+	}
+	return calls[i]
+}
+
+// wait waits until d has elapsed since start if latencies are being
+// preserved.
+func (r *Replayer) wait(ctx context.Context, start time.Time, d time.Duration) error {
+	if !r.opts.latency {
+		return nil
+	}
+	d -= time.Since(start)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *Replayer) stat(ctx context.Context, o op, path string) (filewalk.Info, error) {
+	start := time.Now()
+	e := r.lookup(o, path)
+	if e == nil {
+		name := "stat"
+		if o == opStatFollow {
+			name = "statfollow"
+		}
+		return filewalk.Info{}, fmt.Errorf("%v: %v: %w", name, path, ErrNotRecorded)
+	}
+	if err := r.wait(ctx, start, e.Latency); err != nil {
+		return filewalk.Info{}, err
+	}
+	return e.Info, e.Err.error()
+}
+
+// Stat implements filewalk.Filesystem.
+func (r *Replayer) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	return r.stat(ctx, opStat, path)
+}
+
+// StatFollow implements filewalk.LinkFollower.
+func (r *Replayer) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	return r.stat(ctx, opStatFollow, path)
+}
+
+// FileID implements filewalk.LinkFollower. It always returns false since
+// the system specific information from which device and inode numbers are
+// obtained is not recorded.
+func (r *Replayer) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return 0, 0, false
+}
+
+// List implements filewalk.Filesystem.
+func (r *Replayer) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	start := time.Now()
+	e := r.lookup(opList, path)
+	if e == nil {
+		ch <- filewalk.Contents{
+			Path: path,
+			Err:  fmt.Errorf("list: %v: %w", path, ErrNotRecorded),
+		}
+		return
+	}
+	for _, b := range e.Batches {
+		if err := r.wait(ctx, start, b.Delay); err != nil {
+			ch <- filewalk.Contents{Path: path, Err: err}
+			return
+		}
+		ch <- filewalk.Contents{
+			Path:     b.Path,
+			Children: b.Children,
+			Files:    b.Files,
+			Err:      b.Err.error(),
+		}
+	}
+}
+
+// Join implements filewalk.Filesystem. The components are joined using
+// the separator used by the recorded filesystem with any leading or
+// trailing separators being removed from all but the first component.
+func (r *Replayer) Join(components ...string) string {
+	if len(components) == 0 {
+		return ""
+	}
+	p := strings.TrimSuffix(components[0], r.sep)
+	for _, c := range components[1:] {
+		c = strings.Trim(c, r.sep)
+		if len(c) == 0 {
+			continue
+		}
+		p += r.sep + c
+	}
+	if len(p) == 0 {
+		return components[0]
+	}
+	return p
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (r *Replayer) IsPermissionError(err error) bool {
+	var re *Error
+	return errors.As(err, &re) && re.Permission
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (r *Replayer) IsNotExist(err error) bool {
+	var re *Error
+	return errors.As(err, &re) && re.NotExist
+}
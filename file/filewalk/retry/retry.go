@@ -0,0 +1,258 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package retry provides a filewalk.Filesystem that wraps another
+// filewalk.Filesystem in order to retry Stat and List calls that fail with
+// transient errors. Retries are made using exponential backoff with jitter
+// and the errors that are considered retryable are determined by a
+// pluggable classifier.
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	maxAttempts int
+	initial     time.Duration
+	max         time.Duration
+	classifier  func(error) bool
+}
+
+// MaxAttempts sets the maximum number of attempts, including the first,
+// that will be made for any one call. The default is 5.
+func MaxAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAttempts = n
+	}
+}
+
+// Backoff sets the initial delay before the first retry and the maximum
+// delay between any two attempts. The delay is doubled after every
+// attempt and a random jitter of up to half of the delay is applied.
+// The defaults are 100ms and 10s.
+func Backoff(initial, max time.Duration) Option {
+	return func(o *options) {
+		o.initial = initial
+		o.max = max
+	}
+}
+
+// Classifier sets the function used to determine if an error is
+// retryable. The default is IsTemporary.
+func Classifier(fn func(error) bool) Option {
+	return func(o *options) {
+		o.classifier = fn
+	}
+}
+
+// IsTemporary returns true if err, or any error that it wraps, implements
+// either a Temporary or a Timeout method that returns true. Errors
+// resulting from a canceled or expired context are never considered
+// temporary.
+func IsTemporary(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// Stats represents the counters maintained by a retrying Filesystem.
+type Stats struct {
+	// Calls is the number of calls to Stat, StatFollow, Open and List.
+	Calls int64
+	// Retries is the number of retries made.
+	Retries int64
+	// Failures is the number of calls that failed with a retryable
+	// error after all attempts had been exhausted.
+	Failures int64
+}
+
+// FS is a filewalk.Filesystem that retries failed operations. It also
+// implements filewalk.Reader and filewalk.LinkFollower by forwarding them,
+// with the same retry policy as Stat, to the Filesystem it wraps; see
+// filewalk.Open and filewalk.StatFollow.
+type FS struct {
+	fs   filewalk.Filesystem
+	opts options
+
+	calls, retries, failures int64
+}
+
+// New returns a filewalk.Filesystem that retries Stat and List calls on
+// the supplied Filesystem that fail with a retryable error. List calls
+// are only retried if the error is encountered before any Contents have
+// been returned; errors encountered after that are returned as is.
+func New(fs filewalk.Filesystem, opts ...Option) *FS {
+	r := &FS{fs: fs}
+	r.opts.maxAttempts = 5
+	r.opts.initial = 100 * time.Millisecond
+	r.opts.max = 10 * time.Second
+	r.opts.classifier = IsTemporary
+	for _, fn := range opts {
+		fn(&r.opts)
+	}
+	return r
+}
+
+// Stats returns the current values of the counters maintained by r.
+func (r *FS) Stats() Stats {
+	return Stats{
+		Calls:    atomic.LoadInt64(&r.calls),
+		Retries:  atomic.LoadInt64(&r.retries),
+		Failures: atomic.LoadInt64(&r.failures),
+	}
+}
+
+// backoff returns the delay to use before the specified retry, starting
+// at 1.
+func (r *FS) backoff(retry int) time.Duration {
+	d := r.opts.initial
+	for i := 1; i < retry && d < r.opts.max; i++ {
+		d *= 2
+	}
+	if d > r.opts.max {
+		d = r.opts.max
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half+1))
+	}
+	return d
+}
+
+// retry determines if a call that failed with err on the specified
+// attempt should be retried and if so waits for the appropriate backoff
+// delay.
+func (r *FS) retry(ctx context.Context, attempt int, err error) bool {
+	if !r.opts.classifier(err) {
+		return false
+	}
+	if attempt >= r.opts.maxAttempts {
+		atomic.AddInt64(&r.failures, 1)
+		return false
+	}
+	timer := time.NewTimer(r.backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	}
+	atomic.AddInt64(&r.retries, 1)
+	return true
+}
+
+// Stat implements filewalk.Filesystem.
+func (r *FS) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	atomic.AddInt64(&r.calls, 1)
+	for attempt := 1; ; attempt++ {
+		info, err := r.fs.Stat(ctx, path)
+		if err == nil || !r.retry(ctx, attempt, err) {
+			return info, err
+		}
+	}
+}
+
+// StatFollow implements filewalk.LinkFollower.
+func (r *FS) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	atomic.AddInt64(&r.calls, 1)
+	for attempt := 1; ; attempt++ {
+		info, err := filewalk.StatFollow(ctx, r.fs, path)
+		if err == nil || !r.retry(ctx, attempt, err) {
+			return info, err
+		}
+	}
+}
+
+// FileID implements filewalk.LinkFollower.
+func (r *FS) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return filewalk.FileID(r.fs, info)
+}
+
+// Open implements filewalk.Reader. Only the call to Open is retried,
+// errors encountered reading the returned io.ReadCloser are not.
+func (r *FS) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	atomic.AddInt64(&r.calls, 1)
+	for attempt := 1; ; attempt++ {
+		rc, err := filewalk.Open(ctx, r.fs, path)
+		if err == nil || !r.retry(ctx, attempt, err) {
+			return rc, err
+		}
+	}
+}
+
+// List implements filewalk.Filesystem.
+func (r *FS) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	atomic.AddInt64(&r.calls, 1)
+	for attempt := 1; ; attempt++ {
+		if !r.list(ctx, attempt, path, ch) {
+			return
+		}
+	}
+}
+
+// list makes a single attempt at listing path and returns true if it
+// should be retried.
+func (r *FS) list(ctx context.Context, attempt int, path string, ch chan<- filewalk.Contents) bool {
+	lch := make(chan filewalk.Contents)
+	go func() {
+		r.fs.List(ctx, path, lch)
+		close(lch)
+	}()
+	first, ok := <-lch
+	if !ok {
+		return false
+	}
+	if first.Err == nil {
+		ch <- first
+		for contents := range lch {
+			ch <- contents
+		}
+		return false
+	}
+	// Collect any remaining Contents before deciding whether to retry
+	// so that the goroutine above will exit.
+	rest := []filewalk.Contents{}
+	for contents := range lch {
+		rest = append(rest, contents)
+	}
+	if r.retry(ctx, attempt, first.Err) {
+		return true
+	}
+	ch <- first
+	for _, contents := range rest {
+		ch <- contents
+	}
+	return false
+}
+
+// Join implements filewalk.Filesystem.
+func (r *FS) Join(components ...string) string {
+	return r.fs.Join(components...)
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (r *FS) IsPermissionError(err error) bool {
+	return r.fs.IsPermissionError(err)
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (r *FS) IsNotExist(err error) bool {
+	return r.fs.IsNotExist(err)
+}
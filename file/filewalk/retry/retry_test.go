@@ -0,0 +1,208 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+	"cloudeng.io/file/filewalk/retry"
+)
+
+type temporary struct{}
+
+func (temporary) Error() string   { return "temporary" }
+func (temporary) Temporary() bool { return true }
+
+// flaky fails the first n calls to Stat and List with err.
+type flaky struct {
+	*memfs.FS
+	sync.Mutex
+	n   int
+	err error
+}
+
+func (f *flaky) fail() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.n == 0 {
+		return nil
+	}
+	f.n--
+	return f.err
+}
+
+func (f *flaky) Stat(ctx context.Context, path string) (filewalk.Info, error) {
+	if err := f.fail(); err != nil {
+		return filewalk.Info{}, err
+	}
+	return f.FS.Stat(ctx, path)
+}
+
+func (f *flaky) List(ctx context.Context, path string, ch chan<- filewalk.Contents) {
+	if err := f.fail(); err != nil {
+		ch <- filewalk.Contents{Path: path, Err: err}
+		return
+	}
+	f.FS.List(ctx, path, ch)
+}
+
+func newFlaky(t *testing.T, n int, err error) *flaky {
+	fs, err2 := memfs.Parse("a/\n  f0\n  f1\n")
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	return &flaky{FS: fs, n: n, err: err}
+}
+
+func list(fs filewalk.Filesystem, path string) (int, error) {
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		fs.List(context.Background(), path, ch)
+		close(ch)
+	}()
+	files := 0
+	var err error
+	for c := range ch {
+		if c.Err != nil {
+			err = c.Err
+		}
+		files += len(c.Files)
+	}
+	return files, err
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	backoff := retry.Backoff(time.Millisecond, 2*time.Millisecond)
+	fs := retry.New(newFlaky(t, 3, fmt.Errorf("wrapped: %w", temporary{})), backoff)
+	if _, err := fs.Stat(ctx, "/a/f0"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fs.Stats(), (retry.Stats{Calls: 1, Retries: 3}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	fs = retry.New(newFlaky(t, 2, temporary{}), backoff)
+	files, err := list(fs, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := files, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := fs.Stats(), (retry.Stats{Calls: 1, Retries: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Give up after MaxAttempts.
+	fs = retry.New(newFlaky(t, 10, temporary{}), backoff, retry.MaxAttempts(3))
+	if _, err := fs.Stat(ctx, "/a/f0"); !errors.Is(err, temporary{}) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := list(fs, "/a"); !errors.Is(err, temporary{}) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if got, want := fs.Stats(), (retry.Stats{Calls: 2, Retries: 4, Failures: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Non-retryable errors are returned immediately.
+	fs = retry.New(newFlaky(t, 1, os.ErrPermission), backoff)
+	if _, err := fs.Stat(ctx, "/a/f0"); !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if got, want := fs.Stats(), (retry.Stats{Calls: 1}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Custom classifier.
+	fs = retry.New(newFlaky(t, 1, os.ErrPermission), backoff,
+		retry.Classifier(func(err error) bool { return errors.Is(err, os.ErrPermission) }))
+	if _, err := fs.Stat(ctx, "/a/f0"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	for i, tc := range []struct {
+		err       error
+		temporary bool
+	}{
+		{nil, false},
+		{os.ErrNotExist, false},
+		{temporary{}, true},
+		{fmt.Errorf("x: %w", temporary{}), true},
+		{&os.PathError{Op: "stat", Path: "x", Err: os.ErrDeadlineExceeded}, true},
+		{ctx.Err(), false},
+		{context.Canceled, false},
+	} {
+		if got, want := retry.IsTemporary(tc.err), tc.temporary; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.err, got, want)
+		}
+	}
+}
+
+func (f *flaky) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.FS.Open(ctx, path)
+}
+
+func (f *flaky) StatFollow(ctx context.Context, path string) (filewalk.Info, error) {
+	if err := f.fail(); err != nil {
+		return filewalk.Info{}, err
+	}
+	return f.FS.Stat(ctx, path)
+}
+
+func (f *flaky) FileID(info filewalk.Info) (device, inode uint64, ok bool) {
+	return 1, 2, true
+}
+
+func TestForwarding(t *testing.T) {
+	ctx := context.Background()
+	backoff := retry.Backoff(time.Millisecond, 2*time.Millisecond)
+	fs := retry.New(newFlaky(t, 2, temporary{}), backoff)
+	rc, err := fs.Open(ctx, "/a/f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	info, err := fs.StatFollow(ctx, "/a/f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Name, "f1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, inode, ok := fs.FileID(info); !ok || inode != 2 {
+		t.Errorf("got %v, %v, want 2, true", inode, ok)
+	}
+	if got, want := fs.Stats(), (retry.Stats{Calls: 2, Retries: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// A Filesystem that implements neither interface.
+	fs = retry.New(struct{ filewalk.Filesystem }{newFlaky(t, 0, nil)}, backoff)
+	if _, err := fs.Open(ctx, "/a/f0"); !errors.Is(err, filewalk.ErrNotSupported) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, _, ok := fs.FileID(info); ok {
+		t.Errorf("unexpected file id")
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package s3fs provides an implementation of filewalk.Filesystem for AWS S3
+// and S3-compatible object stores. It uses the ListObjectsV2 REST API
+// with / as the delimiter so that common prefixes are returned as
+// Contents.Children and objects as Contents.Files. Each page of results,
+// as determined by the continuation token returned by S3, is sent as a
+// separate Contents message. Paths are parsed using cloudpath.AWSS3Matcher
+// and hence may be of the form s3://bucket/key or any of the https forms
+// it recognises. Keys are used verbatim, that is, they are not URL decoded
+// and may contain any of the characters, such as #, ? or %, that are
+// special to URLs.
+package s3fs
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/path/cloudpath"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	endpoint    string
+	region      string
+	client      *http.Client
+	scanSize    int
+	fetchOwner  bool
+	credentials *Credentials
+}
+
+// Endpoint sets the endpoint to be used for all requests, for example
+// http://localhost:9000 for a local S3-compatible server. Requests are
+// made using path-style addressing, ie. <endpoint>/<bucket>/<key>. The
+// default is https://s3.<region>.amazonaws.com.
+func Endpoint(endpoint string) Option {
+	return func(o *options) {
+		o.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// Region sets the AWS region, the default is us-east-1.
+func Region(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// HTTPClient sets the http.Client to use, the default is http.DefaultClient.
+func HTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// ScanSize sets the maximum number of keys to be returned by each call to
+// ListObjectsV2 and hence in each Contents message. The default, 0,
+// uses the server's default (1000 for AWS S3).
+func ScanSize(n int) Option {
+	return func(o *options) {
+		o.scanSize = n
+	}
+}
+
+// FetchOwner requests that the owner of each object be returned, in which
+// case the owner ID is used for filewalk.Info.UserID.
+func FetchOwner(v bool) Option {
+	return func(o *options) {
+		o.fetchOwner = v
+	}
+}
+
+// WithCredentials sets the credentials used to sign requests. Requests
+// are unsigned, ie. anonymous, if no credentials are supplied.
+func WithCredentials(creds Credentials) Option {
+	return func(o *options) {
+		o.credentials = &creds
+	}
+}
+
+// EnvCredentials obtains credentials from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables. It
+// is a no-op if AWS_ACCESS_KEY_ID is not set.
+func EnvCredentials() Option {
+	return func(o *options) {
+		id := os.Getenv("AWS_ACCESS_KEY_ID")
+		if len(id) == 0 {
+			return
+		}
+		o.credentials = &Credentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+}
+
+type s3fs struct {
+	opts options
+}
+
+// New returns a new instance of filewalk.Filesystem for S3.
+func New(opts ...Option) filewalk.Filesystem {
+	fs := &s3fs{}
+	fs.opts.region = "us-east-1"
+	fs.opts.client = http.DefaultClient
+	for _, fn := range opts {
+		fn(&fs.opts)
+	}
+	if len(fs.opts.endpoint) == 0 {
+		fs.opts.endpoint = "https://s3." + fs.opts.region + ".amazonaws.com"
+	}
+	return fs
+}
+
+// Error represents an error returned by S3.
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	Resource   string `xml:"Resource"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("s3: %v: %v", e.StatusCode, e.Code)
+	if len(e.Message) > 0 {
+		msg += ": " + e.Message
+	}
+	if len(e.Resource) > 0 {
+		msg += ": " + e.Resource
+	}
+	return msg
+}
+
+// Temporary returns true if the error is likely to be transient, ie. the
+// request was throttled or the service was unavailable, and hence
+// that the request may be retried.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func newError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := xml.Unmarshal(body, e); err != nil || len(e.Code) == 0 {
+		e.Code = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// urlEscaper escapes the characters that are special to URLs so that
+// keys containing them survive being parsed by cloudpath.AWSS3Matcher,
+// which decodes them again.
+var urlEscaper = strings.NewReplacer("%", "%25", "#", "%23", "?", "%3F")
+
+// bucketAndKey returns the bucket and key encoded in p as determined by
+// cloudpath.AWSS3Matcher. The key is returned verbatim.
+func bucketAndKey(p string) (string, string, error) {
+	match := cloudpath.AWSS3Matcher(urlEscaper.Replace(p))
+	if match == nil || len(match.Volume) == 0 {
+		return "", "", fmt.Errorf("not an s3 path: %v", p)
+	}
+	key := strings.TrimPrefix(match.Path, "/")
+	if !strings.HasPrefix(match.Host, match.Volume+".") {
+		// The bucket is the first component of the path for all but
+		// virtual-hosted style URLs.
+		key = strings.TrimPrefix(key, match.Volume)
+	}
+	return match.Volume, strings.TrimPrefix(key, "/"), nil
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+		StorageClass string    `xml:"StorageClass"`
+		Owner        struct {
+			ID string `xml:"ID"`
+		} `xml:"Owner"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+func (fs *s3fs) do(ctx context.Context, method, bucket, key string, query url.Values) (*http.Response, error) {
+	u := fs.opts.endpoint + "/" + bucket
+	if len(key) > 0 {
+		u += "/" + (&url.URL{Path: key}).EscapedPath()
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if creds := fs.opts.credentials; creds != nil {
+		signV4(req, *creds, fs.opts.region, time.Now())
+	}
+	resp, err := fs.opts.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newError(resp)
+	}
+	return resp, nil
+}
+
+func (fs *s3fs) listObjects(ctx context.Context, bucket, prefix, token string, maxKeys int) (*listBucketResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("delimiter", "/")
+	query.Set("prefix", prefix)
+	if len(token) > 0 {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	if fs.opts.fetchOwner {
+		query.Set("fetch-owner", "true")
+	}
+	resp, err := fs.do(ctx, http.MethodGet, bucket, "", query)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &listBucketResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func prefixInfo(name string) filewalk.Info {
+	return filewalk.Info{
+		Name: name,
+		Mode: filewalk.ModePrefix | 0700,
+	}
+}
+
+// Stat implements filewalk.Filesystem.
+func (fs *s3fs) Stat(ctx context.Context, p string) (filewalk.Info, error) {
+	bucket, key, err := bucketAndKey(p)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	if len(key) == 0 {
+		_, err := fs.listObjects(ctx, bucket, "", "", 1)
+		if err != nil {
+			return filewalk.Info{}, err
+		}
+		return prefixInfo(bucket), nil
+	}
+	name := key[strings.LastIndex(strings.TrimSuffix(key, "/"), "/")+1:]
+	name = strings.TrimSuffix(name, "/")
+	// Walks are more likely to Stat prefixes than objects and hence
+	// check for a prefix first.
+	result, err := fs.listObjects(ctx, bucket, strings.TrimSuffix(key, "/")+"/", "", 1)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	if len(result.Contents) > 0 || len(result.CommonPrefixes) > 0 {
+		return prefixInfo(name), nil
+	}
+	resp, err := fs.do(ctx, http.MethodHead, bucket, key, nil)
+	if err != nil {
+		return filewalk.Info{}, err
+	}
+	resp.Body.Close()
+	info := filewalk.Info{
+		Name: name,
+		Mode: 0600,
+	}
+	info.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// List implements filewalk.Filesystem.
+func (fs *s3fs) List(ctx context.Context, p string, ch chan<- filewalk.Contents) {
+	bucket, key, err := bucketAndKey(p)
+	if err != nil {
+		ch <- filewalk.Contents{Path: p, Err: err}
+		return
+	}
+	prefix := key
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	token := ""
+	for {
+		select {
+		case <-ctx.Done():
+			ch <- filewalk.Contents{Path: p, Err: ctx.Err()}
+			return
+		default:
+		}
+		result, err := fs.listObjects(ctx, bucket, prefix, token, fs.opts.scanSize)
+		if err != nil {
+			ch <- filewalk.Contents{Path: p, Err: err}
+			return
+		}
+		contents := filewalk.Contents{Path: p}
+		for _, cp := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(cp.Prefix, prefix), "/")
+			if len(name) == 0 {
+				continue
+			}
+			contents.Children = append(contents.Children, prefixInfo(name))
+		}
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, prefix)
+			if len(name) == 0 {
+				// Ignore 'directory' placeholder objects.
+				continue
+			}
+			contents.Files = append(contents.Files, filewalk.Info{
+				Name:    name,
+				UserID:  obj.Owner.ID,
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+				Mode:    0600,
+			})
+		}
+		if len(contents.Children) > 0 || len(contents.Files) > 0 {
+			ch <- contents
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Open implements filewalk.Reader using the GetObject REST API.
+func (fs *s3fs) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	bucket, key, err := bucketAndKey(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 || strings.HasSuffix(key, "/") {
+		return nil, fmt.Errorf("%v: is not an object", p)
+	}
+	resp, err := fs.do(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Join implements filewalk.Filesystem.
+func (fs *s3fs) Join(components ...string) string {
+	if len(components) == 0 {
+		return ""
+	}
+	p := strings.TrimSuffix(components[0], "/")
+	for _, c := range components[1:] {
+		c = strings.Trim(c, "/")
+		if len(c) == 0 {
+			continue
+		}
+		p += "/" + c
+	}
+	return p
+}
+
+func statusCode(err error) int {
+	var s3err *Error
+	if errors.As(err, &s3err) {
+		return s3err.StatusCode
+	}
+	return 0
+}
+
+// IsPermissionError implements filewalk.Filesystem.
+func (fs *s3fs) IsPermissionError(err error) bool {
+	return statusCode(err) == http.StatusForbidden
+}
+
+// IsNotExist implements filewalk.Filesystem.
+func (fs *s3fs) IsNotExist(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
@@ -0,0 +1,436 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package s3fs_test
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/s3fs"
+)
+
+type object struct {
+	key  string
+	size int64
+}
+
+// fakeS3 implements the subset of the S3 REST API used by s3fs.
+type fakeS3 struct {
+	sync.Mutex
+	buckets   map[string][]object
+	forbidden map[string]bool
+	requests  []string
+	modTime   time.Time
+}
+
+type xmlObject struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+	Owner        *struct{ ID string } `xml:",omitempty"`
+}
+
+type xmlPrefix struct {
+	Prefix string
+}
+
+type xmlResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool
+	NextContinuationToken string      `xml:",omitempty"`
+	Contents              []xmlObject `xml:",omitempty"`
+	CommonPrefixes        []xmlPrefix `xml:",omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>oops</Message></Error>", code)
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.String())
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if s.forbidden[bucket] {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if r.Method == http.MethodHead {
+		for _, obj := range objects {
+			if obj.key == parts[1] {
+				w.Header().Set("Content-Length", strconv.FormatInt(obj.size, 10))
+				w.Header().Set("Last-Modified", s.modTime.Format(http.TimeFormat))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodGet && len(parts) == 2 && len(parts[1]) > 0 {
+		for _, obj := range objects {
+			if obj.key == parts[1] {
+				w.Write([]byte(strings.Repeat("x", int(obj.size))))
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	q := r.URL.Query()
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := 1000
+	if mk := q.Get("max-keys"); len(mk) > 0 {
+		maxKeys, _ = strconv.Atoi(mk)
+	}
+	start, _ := strconv.Atoi(q.Get("continuation-token"))
+	// Build the sorted list of keys and common prefixes.
+	type entry struct {
+		name   string
+		prefix bool
+		size   int64
+	}
+	seen := map[string]bool{}
+	var entries []entry
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.key, prefix) {
+			continue
+		}
+		rest := obj.key[len(prefix):]
+		if idx := strings.Index(rest, delim); len(delim) > 0 && idx >= 0 {
+			cp := prefix + rest[:idx+1]
+			if !seen[cp] {
+				seen[cp] = true
+				entries = append(entries, entry{name: cp, prefix: true})
+			}
+			continue
+		}
+		entries = append(entries, entry{name: obj.key, size: obj.size})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	result := xmlResult{}
+	end := start + maxKeys
+	if end < len(entries) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	} else {
+		end = len(entries)
+	}
+	for _, e := range entries[start:end] {
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, xmlPrefix{e.name})
+			continue
+		}
+		obj := xmlObject{Key: e.name, Size: e.size, LastModified: s.modTime}
+		if q.Get("fetch-owner") == "true" {
+			obj.Owner = &struct{ ID string }{"owner-id"}
+		}
+		result.Contents = append(result.Contents, obj)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		modTime: time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC),
+		buckets: map[string][]object{
+			"bucket": {
+				{"f0", 1},
+				{"a/", 0},
+				{"a/f1", 2},
+				{"a/f2", 3},
+				{"a/f3", 4},
+				{"a/b/f4", 5},
+				{"a/c/d/f5", 6},
+			},
+			"private": {{"f0", 1}},
+			"special": {
+				{"a#b/c", 1},
+				{"x?y/z", 2},
+				{"50%/z", 3},
+			},
+		},
+		forbidden: map[string]bool{"private": true},
+	}
+}
+
+func listAll(fs filewalk.Filesystem, p string) []filewalk.Contents {
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		fs.List(context.Background(), p, ch)
+		close(ch)
+	}()
+	var all []filewalk.Contents
+	for c := range ch {
+		all = append(all, c)
+	}
+	return all
+}
+
+func names(infos []filewalk.Info) []string {
+	n := []string{}
+	for _, i := range infos {
+		n = append(n, i.Name)
+	}
+	return n
+}
+
+func TestList(t *testing.T) {
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	fs := s3fs.New(s3fs.Endpoint(srv.URL), s3fs.ScanSize(2), s3fs.FetchOwner(true))
+	contents := listAll(fs, "s3://bucket/a")
+	if got, want := len(contents), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var children, files []string
+	var infos []filewalk.Info
+	for _, c := range contents {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		children = append(children, names(c.Children)...)
+		files = append(files, names(c.Files)...)
+		infos = append(infos, c.Files...)
+	}
+	if got, want := children, []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := files, []string{"f1", "f2", "f3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	f1 := infos[0]
+	if got, want := f1.Size, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f1.UserID, "owner-id"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f1.ModTime, s3.modTime; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	contents = listAll(fs, "s3://private/")
+	if got, want := len(contents), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := contents[0].Err; err == nil || !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	contents = listAll(fs, "s3://nowhere/")
+	if err := contents[0].Err; err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	contents = listAll(fs, "/local/path")
+	if err := contents[0].Err; err == nil || !strings.Contains(err.Error(), "not an s3 path") {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	fs := s3fs.New(s3fs.Endpoint(srv.URL))
+
+	for _, tc := range []struct {
+		path   string
+		name   string
+		prefix bool
+		size   int64
+	}{
+		{"s3://bucket", "bucket", true, 0},
+		{"s3://bucket/", "bucket", true, 0},
+		{"s3://bucket/a", "a", true, 0},
+		{"s3://bucket/a/c/", "c", true, 0},
+		{"s3://bucket/a/f2", "f2", false, 3},
+		{"https://s3.us-west-2.amazonaws.com/bucket/a/f2", "f2", false, 3},
+		{"https://bucket.s3.us-west-2.amazonaws.com/a/f2", "f2", false, 3},
+	} {
+		info, err := fs.Stat(ctx, tc.path)
+		if err != nil {
+			t.Errorf("%v: %v", tc.path, err)
+			continue
+		}
+		if got, want := info.Name, tc.name; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+		if got, want := info.IsPrefix(), tc.prefix; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+		if got, want := info.Size, tc.size; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+	if _, err := fs.Stat(ctx, "s3://bucket/a/nowhere"); err == nil || !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := fs.Stat(ctx, "gs://bucket/a"); err == nil {
+		t.Errorf("expected an error for a non-s3 path")
+	}
+
+	if got, want := fs.Join("s3://bucket/", "a/", "/b"), "s3://bucket/a/b"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	fs := s3fs.New(s3fs.Endpoint(srv.URL))
+	rd, ok := fs.(filewalk.Reader)
+	if !ok {
+		t.Fatalf("%T does not implement filewalk.Reader", fs)
+	}
+	rc, err := rd.Open(ctx, "s3://bucket/a/f2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf), "xxx"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := rd.Open(ctx, "s3://bucket/a/nowhere"); !fs.IsNotExist(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := rd.Open(ctx, "s3://private/f0"); !fs.IsPermissionError(err) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	if _, err := rd.Open(ctx, "s3://bucket/a/"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	fs := s3fs.New(s3fs.Endpoint(srv.URL), s3fs.ScanSize(1),
+		s3fs.WithCredentials(s3fs.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}))
+
+	var mu sync.Mutex
+	var lines []string
+	wk := filewalk.New(fs, filewalk.Concurrency(2))
+	err := wk.Walk(ctx,
+		func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+			return false, nil, err
+		},
+		func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+			children := []filewalk.Info{}
+			for c := range ch {
+				mu.Lock()
+				for _, f := range c.Files {
+					lines = append(lines, fs.Join(prefix, f.Name))
+				}
+				mu.Unlock()
+				children = append(children, c.Children...)
+			}
+			return children, nil
+		},
+		"s3://bucket",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	if got, want := lines, []string{
+		"s3://bucket/a/b/f4",
+		"s3://bucket/a/c/d/f5",
+		"s3://bucket/a/f1",
+		"s3://bucket/a/f2",
+		"s3://bucket/a/f3",
+		"s3://bucket/f0",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, req := range s3.requests {
+		if !strings.HasPrefix(req, "GET /bucket?") {
+			t.Errorf("unexpected request: %v", req)
+		}
+	}
+}
+
+func TestSpecialCharacters(t *testing.T) {
+	ctx := context.Background()
+	s3 := newFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	fs := s3fs.New(s3fs.Endpoint(srv.URL))
+
+	for _, tc := range []struct {
+		prefix, file string
+		size         int64
+	}{
+		{"a#b", "c", 1},
+		{"x?y", "z", 2},
+		{"50%", "z", 3},
+	} {
+		prefix := fs.Join("s3://special", tc.prefix)
+		info, err := fs.Stat(ctx, prefix)
+		if err != nil {
+			t.Errorf("%v: %v", prefix, err)
+			continue
+		}
+		if got, want := info.Name, tc.prefix; got != want || !info.IsPrefix() {
+			t.Errorf("%v: got %v, want %v", prefix, got, want)
+		}
+		contents := listAll(fs, prefix)
+		if got, want := len(contents), 1; got != want {
+			t.Errorf("%v: got %v, want %v", prefix, got, want)
+			continue
+		}
+		if got, want := names(contents[0].Files), []string{tc.file}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", prefix, got, want)
+		}
+		file := fs.Join(prefix, tc.file)
+		info, err = fs.Stat(ctx, file)
+		if err != nil {
+			t.Errorf("%v: %v", file, err)
+			continue
+		}
+		if got, want := info.Size, tc.size; got != want {
+			t.Errorf("%v: got %v, want %v", file, got, want)
+		}
+		rc, err := fs.(filewalk.Reader).Open(ctx, file)
+		if err != nil {
+			t.Errorf("%v: %v", file, err)
+			continue
+		}
+		buf, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if got, want := len(buf), int(tc.size); got != want {
+			t.Errorf("%v: got %v, want %v", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"cloudeng.io/errors"
+)
+
+// CoordinatorOption represents an option accepted by NewCoordinator.
+type CoordinatorOption func(o *coordinatorOptions)
+
+type coordinatorOptions struct {
+	leaseTimeout time.Duration
+}
+
+// LeaseTimeout sets the time within which a lease must be renewed,
+// reported on or completed before it expires and is reassigned. The
+// default is 30 seconds.
+func LeaseTimeout(d time.Duration) CoordinatorOption {
+	return func(o *coordinatorOptions) {
+		o.leaseTimeout = d
+	}
+}
+
+// CoordinatorStats represents the counters maintained by a Coordinator.
+type CoordinatorStats struct {
+	Leases     int64 // number of leases granted.
+	Completed  int64 // number of leases completed.
+	Reassigned int64 // number of leases that expired and were reassigned.
+	Results    int64 // number of results delivered.
+}
+
+type lease struct {
+	id      uint64
+	prefix  string
+	expires time.Time
+	results []Result
+}
+
+// Coordinator hands out prefixes to Workers and collects their results.
+type Coordinator struct {
+	opts   coordinatorOptions
+	fn     ResultFunc
+	server *rpc.Server
+
+	mu        sync.Mutex
+	started   bool
+	queue     []string
+	leases    map[uint64]*lease
+	completed map[string]bool
+	nextID    uint64
+	stats     CoordinatorStats
+	pending   []Result
+	doneCh    chan struct{}
+	notifyCh  chan struct{}
+}
+
+// NewCoordinator returns a new Coordinator that will call fn for every
+// result.
+func NewCoordinator(fn ResultFunc, opts ...CoordinatorOption) *Coordinator {
+	c := &Coordinator{
+		fn:        fn,
+		server:    rpc.NewServer(),
+		leases:    map[uint64]*lease{},
+		completed: map[string]bool{},
+		doneCh:    make(chan struct{}),
+		notifyCh:  make(chan struct{}, 1),
+	}
+	c.opts.leaseTimeout = 30 * time.Second
+	for _, fn := range opts {
+		fn(&c.opts)
+	}
+	if err := c.server.RegisterName("Coordinator", &service{c}); err != nil {
+		// Registration can only fail if service is incorrectly defined.
+		panic(err)
+	}
+	return c
+}
+
+// Stats returns the current values of the counters maintained by c.
+func (c *Coordinator) Stats() CoordinatorStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Completed returns the prefixes that have been completed so far.
+func (c *Coordinator) Completed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefixes := make([]string, 0, len(c.completed))
+	for p := range c.completed {
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
+
+// ServeConn serves a single connection to a Worker, for example one end
+// of a pair of pipes. It blocks until the connection is closed.
+func (c *Coordinator) ServeConn(conn io.ReadWriteCloser) {
+	c.server.ServeConn(conn)
+}
+
+// Run adds the specified roots to the queue of work and then serves
+// Workers that connect via ln, if ln is non-nil, until either all of
+// the work has been completed or the context is canceled. Workers may
+// also be served via ServeConn. Run closes ln before returning and
+// returns any errors returned by the ResultFunc.
+func (c *Coordinator) Run(ctx context.Context, ln net.Listener, roots ...string) error {
+	c.mu.Lock()
+	c.started = true
+	c.queue = append(c.queue, roots...)
+	c.checkDoneLocked()
+	c.mu.Unlock()
+
+	if ln != nil {
+		defer ln.Close()
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go c.server.ServeConn(conn)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(c.opts.leaseTimeout / 4)
+	defer ticker.Stop()
+	errs := errors.M{}
+	for {
+		select {
+		case <-ctx.Done():
+			errs.Append(ctx.Err())
+			return errs.Err()
+		case <-c.notifyCh:
+			errs.Append(c.deliver(ctx))
+		case <-ticker.C:
+			c.mu.Lock()
+			c.expireLocked()
+			c.mu.Unlock()
+		case <-c.doneCh:
+			errs.Append(c.deliver(ctx))
+			return errs.Err()
+		}
+	}
+}
+
+// deliver calls the ResultFunc for all pending results.
+func (c *Coordinator) deliver(ctx context.Context) error {
+	c.mu.Lock()
+	results := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	errs := errors.M{}
+	for _, r := range results {
+		errs.Append(c.fn(ctx, r))
+	}
+	return errs.Err()
+}
+
+// expireLocked requeues the prefixes for all expired leases; the caller
+// must hold c.mu.
+func (c *Coordinator) expireLocked() {
+	now := time.Now()
+	for id, l := range c.leases {
+		if now.After(l.expires) {
+			delete(c.leases, id)
+			c.queue = append(c.queue, l.prefix)
+			c.stats.Reassigned++
+		}
+	}
+}
+
+// checkDoneLocked signals completion if there is no more work to be done;
+// the caller must hold c.mu.
+func (c *Coordinator) checkDoneLocked() {
+	if !c.started || len(c.queue) > 0 || len(c.leases) > 0 {
+		return
+	}
+	select {
+	case <-c.doneCh:
+	default:
+		close(c.doneCh)
+	}
+}
+
+func (c *Coordinator) leaseLocked(id uint64) (*lease, error) {
+	l, ok := c.leases[id]
+	if !ok || time.Now().After(l.expires) {
+		return nil, ErrLeaseExpired
+	}
+	l.expires = time.Now().Add(c.opts.leaseTimeout)
+	return l, nil
+}
+
+func (c *Coordinator) lease(resp *LeaseResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+	select {
+	case <-c.doneCh:
+		resp.Done = true
+		return nil
+	default:
+	}
+	for len(c.queue) > 0 {
+		prefix := c.queue[0]
+		c.queue = c.queue[1:]
+		if c.completed[prefix] {
+			continue
+		}
+		c.nextID++
+		c.leases[c.nextID] = &lease{
+			id:      c.nextID,
+			prefix:  prefix,
+			expires: time.Now().Add(c.opts.leaseTimeout),
+		}
+		c.stats.Leases++
+		resp.ID, resp.Prefix, resp.Timeout = c.nextID, prefix, c.opts.leaseTimeout
+		return nil
+	}
+	c.checkDoneLocked()
+	return nil
+}
+
+func (c *Coordinator) renew(req RenewRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.leaseLocked(req.ID)
+	return err
+}
+
+func (c *Coordinator) report(req ReportRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.leaseLocked(req.ID)
+	if err != nil {
+		return err
+	}
+	l.results = append(l.results, req.Results...)
+	return nil
+}
+
+func (c *Coordinator) complete(req CompleteRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.leaseLocked(req.ID)
+	if err != nil {
+		return err
+	}
+	delete(c.leases, req.ID)
+	c.completed[l.prefix] = true
+	for _, p := range req.Prefixes {
+		if !c.completed[p] {
+			c.queue = append(c.queue, p)
+		}
+	}
+	c.stats.Completed++
+	c.stats.Results += int64(len(l.results))
+	c.pending = append(c.pending, l.results...)
+	select {
+	case c.notifyCh <- struct{}{}:
+	default:
+	}
+	c.checkDoneLocked()
+	return nil
+}
+
+// service implements the RPC interface to the Coordinator.
+type service struct {
+	c *Coordinator
+}
+
+// Lease grants a lease on a prefix.
+func (s *service) Lease(req Empty, resp *LeaseResponse) error {
+	return s.c.lease(resp)
+}
+
+// Renew renews a lease.
+func (s *service) Renew(req RenewRequest, resp *Empty) error {
+	return s.c.renew(req)
+}
+
+// Report adds results to a lease.
+func (s *service) Report(req ReportRequest, resp *Empty) error {
+	return s.c.report(req)
+}
+
+// Complete completes a lease.
+func (s *service) Complete(req CompleteRequest, resp *Empty) error {
+	return s.c.complete(req)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package shard provides support for sharding a walk across multiple
+// worker processes. A Coordinator maintains a queue of prefixes and hands
+// them out, as leases, to Workers over net/rpc, typically via a unix
+// domain socket or a pair of pipes. Each Worker walks the sub-tree rooted
+// at its leased prefix, down to a configurable depth, and streams the
+// results back to the Coordinator. Prefixes found at that depth are
+// returned to the Coordinator's queue rather than being walked so that
+// large hierarchies are spread across all of the Workers.
+//
+// The results for a lease are only delivered, via a ResultFunc, once the
+// lease has been completed. Leases that are not renewed, for example
+// because their Worker has died, expire and are reassigned with any
+// results streamed for them being discarded. Hence the results for every
+// prefix are delivered exactly once.
+package shard
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// ErrLeaseExpired is returned by the Coordinator for operations on a lease
+// that has expired, or otherwise does not exist.
+var ErrLeaseExpired = errors.New("lease expired")
+
+// Result represents the outcome of walking a single prefix.
+type Result struct {
+	Prefix   string
+	Info     filewalk.Info   // as returned by Stat for the prefix.
+	Children []filewalk.Info // prefixes contained within this prefix.
+	Files    []filewalk.Info // files contained within this prefix.
+	Err      string          // non-empty if the prefix could not be listed in full.
+}
+
+// ResultFunc is called by the Coordinator for every Result once the lease
+// that produced it has been completed. It is called by a single goroutine
+// at a time.
+type ResultFunc func(ctx context.Context, result Result) error
+
+// The following types are used by the RPC protocol between Workers and
+// the Coordinator.
+
+// LeaseResponse is the reply to Coordinator.Lease. If Done is set then
+// all work has been completed. If neither Done is set nor a prefix is
+// returned then the Worker should try again later.
+type LeaseResponse struct {
+	ID      uint64
+	Prefix  string
+	Timeout time.Duration // the lease must be renewed within this time.
+	Done    bool
+}
+
+// RenewRequest is the argument to Coordinator.Renew.
+type RenewRequest struct {
+	ID uint64
+}
+
+// ReportRequest is the argument to Coordinator.Report.
+type ReportRequest struct {
+	ID      uint64
+	Results []Result
+}
+
+// CompleteRequest is the argument to Coordinator.Complete.
+type CompleteRequest struct {
+	ID uint64
+	// Prefixes that were not walked and hence need to be added to the queue.
+	Prefixes []string
+}
+
+// Empty is used for the arguments and replies that carry no data.
+type Empty struct{}
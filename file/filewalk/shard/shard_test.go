@@ -0,0 +1,237 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package shard_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+	"cloudeng.io/file/filewalk/shard"
+)
+
+const tree = `
+a/
+  f0 size=1
+  b/
+    f1 size=2
+    c/
+      f2 size=3
+      d/
+        f3 size=4
+  e/
+x/
+  f4 size=5
+  y/
+    z/
+      f5 size=6
+`
+
+func newFS(t *testing.T) *memfs.FS {
+	fs, err := memfs.Parse(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetError(memfs.List, "/x/y/z", fmt.Errorf("oops"))
+	return fs
+}
+
+func format(prefix string, files []filewalk.Info, err string) string {
+	names := []string{}
+	for _, f := range files {
+		names = append(names, fmt.Sprintf("%v:%v", f.Name, f.Size))
+	}
+	return fmt.Sprintf("%v %v %q", prefix, names, err)
+}
+
+// direct walks fs with a single Walker.
+func direct(t *testing.T, fs filewalk.Filesystem) []string {
+	var mu sync.Mutex
+	var lines []string
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		return false, nil, err
+	}
+	contentsFn := func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+		var children, files []filewalk.Info
+		var err string
+		for c := range ch {
+			if c.Err != nil {
+				err = c.Err.Error()
+				continue
+			}
+			children = append(children, c.Children...)
+			files = append(files, c.Files...)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, format(prefix, files, err))
+		return children, nil
+	}
+	if err := filewalk.New(fs).Walk(context.Background(), prefixFn, contentsFn, "/"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+type collector struct {
+	sync.Mutex
+	lines []string
+}
+
+func (c *collector) result(ctx context.Context, r shard.Result) error {
+	c.Lock()
+	defer c.Unlock()
+	c.lines = append(c.lines, format(r.Prefix, r.Files, r.Err))
+	return nil
+}
+
+func (c *collector) sorted() []string {
+	c.Lock()
+	defer c.Unlock()
+	sort.Strings(c.lines)
+	return c.lines
+}
+
+func listen(t *testing.T) (net.Listener, string) {
+	addr := filepath.Join(t.TempDir(), "coordinator.sock")
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln, addr
+}
+
+func runWorkers(ctx context.Context, t *testing.T, addr string, n int, opts ...shard.WorkerOption) (*sync.WaitGroup, []*shard.Worker) {
+	var wg sync.WaitGroup
+	workers := make([]*shard.Worker, n)
+	for i := range workers {
+		conn, err := net.Dial("unix", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers[i] = shard.NewWorker(newFS(t), opts...)
+		wg.Add(1)
+		go func(w *shard.Worker) {
+			defer wg.Done()
+			if err := w.Run(ctx, conn); err != nil {
+				t.Errorf("worker failed: %v", err)
+			}
+		}(workers[i])
+	}
+	return &wg, workers
+}
+
+func TestShard(t *testing.T) {
+	ctx := context.Background()
+	for _, depth := range []int{0, 1, 2} {
+		ln, addr := listen(t)
+		col := &collector{}
+		coord := shard.NewCoordinator(col.result)
+		opts := []shard.WorkerOption{shard.SplitDepth(depth), shard.ReportSize(1), shard.PollInterval(time.Millisecond)}
+		wg, workers := runWorkers(ctx, t, addr, 3, opts...)
+
+		// An additional worker connected via a pipe.
+		wconn, cconn := net.Pipe()
+		go coord.ServeConn(cconn)
+		pw := shard.NewWorker(newFS(t), opts...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pw.Run(ctx, wconn); err != nil {
+				t.Errorf("worker failed: %v", err)
+			}
+		}()
+		workers = append(workers, pw)
+
+		if err := coord.Run(ctx, ln, "/"); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+
+		if got, want := col.sorted(), direct(t, newFS(t)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", depth, got, want)
+		}
+		var prefixes, leases int64
+		for _, w := range workers {
+			prefixes += w.Stats().Prefixes
+			leases += w.Stats().Leases
+		}
+		if got, want := prefixes, int64(9); got != want {
+			t.Errorf("%v: got %v, want %v", depth, got, want)
+		}
+		stats := coord.Stats()
+		if got, want := leases, stats.Completed; got != want {
+			t.Errorf("%v: got %v, want %v", depth, got, want)
+		}
+		if got, want := stats.Results, int64(9); got != want {
+			t.Errorf("%v: got %v, want %v", depth, got, want)
+		}
+		if got, want := int64(len(coord.Completed())), stats.Completed; got != want {
+			t.Errorf("%v: got %v, want %v", depth, got, want)
+		}
+		// The depth determines the number of leases.
+		if got, want := stats.Leases, map[int]int64{0: 1, 1: 9, 2: 5}[depth]; got != want {
+			t.Errorf("%v: got %v, want %v", depth, got, want)
+		}
+	}
+}
+
+func TestReassign(t *testing.T) {
+	ctx := context.Background()
+	ln, addr := listen(t)
+	col := &collector{}
+	coord := shard.NewCoordinator(col.result, shard.LeaseTimeout(50*time.Millisecond))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- coord.Run(ctx, ln, "/a", "/x")
+	}()
+
+	// Obtain a lease and then never complete it, as if the worker had died.
+	client, err := rpc.Dial("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var lease shard.LeaseResponse
+	if err := client.Call("Coordinator.Lease", shard.Empty{}, &lease); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lease.Prefix, "/a"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	wg, _ := runWorkers(ctx, t, addr, 2, shard.PollInterval(time.Millisecond))
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	fs := newFS(t)
+	want := direct(t, fs)
+	// The root was not walked.
+	want = want[1:]
+	if got := col.sorted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := coord.Stats().Reassigned; got != 1 {
+		t.Errorf("got %v, want 1", got)
+	}
+
+	// The expired lease can no longer be used.
+	err = client.Call("Coordinator.Complete", shard.CompleteRequest{ID: lease.ID}, &shard.Empty{})
+	if err == nil || err.Error() != shard.ErrLeaseExpired.Error() {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
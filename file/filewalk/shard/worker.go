@@ -0,0 +1,261 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/errors"
+	"cloudeng.io/file/filewalk"
+)
+
+// WorkerOption represents an option accepted by NewWorker.
+type WorkerOption func(o *workerOptions)
+
+type workerOptions struct {
+	splitDepth   int
+	reportSize   int
+	pollInterval time.Duration
+	walkerOpts   []filewalk.Option
+}
+
+// SplitDepth sets the depth, relative to the leased prefix, at which
+// prefixes are returned to the Coordinator rather than being walked. A depth
+// of zero or less results in the entire sub-tree being walked. The default
+// is 2.
+func SplitDepth(n int) WorkerOption {
+	return func(o *workerOptions) {
+		o.splitDepth = n
+	}
+}
+
+// ReportSize sets the number of results that are accumulated before being
+// sent to the Coordinator. The default is 100.
+func ReportSize(n int) WorkerOption {
+	return func(o *workerOptions) {
+		o.reportSize = n
+	}
+}
+
+// PollInterval sets the time to wait before asking for more work when the
+// Coordinator has none available. The default is 100ms.
+func PollInterval(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.pollInterval = d
+	}
+}
+
+// WalkerOptions sets the options to be used for the filewalk.Walker used
+// to walk each leased prefix.
+func WalkerOptions(opts ...filewalk.Option) WorkerOption {
+	return func(o *workerOptions) {
+		o.walkerOpts = opts
+	}
+}
+
+// WorkerStats represents the counters maintained by a Worker.
+type WorkerStats struct {
+	Leases    int64 // number of leases completed.
+	Prefixes  int64 // number of prefixes walked.
+	Abandoned int64 // number of leases abandoned because they expired.
+}
+
+// Worker walks the prefixes leased to it by a Coordinator.
+type Worker struct {
+	fs   filewalk.Filesystem
+	opts workerOptions
+	sep  string
+
+	leases, prefixes, abandoned int64
+}
+
+// NewWorker returns a new Worker that will walk fs.
+func NewWorker(fs filewalk.Filesystem, opts ...WorkerOption) *Worker {
+	w := &Worker{fs: fs}
+	w.opts.splitDepth = 2
+	w.opts.reportSize = 100
+	w.opts.pollInterval = 100 * time.Millisecond
+	for _, fn := range opts {
+		fn(&w.opts)
+	}
+	w.sep = filewalk.Separator(fs)
+	return w
+}
+
+// Stats returns the current values of the counters maintained by w.
+func (w *Worker) Stats() WorkerStats {
+	return WorkerStats{
+		Leases:    atomic.LoadInt64(&w.leases),
+		Prefixes:  atomic.LoadInt64(&w.prefixes),
+		Abandoned: atomic.LoadInt64(&w.abandoned),
+	}
+}
+
+// Run obtains work from the Coordinator connected to via conn until all
+// of the work has been completed, the context is canceled or an error
+// is encountered communicating with the Coordinator. It closes conn
+// before returning.
+func (w *Worker) Run(ctx context.Context, conn io.ReadWriteCloser) error {
+	client := rpc.NewClient(conn)
+	defer client.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var resp LeaseResponse
+		if err := client.Call("Coordinator.Lease", Empty{}, &resp); err != nil {
+			return err
+		}
+		if resp.Done {
+			return nil
+		}
+		if len(resp.Prefix) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.opts.pollInterval):
+			}
+			continue
+		}
+		err := w.walk(ctx, client, resp)
+		switch {
+		case err == nil:
+			atomic.AddInt64(&w.leases, 1)
+		case errors.Is(err, ErrLeaseExpired):
+			atomic.AddInt64(&w.abandoned, 1)
+		default:
+			return err
+		}
+	}
+}
+
+// call calls the specified Coordinator method and translates lease expiry
+// errors into ErrLeaseExpired.
+func call(client *rpc.Client, method string, req interface{}) error {
+	err := client.Call("Coordinator."+method, req, &Empty{})
+	if se, ok := err.(rpc.ServerError); ok && string(se) == ErrLeaseExpired.Error() {
+		return ErrLeaseExpired
+	}
+	return err
+}
+
+// depth returns the depth of prefix relative to root.
+func (w *Worker) depth(root, prefix string) int {
+	rel := strings.TrimPrefix(strings.TrimPrefix(prefix, root), w.sep)
+	if len(rel) == 0 {
+		return 0
+	}
+	return strings.Count(rel, w.sep) + 1
+}
+
+// renew renews the lease until done is closed, calling cancel if the
+// lease cannot be renewed.
+func renew(client *rpc.Client, lease LeaseResponse, done <-chan struct{}, cancel func(), errCh chan<- error) {
+	ticker := time.NewTicker(lease.Timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			errCh <- nil
+			return
+		case <-ticker.C:
+		}
+		if err := call(client, "Renew", RenewRequest{ID: lease.ID}); err != nil {
+			cancel()
+			errCh <- err
+			return
+		}
+	}
+}
+
+// walk walks the leased prefix, reporting results as it goes, and then
+// completes the lease.
+func (w *Worker) walk(ctx context.Context, client *rpc.Client, lease LeaseResponse) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	renewErrCh := make(chan error, 1)
+	go renew(client, lease, done, cancel, renewErrCh)
+
+	var (
+		mu      sync.Mutex
+		pending []Result
+		splits  []string
+	)
+	report := func(all bool) error {
+		mu.Lock()
+		if len(pending) == 0 || (!all && len(pending) < w.opts.reportSize) {
+			mu.Unlock()
+			return nil
+		}
+		results := pending
+		pending = nil
+		mu.Unlock()
+		return call(client, "Report", ReportRequest{ID: lease.ID, Results: results})
+	}
+	add := func(r Result) error {
+		atomic.AddInt64(&w.prefixes, 1)
+		mu.Lock()
+		pending = append(pending, r)
+		mu.Unlock()
+		return report(false)
+	}
+
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		if err != nil {
+			return true, nil, add(Result{Prefix: prefix, Err: err.Error()})
+		}
+		if w.opts.splitDepth > 0 && w.depth(lease.Prefix, prefix) >= w.opts.splitDepth {
+			mu.Lock()
+			splits = append(splits, prefix)
+			mu.Unlock()
+			return true, nil, nil
+		}
+		return false, nil, nil
+	}
+	contentsFn := func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+		r := Result{Prefix: prefix, Info: *info}
+		listErrs := errors.M{}
+		for c := range ch {
+			if c.Err != nil {
+				listErrs.Append(c.Err)
+				continue
+			}
+			r.Children = append(r.Children, c.Children...)
+			r.Files = append(r.Files, c.Files...)
+		}
+		if err := listErrs.Err(); err != nil {
+			r.Err = err.Error()
+		}
+		return r.Children, add(r)
+	}
+
+	walkErr := filewalk.New(w.fs, w.opts.walkerOpts...).Walk(ctx, prefixFn, contentsFn, lease.Prefix)
+	close(done)
+	if err := <-renewErrCh; err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if walkErr != nil {
+		// Errors encountered walking the prefix are reported as part of the
+		// results, hence any error here relates to reporting those results.
+		if errors.Is(walkErr, ErrLeaseExpired) {
+			return ErrLeaseExpired
+		}
+		return walkErr
+	}
+	if err := report(true); err != nil {
+		return err
+	}
+	return call(client, "Complete", CompleteRequest{ID: lease.ID, Prefixes: splits})
+}
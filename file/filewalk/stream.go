@@ -0,0 +1,232 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// EventType identifies the type of an Event.
+type EventType int
+
+const (
+	// EventPrefix is delivered when a prefix is entered, that is, before
+	// it is listed. Its Info field is set.
+	EventPrefix EventType = iota
+	// EventContents is delivered for each batch of contents obtained
+	// when listing a prefix. Its Contents field is set.
+	EventContents
+	// EventDone is delivered when the listing of a prefix is complete.
+	EventDone
+	// EventError is delivered for errors encountered when stat'ing or
+	// listing a prefix. Its Err field is set.
+	EventError
+)
+
+// String implements stringer.
+func (et EventType) String() string {
+	switch et {
+	case EventPrefix:
+		return "prefix"
+	case EventContents:
+		return "contents"
+	case EventDone:
+		return "done"
+	case EventError:
+		return "error"
+	}
+	return fmt.Sprintf("unknown event type: %d", int(et))
+}
+
+// Event represents a single event in a Stream.
+type Event struct {
+	Type     EventType
+	Prefix   string
+	Info     *Info    // Set for EventPrefix.
+	Contents Contents // Set for EventContents.
+	Err      error    // Set for EventError.
+}
+
+// skipRequest records the consumer's decision on whether to list a prefix.
+type skipRequest struct {
+	skip     bool
+	children []Info
+}
+
+type streamEvent struct {
+	Event
+	skipCh chan skipRequest // Set for EventPrefix.
+}
+
+// Stream provides a pull-style alternative to Walk's callbacks. Events
+// for any given prefix are delivered in the order EventPrefix, any number
+// of EventContents and then EventDone, or EventPrefix followed by an
+// EventError if the prefix could not be stat'ed. The events for different
+// prefixes are interleaved unless an ordered traversal is requested via
+// the Order option. A Stream is typically used as follows:
+//
+//	stream := walker.Stream(ctx, roots...)
+//	defer stream.Close()
+//	for stream.Next() {
+//	    ev := stream.Event()
+//	    ...
+//	}
+//	if err := stream.Err(); err != nil {
+//	    ...
+//	}
+type Stream struct {
+	cancel  func()
+	eventCh chan streamEvent
+	errCh   chan error
+	current streamEvent
+	skip    skipRequest
+	err     error
+	done    bool
+}
+
+// Stream starts a walk of the specified roots and returns a Stream from
+// which the events generated by the walk may be read. The walk proceeds
+// only as fast as events are read from the Stream. Errors encountered
+// when stat'ing or listing prefixes are delivered as events and are not
+// returned by Err.
+func (w *Walker) Stream(ctx context.Context, roots ...string) *Stream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Stream{
+		cancel:  cancel,
+		eventCh: make(chan streamEvent),
+		errCh:   make(chan error, 1),
+	}
+	// Walk may return when canceled before all of the goroutines that
+	// call prefixFn and contentsFn have finished. closed, guarded by mu,
+	// ensures that they never send on eventCh once it has been closed.
+	var (
+		mu     sync.RWMutex
+		closed bool
+	)
+	send := func(ev streamEvent) bool {
+		mu.RLock()
+		defer mu.RUnlock()
+		if closed {
+			return false
+		}
+		select {
+		case s.eventCh <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	prefixFn := func(ctx context.Context, prefix string, info *Info, err error) (bool, []Info, error) {
+		ev := streamEvent{Event: Event{Type: EventPrefix, Prefix: prefix, Info: info}, skipCh: make(chan skipRequest, 1)}
+		if !send(ev) {
+			return true, nil, nil
+		}
+		var skip skipRequest
+		select {
+		case skip = <-ev.skipCh:
+		case <-ctx.Done():
+			return true, nil, nil
+		}
+		if err != nil {
+			send(streamEvent{Event: Event{Type: EventError, Prefix: prefix, Err: err}})
+			return true, nil, nil
+		}
+		if len(skip.children) > 0 {
+			return false, skip.children, nil
+		}
+		return skip.skip, nil, nil
+	}
+	contentsFn := func(ctx context.Context, prefix string, info *Info, ch <-chan Contents) ([]Info, error) {
+		children := []Info{}
+		for c := range ch {
+			ev := streamEvent{Event: Event{Type: EventContents, Prefix: prefix, Contents: c}}
+			if c.Err != nil {
+				ev.Type, ev.Err = EventError, c.Err
+			}
+			if !send(ev) {
+				return nil, ctx.Err()
+			}
+			children = append(children, c.Children...)
+		}
+		send(streamEvent{Event: Event{Type: EventDone, Prefix: prefix}})
+		return children, nil
+	}
+	go func() {
+		s.errCh <- w.Walk(ctx, prefixFn, contentsFn, roots...)
+		// Cancel the walk so that any goroutines that are blocked in send
+		// return and release mu.
+		cancel()
+		mu.Lock()
+		closed = true
+		close(s.eventCh)
+		mu.Unlock()
+	}()
+	return s
+}
+
+// Next advances the stream to the next event, which is available via
+// Event. It returns false when there are no more events, either because
+// the walk is complete or because it was canceled.
+func (s *Stream) Next() bool {
+	if s.done {
+		return false
+	}
+	if s.current.skipCh != nil {
+		s.current.skipCh <- s.skip
+	}
+	s.current, s.skip = streamEvent{}, skipRequest{}
+	ev, ok := <-s.eventCh
+	if !ok {
+		s.done = true
+		s.err = <-s.errCh
+		s.cancel()
+		return false
+	}
+	s.current = ev
+	return true
+}
+
+// Event returns the current event.
+func (s *Stream) Event() Event {
+	return s.current.Event
+}
+
+// Skip requests that the prefix for the current event, which must be
+// an EventPrefix, not be listed and hence that no EventContents or
+// EventDone events will be delivered for it. It provides the same
+// functionality as returning true for stop from a PrefixFunc.
+func (s *Stream) Skip() {
+	s.SkipWithChildren(nil)
+}
+
+// SkipWithChildren is like Skip except that the supplied children, if any,
+// are walked in place of those that would have been obtained by listing
+// the prefix. It provides the same functionality as returning a list of
+// children from a PrefixFunc and allows for children obtained from
+// elsewhere, such as a database, to be used.
+func (s *Stream) SkipWithChildren(children []Info) {
+	if s.current.Type == EventPrefix {
+		s.skip = skipRequest{skip: true, children: children}
+	}
+}
+
+// Err returns any error encountered by the walk, such as the walk being
+// canceled.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close cancels the walk, if it is still in progress, and waits for it
+// to finish. It need not be called if Next has returned false.
+func (s *Stream) Close() {
+	if s.done {
+		return
+	}
+	s.cancel()
+	for s.Next() {
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/memfs"
+)
+
+func TestStream(t *testing.T) {
+	fs, err := memfs.Parse(checkpointTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetError(memfs.List, "/c0", os.ErrPermission)
+	fs.SetError(memfs.Stat, "/b0/b0.1", os.ErrNotExist)
+	wk := filewalk.New(fs, filewalk.Order(filewalk.DepthFirst))
+	stream := wk.Stream(context.Background(), "/")
+	defer stream.Close()
+	var events []string
+	for stream.Next() {
+		ev := stream.Event()
+		line := ev.Type.String() + " " + ev.Prefix
+		switch ev.Type {
+		case filewalk.EventPrefix:
+			if ev.Prefix == "/a0/a0.0" {
+				stream.Skip()
+			}
+		case filewalk.EventContents:
+			for _, f := range ev.Contents.Files {
+				line += " " + f.Name
+			}
+		case filewalk.EventError:
+			switch {
+			case fs.IsPermissionError(ev.Err):
+				line += " permission"
+			case fs.IsNotExist(ev.Err):
+				line += " not-exist"
+			}
+		}
+		events = append(events, line)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := events, []string{
+		"prefix /", "contents /", "done /",
+		"prefix /a0", "contents /a0 f0", "done /a0",
+		"prefix /a0/a0.0",
+		"prefix /a0/a0.1", "contents /a0/a0.1 f3", "done /a0/a0.1",
+		"prefix /b0", "contents /b0", "done /b0",
+		"prefix /b0/b0.0", "contents /b0/b0.0 f4", "done /b0/b0.0",
+		"prefix /b0/b0.1", "error /b0/b0.1 not-exist",
+		"prefix /c0", "error /c0 permission", "done /c0",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if stream.Next() {
+		t.Errorf("Next should continue to return false")
+	}
+}
+
+func TestStreamSkipWithChildren(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(checkpointTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := fs.Stat(ctx, "/a0/a0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wk := filewalk.New(fs, filewalk.Order(filewalk.DepthFirst))
+	stream := wk.Stream(ctx, "/a0")
+	defer stream.Close()
+	var events []string
+	for stream.Next() {
+		ev := stream.Event()
+		if ev.Type == filewalk.EventPrefix && ev.Prefix == "/a0" {
+			// Only /a0/a0.1 is walked and /a0 is not listed.
+			stream.SkipWithChildren([]filewalk.Info{child})
+		}
+		events = append(events, ev.Type.String()+" "+ev.Prefix)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := events, []string{
+		"prefix /a0",
+		"prefix /a0/a0.1", "contents /a0/a0.1", "done /a0/a0.1",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	fs, err := memfs.Parse(checkpointTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	wk := filewalk.New(fs, filewalk.Concurrency(4))
+	stream := wk.Stream(context.Background(), "/")
+	for i := 0; i < 3 && stream.Next(); i++ {
+	}
+	stream.Close()
+	if err := stream.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+	// Allow for any goroutines that are exiting.
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > before {
+		t.Errorf("goroutine leak: %v > %v", got, before)
+	}
+}
+
+func TestStreamCloseDuringWalk(t *testing.T) {
+	var tree strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&tree, "d%v/\n", i)
+		for j := 0; j < 10; j++ {
+			fmt.Fprintf(&tree, "  d%v/\n    f 1\n", j)
+		}
+	}
+	fs, err := memfs.Parse(tree.String(), memfs.ListBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Close the stream at various points whilst many prefixes are being
+	// walked concurrently; the walk must wind down without attempting to
+	// deliver events once the stream has been closed.
+	for i := 0; i < 100; i++ {
+		wk := filewalk.New(fs, filewalk.Concurrency(8))
+		stream := wk.Stream(context.Background(), "/")
+		for n := 0; n < i && stream.Next(); n++ {
+		}
+		stream.Close()
+		if stream.Next() {
+			t.Errorf("%v: Next returned true after Close", i)
+		}
+	}
+}
@@ -18,3 +18,11 @@ func getUserAndGroupID(sys interface{}) (string, string) {
 	}
 	return strconv.Itoa(int(si.Uid)), strconv.Itoa(int(si.Gid))
 }
+
+func getDeviceAndInode(sys interface{}) (uint64, uint64, bool) {
+	si, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(si.Dev), uint64(si.Ino), true
+}
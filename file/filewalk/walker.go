@@ -17,7 +17,9 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloudeng.io/errors"
@@ -43,6 +45,9 @@ type Walker struct {
 	contentsFn ContentsFunc
 	prefixFn   PrefixFunc
 	errs       *errors.M
+	frontier   *frontier
+	progress   *progress
+	prefetcher *prefetcher
 }
 
 // Option represents options accepted by Walker.
@@ -52,6 +57,18 @@ type options struct {
 	concurrency int
 	scanSize    int
 	chanSize    int
+	followLinks bool
+	sameDevice  bool
+	mountPoints map[string]bool
+
+	checkpointer       Checkpointer
+	checkpointInterval time.Duration
+	resume             Checkpointer
+
+	progressFn       func(ProgressSnapshot)
+	progressInterval time.Duration
+
+	order Traversal
 }
 
 // Concurreny can be used to change the degree of concurrency used. The
@@ -70,6 +87,71 @@ func ChanSize(n int) Option {
 	}
 }
 
+// FollowLinks can be used to request that symbolic links to prefixes be
+// followed. It has no effect unless the Filesystem being walked implements
+// LinkFollower. Links are followed by presenting them to ContentsFunc as
+// children, rather than files, with both ModePrefix and ModeLink set in
+// their Info. The Info passed to PrefixFunc for a followed link will
+// similarly have both ModePrefix and ModeLink set and will otherwise
+// describe the link's target. Cycles are detected by tracking the
+// device and inode numbers, as returned by LinkFollower.FileID, of every
+// prefix between the root and the current prefix; a prefix that is
+// already one of its own ancestors is passed to PrefixFunc with an error
+// that wraps ErrLinkCycle and is not traversed.
+func FollowLinks(follow bool) Option {
+	return func(o *options) {
+		o.followLinks = follow
+	}
+}
+
+// SameDevice can be used to request that the walk not descend into
+// prefixes that are on a different device to the root that they were
+// reached from, as per du -x or find -xdev. Such prefixes are silently
+// skipped, that is, PrefixFunc is not called for them. Prefixes that
+// appear in mountPoints are traversed regardless, with the prefixes
+// below them being compared against the mount point's device. Device
+// ids are obtained from Info.Device and no checks are made for roots
+// whose device is reported as zero.
+func SameDevice(mountPoints ...string) Option {
+	return func(o *options) {
+		o.sameDevice = true
+		if o.mountPoints == nil {
+			o.mountPoints = map[string]bool{}
+		}
+		for _, mp := range mountPoints {
+			o.mountPoints[mp] = true
+		}
+	}
+}
+
+// CheckpointTo requests that the frontier of the walk, that is, the
+// prefixes that are pending or are being listed, be saved to cp at
+// the specified interval, which defaults to one minute, and when the
+// walk completes or is canceled.
+func CheckpointTo(cp Checkpointer, interval time.Duration) Option {
+	return func(o *options) {
+		o.checkpointer = cp
+		o.checkpointInterval = interval
+	}
+}
+
+// ResumeFrom requests that a walk be resumed from the checkpoint, if any,
+// stored in cp. Only the prefixes that were pending or being listed when
+// the checkpoint was taken will be traversed, with the latter being listed
+// again; prefixes that had already been listed are skipped. The walk
+// starts from the roots supplied to Walk if there is no checkpoint or if
+// the checkpoint records a completed walk, and an error is returned if
+// the checkpoint was taken for a walk of different roots. The state that
+// is accumulated as a walk descends, that is, the ancestors used to detect
+// link cycles and the device used by SameDevice, is saved in checkpoints
+// and restored for each of the prefixes that a walk is resumed from.
+// ResumeFrom is typically used in conjunction with CheckpointTo.
+func ResumeFrom(cp Checkpointer) Option {
+	return func(o *options) {
+		o.resume = cp
+	}
+}
+
 // New creates a new Walker instance.
 func New(filesystem Filesystem, opts ...Option) *Walker {
 	w := &Walker{fs: filesystem, errs: &errors.M{}}
@@ -78,6 +160,13 @@ func New(filesystem Filesystem, opts ...Option) *Walker {
 	for _, fn := range opts {
 		fn(&w.opts)
 	}
+	// Normalize the mount points so that they can be compared with the
+	// paths created by the walk.
+	mountPoints := map[string]bool{}
+	for mp := range w.opts.mountPoints {
+		mountPoints[filesystem.Join(mp)] = true
+	}
+	w.opts.mountPoints = mountPoints
 	return w
 }
 
@@ -100,13 +189,28 @@ func (fm FileMode) String() string {
 // Info represents the information that can be retrieved for a single
 // file or prefix.
 type Info struct {
-	Name    string      // base name of the file
-	UserID  string      // user id as returned by the underlying system
-	GroupID string      // group id as returned by the underlying system
-	Size    int64       // length in bytes
-	ModTime time.Time   // modification time
-	Mode    FileMode    // permissions, directory or link.
-	sys     interface{} // underlying data source (can return nil)
+	Name     string      // base name of the file
+	UserID   string      // user id as returned by the underlying system
+	GroupID  string      // group id as returned by the underlying system
+	Size     int64       // length in bytes
+	ModTime  time.Time   // modification time
+	Mode     FileMode    // permissions, directory or link.
+	Device   uint64      // device id as returned by the underlying system, zero if unavailable
+	Metadata *Metadata   // extended POSIX metadata, nil if unavailable
+	sys      interface{} // underlying data source (can return nil)
+}
+
+// NewInfo creates a new instance of Info. It is intended for use by
+// implementations of Filesystem that need to make their underlying
+// data source available via Sys.
+func NewInfo(name string, size int64, mode FileMode, modTime time.Time, sys interface{}) Info {
+	return Info{
+		Name:    name,
+		Size:    size,
+		ModTime: modTime,
+		Mode:    mode,
+		sys:     sys,
+	}
 }
 
 // Sys returns the underlying, if available, data source.
@@ -150,6 +254,25 @@ type Filesystem interface {
 	IsNotExist(err error) bool
 }
 
+// Separator returns the separator used by fs to join the components of a
+// path, as inferred from the result of fs.Join("a", "b").
+func Separator(fs Filesystem) string {
+	return strings.TrimSuffix(strings.TrimPrefix(fs.Join("a", "b"), "a"), "b")
+}
+
+// LinkFollower may be implemented by a Filesystem that supports symbolic
+// links in order to allow them to be followed; see the FollowLinks option.
+type LinkFollower interface {
+	// StatFollow is like Stat except that if path is a symbolic link
+	// the returned Info describes the link's target.
+	StatFollow(ctx context.Context, path string) (Info, error)
+
+	// FileID returns the device and inode numbers of the file or prefix
+	// described by info, as returned by Stat, StatFollow or List. It
+	// returns false if they are not available.
+	FileID(info Info) (device, inode uint64, ok bool)
+}
+
 // Error implements error and provides additional detail on the error
 // encountered.
 type Error struct {
@@ -163,6 +286,11 @@ func (e *Error) Error() string {
 	return "[" + e.Path + ": " + e.Op + "] " + e.Err.Error()
 }
 
+// Unwrap implements errors.Unwrap.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
 // recordError will record the specified error if it is not nil; ie.
 // its safe to call it with a nil error.
 func (w *Walker) recordError(path, op string, err error) error {
@@ -178,11 +306,12 @@ func (w *Walker) listLevel(ctx context.Context, idx string, path string, info *I
 	ch := make(chan Contents, w.opts.concurrency)
 
 	go func(path string) {
-		w.fs.List(ctx, path, ch)
+		w.list(ctx, path, ch)
 		close(ch)
 	}(path)
 
 	children, err := w.contentsFn(ctx, path, info, ch)
+	atomic.AddInt64(&w.progress.listed, 1)
 
 	if err != nil {
 		w.recordError(path, "fileFunc", err)
@@ -195,13 +324,38 @@ func (w *Walker) listLevel(ctx context.Context, idx string, path string, info *I
 	case <-ch:
 	}
 
-	sort.Slice(children, func(i, j int) bool {
-		if children[i].Name == children[j].Name {
-			return children[i].Size >= children[j].Size
+	sortInfos(children)
+	return children
+}
+
+// list sends the contents of path to ch, using the prefetched contents
+// if an ordered traversal is being performed.
+func (w *Walker) list(ctx context.Context, path string, ch chan<- Contents) {
+	w.countingList(func(ch chan<- Contents) {
+		if w.listPrefetched(path, ch) {
+			return
 		}
-		return children[i].Name < children[j].Name
+		w.listContents(ctx, path, ch)
+	}, ch)
+}
+
+// listContents sends the contents of path to ch, following links if
+// requested.
+func (w *Walker) listContents(ctx context.Context, path string, ch chan<- Contents) {
+	if lf, ok := w.linkFollower(); ok {
+		w.listFollowingLinks(ctx, lf, path, ch)
+		return
+	}
+	w.fs.List(ctx, path, ch)
+}
+
+func sortInfos(infos []Info) {
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name == infos[j].Name {
+			return infos[i].Size >= infos[j].Size
+		}
+		return infos[i].Name < infos[j].Name
 	})
-	return children
 }
 
 type stringer string
@@ -221,14 +375,15 @@ type ContentsFunc func(ctx context.Context, prefix string, info *Info, ch <-chan
 // If stop is true then traversal stops at this point, however if a list
 // of children is returned, they will be traversed directly rather than
 // obtaining the children from the filesystem. This allows for both
-// exclusions and incremental processing in conjunction with a database t
-// be implemented.
+// exclusions and incremental processing in conjunction with a database to
+// be implemented; see cloudeng.io/file/filewalk/incremental for the latter.
 type PrefixFunc func(ctx context.Context, prefix string, info *Info, err error) (stop bool, children []Info, returnErr error)
 
 // Walk traverses the hierarchies specified by each of the roots calling
 // prefixFn and contentsFn as it goes. prefixFn will always be called
 // before contentsFn for the same prefix, but no other ordering guarantees
-// are provided.
+// are provided unless an ordered traversal is requested via the Order
+// option.
 func (w *Walker) Walk(ctx context.Context, prefixFn PrefixFunc, contentsFn ContentsFunc, roots ...string) error {
 	rootCtx := ctx
 	listers, ctx := errgroup.WithContext(rootCtx)
@@ -244,6 +399,17 @@ func (w *Walker) Walk(ctx context.Context, prefixFn PrefixFunc, contentsFn Conte
 	w.prefixFn = prefixFn
 	w.contentsFn = contentsFn
 
+	w.frontier = nil
+	starts, err := w.startingPoints(rootCtx, roots)
+	if err != nil {
+		return err
+	}
+	w.progress = &progress{start: time.Now(), queued: int64(len(starts))}
+	w.prefetcher = nil
+	if w.opts.order != Concurrent {
+		w.prefetcher = newPrefetcher(w.opts.concurrency)
+	}
+
 	// create and prime the concurrency limiter for walking directories.
 	walkerLimitCh := make(chan string, w.opts.concurrency*2)
 	for i := 0; i < cap(walkerLimitCh); i++ {
@@ -253,10 +419,34 @@ func (w *Walker) Walk(ctx context.Context, prefixFn PrefixFunc, contentsFn Conte
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	for _, root := range roots {
-		root := root
+	doneCh := make(chan struct{})
+	checkpointErrCh := make(chan error, 1)
+	go func() {
+		checkpointErrCh <- w.checkpointer(rootCtx, doneCh)
+	}()
+	progressDoneCh := make(chan struct{})
+	go func() {
+		w.reportProgress(doneCh)
+		close(progressDoneCh)
+	}()
+
+	if w.prefetcher != nil {
+		ordered := starts
+		walkers.Go(func() error {
+			w.walkOrdered(ctx, ordered)
+			return nil
+		})
+		starts = nil
+	}
+
+	for _, start := range starts {
+		start := start
 		walkers.Go(func() error {
-			w.walker(ctx, <-walkerLimitCh, root, walkerLimitCh)
+			// Return the token so that walks resumed from a large
+			// number of prefixes do not exhaust the limiter.
+			idx := <-walkerLimitCh
+			w.walker(ctx, idx, start.path, start.state, walkerLimitCh)
+			walkerLimitCh <- idx
 			return nil
 		})
 	}
@@ -282,10 +472,13 @@ func (w *Walker) Walk(ctx context.Context, prefixFn PrefixFunc, contentsFn Conte
 		w.errs.Append(rootCtx.Err())
 	case <-waitCh:
 	}
+	close(doneCh)
+	w.errs.Append(<-checkpointErrCh)
+	<-progressDoneCh
 	return w.errs.Err()
 }
 
-func (w *Walker) walkChildren(ctx context.Context, path string, children []Info, limitCh chan string) {
+func (w *Walker) walkChildren(ctx context.Context, path string, children []Info, state walkState, limitCh chan string) {
 	var wg sync.WaitGroup
 	wg.Add(len(children))
 	for _, child := range children {
@@ -297,12 +490,12 @@ func (w *Walker) walkChildren(ctx context.Context, path string, children []Info,
 			return
 		default:
 			// no concurreny is available fallback to sync.
-			w.walker(ctx, idx, w.fs.Join(path, child.Name), limitCh)
+			w.walker(ctx, idx, w.fs.Join(path, child.Name), state, limitCh)
 			wg.Done()
 			continue
 		}
 		go func() {
-			w.walker(ctx, idx, w.fs.Join(path, child.Name), limitCh)
+			w.walker(ctx, idx, w.fs.Join(path, child.Name), state, limitCh)
 			wg.Done()
 			limitCh <- idx
 		}()
@@ -311,25 +504,88 @@ func (w *Walker) walkChildren(ctx context.Context, path string, children []Info,
 
 }
 
-func (w *Walker) walker(ctx context.Context, idx string, path string, limitCh chan string) {
+// walkState represents the state that is passed from a prefix to its
+// children.
+type walkState struct {
+	parents *ancestor // used for detecting link cycles.
+	device  uint64    // device of the root or most recent mount point.
+	started bool      // false for roots.
+}
+
+// crossesDevice returns true if info is on a different device to the one
+// being walked and path is not an allowed mount point. It also returns the
+// device to be used for the children of path.
+func (w *Walker) crossesDevice(path string, info Info, state walkState) (bool, uint64) {
+	if !state.started || w.opts.mountPoints[path] {
+		return false, info.Device
+	}
+	if state.device == 0 || info.Device == state.device {
+		return false, state.device
+	}
+	return true, state.device
+}
+
+func (w *Walker) walker(ctx context.Context, idx string, path string, state walkState, limitCh chan string) {
 	select {
 	default:
 	case <-ctx.Done():
 		return
 	}
+	children, state := w.step(ctx, idx, path, state)
+	if len(children) > 0 {
+		w.walkChildren(ctx, path, children, state, limitCh)
+	}
+}
+
+// step visits path, recording its progress, and returns the children
+// to be walked and the state to be passed to them.
+func (w *Walker) step(ctx context.Context, idx string, path string, state walkState) ([]Info, walkState) {
+	w.frontier.start(path, state)
+	atomic.AddInt64(&w.progress.queued, -1)
+	atomic.AddInt64(&w.progress.visiting, 1)
+	children, state := w.visit(ctx, idx, path, state)
+	atomic.AddInt64(&w.progress.queued, int64(len(children)))
+	atomic.AddInt64(&w.progress.visiting, -1)
+	if w.frontier != nil && ctx.Err() == nil {
+		// Prefixes whose listing was interrupted remain in progress.
+		paths := make([]string, len(children))
+		for i, child := range children {
+			paths[i] = w.fs.Join(path, child.Name)
+		}
+		w.frontier.done(path, paths, state)
+	}
+	return children, state
+}
+
+// visit calls PrefixFunc and, if required, lists path. It returns the
+// children to be walked and the state to be passed to them.
+func (w *Walker) visit(ctx context.Context, idx string, path string, state walkState) ([]Info, walkState) {
 	walkingVar.Set(idx, stringer(path))
-	info, err := w.fs.Stat(ctx, path)
+	info, err := w.stat(ctx, path)
+	atomic.AddInt64(&w.progress.stated, 1)
+	w.progress.error(w.fs, err)
+	if lf, ok := w.linkFollower(); ok && err == nil {
+		info, state.parents, err = w.followLink(ctx, lf, path, info, state.parents)
+		if err != nil {
+			_, _, err = w.prefixFn(ctx, path, &info, err)
+			w.recordError(path, "stat", err)
+			return nil, state
+		}
+	}
+	if w.opts.sameDevice && err == nil {
+		var crosses bool
+		if crosses, state.device = w.crossesDevice(path, info, state); crosses {
+			return nil, state
+		}
+	}
+	state.started = true
 	stop, children, err := w.prefixFn(ctx, path, &info, err)
 	w.recordError(path, "stat", err)
 	if stop {
-		return
-	}
-	if len(children) > 0 {
-		w.walkChildren(ctx, path, children, limitCh)
-		return
+		return nil, state
 	}
-	children = w.listLevel(ctx, idx, path, &info)
 	if len(children) > 0 {
-		w.walkChildren(ctx, path, children, limitCh)
+		return children, state
 	}
+	return w.listLevel(ctx, idx, path, &info), state
 }
@@ -7,6 +7,7 @@ package filewalk_test
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -16,7 +17,10 @@ import (
 	"testing"
 	"time"
 
+	"cloudeng.io/errors"
 	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/cache"
+	"cloudeng.io/file/filewalk/memfs"
 )
 
 type logger struct {
@@ -344,3 +348,165 @@ func TestCancel(t *testing.T) {
 		t.Fatalf("context was not canceld")
 	}
 }
+
+func TestFollowLinks(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "filewalk-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	j := filepath.Join
+	errs := errors.M{}
+	errs.Append(os.Mkdir(j(tmpDir, "d"), 0700))
+	errs.Append(ioutil.WriteFile(j(tmpDir, "d", "f"), []byte{'1', '2', '3'}, 0600))
+	errs.Append(os.Symlink("..", j(tmpDir, "d", "up")))
+	errs.Append(os.Symlink("d", j(tmpDir, "ld")))
+	errs.Append(os.Symlink(j("d", "f"), j(tmpDir, "lf")))
+	errs.Append(os.Symlink("nowhere", j(tmpDir, "dangling")))
+	if err := errs.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var lines []string
+	appendLine := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	walk := func(wk *filewalk.Walker) {
+		lines = nil
+		err := wk.Walk(ctx,
+			func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+				prefix = strings.TrimPrefix(prefix, tmpDir)
+				if err != nil {
+					appendLine("%v: cycle: %v", prefix, errors.Is(err, filewalk.ErrLinkCycle))
+					return true, nil, nil
+				}
+				appendLine("%v* link: %v", prefix, info.IsLink())
+				return false, nil, nil
+			},
+			func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+				prefix = strings.TrimPrefix(prefix, tmpDir)
+				children := []filewalk.Info{}
+				for c := range ch {
+					for _, f := range c.Files {
+						appendLine("%v link: %v", j(prefix, f.Name), f.IsLink())
+					}
+					children = append(children, c.Children...)
+				}
+				return children, nil
+			},
+			tmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(lines)
+	}
+
+	walk(filewalk.New(filewalk.LocalFilesystem(10), filewalk.Concurrency(2)))
+	if got, want := strings.Join(lines, "\n"), `* link: false
+/d* link: false
+/d/f link: false
+/d/up link: true
+dangling link: true
+ld link: true
+lf link: true`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	followed := `* link: false
+/d* link: false
+/d/f link: false
+/d/up: cycle: true
+/ld* link: true
+/ld/f link: false
+/ld/up: cycle: true
+dangling link: true
+lf link: true`
+	walk(filewalk.New(filewalk.LocalFilesystem(10), filewalk.Concurrency(2), filewalk.FollowLinks(true)))
+	if got, want := strings.Join(lines, "\n"), followed; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The contents returned by the cache must not be modified when links
+	// are followed and hence repeated walks must produce the same results.
+	cfs := cache.New(filewalk.LocalFilesystem(10))
+	for i := 0; i < 2; i++ {
+		walk(filewalk.New(cfs, filewalk.Concurrency(2), filewalk.FollowLinks(true)))
+		if got, want := strings.Join(lines, "\n"), followed; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSameDevice(t *testing.T) {
+	ctx := context.Background()
+	fs, err := memfs.Parse(`
+r/ dev=1
+  f0 1
+  d/ dev=1
+    f1 1
+  proc/ dev=2
+    f2 1
+  mnt/ dev=3
+    f3 1
+    sub/ dev=3
+      f4 1
+    other/ dev=4
+      f5 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walk := func(opts ...filewalk.Option) string {
+		var mu sync.Mutex
+		var lines []string
+		wk := filewalk.New(fs, opts...)
+		err := wk.Walk(ctx,
+			func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				lines = append(lines, prefix+"*")
+				return false, nil, err
+			},
+			func(ctx context.Context, prefix string, info *filewalk.Info, ch <-chan filewalk.Contents) ([]filewalk.Info, error) {
+				children := []filewalk.Info{}
+				for c := range ch {
+					mu.Lock()
+					for _, f := range c.Files {
+						lines = append(lines, fs.Join(prefix, f.Name))
+					}
+					mu.Unlock()
+					children = append(children, c.Children...)
+				}
+				return children, nil
+			},
+			"/r")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, " ")
+	}
+
+	if got, want := walk(), "/r* /r/d* /r/d/f1 /r/f0 /r/mnt* /r/mnt/f3 /r/mnt/other* /r/mnt/other/f5 /r/mnt/sub* /r/mnt/sub/f4 /r/proc* /r/proc/f2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := walk(filewalk.SameDevice()), "/r* /r/d* /r/d/f1 /r/f0"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := walk(filewalk.SameDevice("/r/mnt/")), "/r* /r/d* /r/d/f1 /r/f0 /r/mnt* /r/mnt/f3 /r/mnt/sub* /r/mnt/sub/f4"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	if got, want := filewalk.Separator(filewalk.LocalFilesystem(1)), string(filepath.Separator); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := filewalk.Separator(memfs.New()), "/"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package watch provides support for keeping a filewalk.Database up to date
+// with changes to a local filesystem without repeatedly walking it in its
+// entirety. A Watcher performs an initial, incremental, walk of its roots,
+// establishing an inotify watch on every prefix encountered, and thereafter
+// re-lists only those prefixes for which events are received. Events are
+// coalesced per prefix over a short interval so that a burst of changes to
+// the same prefix results in it being listed only once.
+//
+// Should the system limit on the number of watches be reached, the prefixes
+// that could not be watched are instead periodically rescanned. Similarly,
+// should the kernel's event queue overflow, all of the roots are rescanned.
+// Rescans list every prefix rather than being incremental, see
+// incremental.Updater, since changes to existing files are not reflected
+// in the modification times of the prefixes that contain them.
+//
+// Watcher is only supported on Linux.
+package watch
+
+import (
+	"context"
+	"time"
+)
+
+// Option represents an option accepted by New.
+type Option func(o *options)
+
+type options struct {
+	latency        time.Duration
+	rescanInterval time.Duration
+	maxWatches     int
+	updated        UpdateFunc
+	errors         ErrorFunc
+}
+
+// Latency sets the interval over which events are coalesced before the
+// affected prefixes are re-listed. The default is one second.
+func Latency(d time.Duration) Option {
+	return func(o *options) {
+		o.latency = d
+	}
+}
+
+// RescanInterval sets the interval at which prefixes that could not be
+// watched are rescanned. The default is 10 minutes.
+func RescanInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.rescanInterval = d
+	}
+}
+
+// MaxWatches limits the number of watches that will be established, in
+// addition to the system-wide limit. It is primarily intended for testing
+// the fallback to periodic rescans.
+func MaxWatches(n int) Option {
+	return func(o *options) {
+		o.maxWatches = n
+	}
+}
+
+// UpdateFunc is called whenever the database has been updated for prefix,
+// deleted is true if the prefix has been removed.
+type UpdateFunc func(ctx context.Context, prefix string, deleted bool)
+
+// Updated sets the function to be called for every update made to the
+// database, including those made by the initial walk.
+func Updated(fn UpdateFunc) Option {
+	return func(o *options) {
+		o.updated = fn
+	}
+}
+
+// ErrorFunc is called for errors encountered whilst walking, listing or
+// updating the database. Such errors do not terminate the Watcher.
+type ErrorFunc func(ctx context.Context, err error)
+
+// Errors sets the function to be called for errors encountered by the
+// Watcher; by default they are ignored.
+func Errors(fn ErrorFunc) Option {
+	return func(o *options) {
+		o.errors = fn
+	}
+}
+
+// Stats records the activity of a Watcher.
+type Stats struct {
+	Events    int64 // Number of events received.
+	Refreshed int64 // Number of prefixes re-listed in response to events.
+	Deleted   int64 // Number of prefixes deleted in response to events.
+	Rescans   int64 // Number of rescans performed.
+	Watched   int64 // Number of prefixes currently being watched.
+	Unwatched int64 // Number of prefixes that could not be watched.
+	Overflows int64 // Number of times the kernel's event queue overflowed.
+}
@@ -0,0 +1,387 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package watch
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/incremental"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// Watcher uses inotify to keep a filewalk.Database up to date with
+// changes to a local filesystem.
+type Watcher struct {
+	opts    options
+	fs      filewalk.Filesystem
+	db      filewalk.Database
+	updater *incremental.Updater
+	roots   []string
+
+	fd   int
+	file *os.File
+
+	mu        sync.Mutex
+	byWd      map[int32]string
+	byPath    map[string]int32
+	unwatched map[string]bool
+
+	events, refreshed, deleted, rescans, overflows int64
+}
+
+// New returns a new Watcher that will keep db up to date with fs, which
+// must be a local filesystem, such as that returned by
+// filewalk.LocalFilesystem.
+func New(fs filewalk.Filesystem, db filewalk.Database, opts ...Option) *Watcher {
+	w := &Watcher{
+		fs:        fs,
+		db:        db,
+		byWd:      map[int32]string{},
+		byPath:    map[string]int32{},
+		unwatched: map[string]bool{},
+	}
+	w.opts.latency = time.Second
+	w.opts.rescanInterval = 10 * time.Minute
+	for _, fn := range opts {
+		fn(&w.opts)
+	}
+	w.updater = incremental.New(fs, db, incremental.Visit(w.visit))
+	return w
+}
+
+// Stats returns the current statistics for the Watcher.
+func (w *Watcher) Stats() Stats {
+	w.mu.Lock()
+	watched, unwatched := len(w.byPath), len(w.unwatched)
+	w.mu.Unlock()
+	return Stats{
+		Events:    atomic.LoadInt64(&w.events),
+		Refreshed: atomic.LoadInt64(&w.refreshed),
+		Deleted:   atomic.LoadInt64(&w.deleted),
+		Rescans:   atomic.LoadInt64(&w.rescans),
+		Overflows: atomic.LoadInt64(&w.overflows),
+		Watched:   int64(watched),
+		Unwatched: int64(unwatched),
+	}
+}
+
+type event struct {
+	wd   int32
+	mask uint32
+}
+
+// Run performs an initial walk of the specified roots, updating the
+// database as it does so, and then processes events until the context is
+// canceled. It returns an error only if inotify cannot be used or the
+// context is canceled.
+func (w *Watcher) Run(ctx context.Context, roots ...string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// Using a non-blocking descriptor allows Close to interrupt Read.
+	w.fd, w.file = fd, os.NewFile(uintptr(fd), "inotify")
+	defer w.file.Close()
+	w.roots = roots
+	w.walk(ctx, false, roots...)
+
+	done := make(chan struct{})
+	defer close(done)
+	evCh := make(chan []event, 100)
+	errCh := make(chan error, 1)
+	go w.read(done, evCh, errCh)
+
+	rescan := time.NewTicker(w.opts.rescanInterval)
+	defer rescan.Stop()
+	dirty := map[string]bool{}
+	var flushCh <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		case evs := <-evCh:
+			overflow := false
+			for _, ev := range evs {
+				atomic.AddInt64(&w.events, 1)
+				overflow = w.handle(ev, dirty) || overflow
+			}
+			if overflow {
+				// Events have been lost and hence the roots must be
+				// rescanned, which subsumes any pending prefixes.
+				atomic.AddInt64(&w.overflows, 1)
+				atomic.AddInt64(&w.rescans, 1)
+				dirty = map[string]bool{}
+				w.walk(ctx, true, w.roots...)
+				continue
+			}
+			if flushCh == nil && len(dirty) > 0 {
+				flushCh = time.After(w.opts.latency)
+			}
+		case <-flushCh:
+			pending := dirty
+			dirty, flushCh = map[string]bool{}, nil
+			w.flush(ctx, pending)
+		case <-rescan.C:
+			w.rescan(ctx)
+		}
+	}
+}
+
+func (w *Watcher) read(done <-chan struct{}, evCh chan<- []event, errCh chan<- error) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		select {
+		case evCh <- parse(buf[:n]):
+		case <-done:
+			return
+		}
+	}
+}
+
+func parse(buf []byte) []event {
+	var evs []event
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		// The name of the entry within the prefix, if any, follows
+		// the event but is not needed since the prefix is re-listed.
+		end := syscall.SizeofInotifyEvent + int(raw.Len)
+		if end > len(buf) {
+			break
+		}
+		evs = append(evs, event{wd: raw.Wd, mask: raw.Mask})
+		buf = buf[end:]
+	}
+	return evs
+}
+
+// handle records the prefix affected by ev as being dirty and returns
+// true if the event queue has overflowed.
+func (w *Watcher) handle(ev event, dirty map[string]bool) bool {
+	if ev.mask&syscall.IN_Q_OVERFLOW != 0 {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prefix, ok := w.byWd[ev.wd]
+	if !ok {
+		return false
+	}
+	switch {
+	case ev.mask&syscall.IN_IGNORED != 0:
+		// The watch has been removed, either explicitly or because the
+		// prefix has been deleted.
+		delete(w.byWd, ev.wd)
+		if w.byPath[prefix] == ev.wd {
+			delete(w.byPath, prefix)
+		}
+		return false
+	case ev.mask&syscall.IN_MOVE_SELF != 0:
+		// The watch follows the prefix to its new location, which will be
+		// discovered when its new parent is listed.
+		w.forgetLocked(prefix)
+	}
+	dirty[prefix] = true
+	return false
+}
+
+func (w *Watcher) watch(prefix string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.byPath[prefix]; ok {
+		return
+	}
+	if w.opts.maxWatches > 0 && len(w.byPath) >= w.opts.maxWatches {
+		w.unwatched[prefix] = true
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, prefix, watchMask)
+	if err != nil {
+		// Most likely ENOSPC, ie. the limit on watches has been reached.
+		w.unwatched[prefix] = true
+		return
+	}
+	delete(w.unwatched, prefix)
+	w.byWd[int32(wd)] = prefix
+	w.byPath[prefix] = int32(wd)
+}
+
+func (w *Watcher) known(prefix string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.byPath[prefix]
+	return ok || w.unwatched[prefix]
+}
+
+func isDescendant(parent, prefix string) bool {
+	return prefix == parent || strings.HasPrefix(prefix, strings.TrimSuffix(parent, "/")+"/")
+}
+
+// forgetLocked removes the watches for prefix and all of its descendants;
+// the caller must hold w.mu.
+func (w *Watcher) forgetLocked(prefix string) {
+	for p, wd := range w.byPath {
+		if isDescendant(prefix, p) {
+			_, _ = syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.byPath, p)
+			delete(w.byWd, wd)
+		}
+	}
+	for p := range w.unwatched {
+		if isDescendant(prefix, p) {
+			delete(w.unwatched, p)
+		}
+	}
+}
+
+func (w *Watcher) reportError(ctx context.Context, err error) {
+	if err != nil && w.opts.errors != nil {
+		w.opts.errors(ctx, err)
+	}
+}
+
+func (w *Watcher) visit(ctx context.Context, prefix string, pi *filewalk.PrefixInfo, refreshed bool) error {
+	if refreshed && w.opts.updated != nil {
+		w.opts.updated(ctx, prefix, false)
+	}
+	return nil
+}
+
+// walk walks the specified roots, watching every prefix encountered. If
+// relist is false the walk is incremental and prefixes whose modification
+// time is unchanged are not listed, otherwise every prefix is listed since
+// changes to existing files do not change the modification time of the
+// prefix that contains them.
+func (w *Watcher) walk(ctx context.Context, relist bool, roots ...string) {
+	prefixFn := func(ctx context.Context, prefix string, info *filewalk.Info, err error) (bool, []filewalk.Info, error) {
+		if err == nil {
+			w.watch(prefix)
+		}
+		if relist {
+			return err != nil, nil, err
+		}
+		return w.updater.PrefixFunc(ctx, prefix, info, err)
+	}
+	err := filewalk.New(w.fs).Walk(ctx, prefixFn, w.updater.ContentsFunc, roots...)
+	w.reportError(ctx, err)
+}
+
+func (w *Watcher) flush(ctx context.Context, dirty map[string]bool) {
+	prefixes := make([]string, 0, len(dirty))
+	for p := range dirty {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	for _, p := range prefixes {
+		w.reportError(ctx, w.refresh(ctx, p))
+	}
+}
+
+// refresh re-lists prefix and walks any new prefixes found within it.
+func (w *Watcher) refresh(ctx context.Context, prefix string) error {
+	info, err := w.fs.Stat(ctx, prefix)
+	if err != nil {
+		if w.fs.IsNotExist(err) {
+			return w.remove(ctx, prefix)
+		}
+		return err
+	}
+	w.watch(prefix)
+	ch := make(chan filewalk.Contents, 10)
+	go func() {
+		w.fs.List(ctx, prefix, ch)
+		close(ch)
+	}()
+	atomic.AddInt64(&w.refreshed, 1)
+	children, err := w.updater.ContentsFunc(ctx, prefix, &info, ch)
+	var added []string
+	for _, child := range children {
+		if !child.IsPrefix() {
+			continue
+		}
+		if p := w.fs.Join(prefix, child.Name); !w.known(p) {
+			added = append(added, p)
+		}
+	}
+	if len(added) > 0 {
+		// Any existing records for the new prefixes were not being kept
+		// up to date and hence they must be listed.
+		w.walk(ctx, true, added...)
+	}
+	return err
+}
+
+// remove deletes prefix, and all of its descendants, from the database.
+func (w *Watcher) remove(ctx context.Context, prefix string) error {
+	w.mu.Lock()
+	w.forgetLocked(prefix)
+	w.mu.Unlock()
+	atomic.AddInt64(&w.deleted, 1)
+	var pi filewalk.PrefixInfo
+	ok, err := w.db.Get(ctx, prefix, &pi)
+	if err == nil && ok {
+		_, err = w.db.Delete(ctx, "/", []string{prefix}, true)
+	}
+	if w.opts.updated != nil {
+		w.opts.updated(ctx, prefix, true)
+	}
+	return err
+}
+
+// rescan re-lists the topmost of the prefixes that could not be watched,
+// and all of their descendants.
+func (w *Watcher) rescan(ctx context.Context) {
+	w.mu.Lock()
+	var prefixes []string
+	for p := range w.unwatched {
+		prefixes = append(prefixes, p)
+	}
+	w.mu.Unlock()
+	if len(prefixes) == 0 {
+		return
+	}
+	sort.Strings(prefixes)
+	var roots []string
+	for _, p := range prefixes {
+		if len(roots) > 0 && isDescendant(roots[len(roots)-1], p) {
+			continue
+		}
+		roots = append(roots, p)
+	}
+	atomic.AddInt64(&w.rescans, 1)
+	var existing []string
+	for _, p := range roots {
+		if _, err := w.fs.Stat(ctx, p); err != nil && w.fs.IsNotExist(err) {
+			w.reportError(ctx, w.remove(ctx, p))
+			continue
+		}
+		existing = append(existing, p)
+	}
+	if len(existing) > 0 {
+		w.walk(ctx, true, existing...)
+	}
+}
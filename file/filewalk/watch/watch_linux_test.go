@@ -0,0 +1,192 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package watch_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/localdb"
+	"cloudeng.io/file/filewalk/watch"
+)
+
+func writeFile(t *testing.T, name, contents string) {
+	if err := os.WriteFile(name, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mkdir(t *testing.T, name string) {
+	if err := os.MkdirAll(name, 0700); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func files(ctx context.Context, db filewalk.Database, prefix string) ([]string, bool) {
+	var pi filewalk.PrefixInfo
+	ok, err := db.Get(ctx, prefix, &pi)
+	if err != nil || !ok {
+		return nil, false
+	}
+	var names []string
+	for _, f := range pi.Files {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+func size(ctx context.Context, db filewalk.Database, prefix, name string) int64 {
+	var pi filewalk.PrefixInfo
+	if ok, err := db.Get(ctx, prefix, &pi); err != nil || !ok {
+		return -1
+	}
+	for _, f := range pi.Files {
+		if f.Name == name {
+			return f.Size
+		}
+	}
+	return -1
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, _, line, _ := runtime.Caller(1)
+	t.Fatalf("line %v: timed out", line)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func run(ctx context.Context, t *testing.T, root string, opts ...watch.Option) (filewalk.Database, *watch.Watcher, func()) {
+	db, err := localdb.Open(ctx, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := watch.New(filewalk.LocalFilesystem(10), db, opts...)
+	ctx, cancel := context.WithCancel(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.Run(ctx, root)
+	}()
+	return db, w, func() {
+		cancel()
+		if err := <-errCh; !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		db.Close(context.Background())
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	mkdir(t, filepath.Join(root, "a"))
+	mkdir(t, filepath.Join(root, "b", "c"))
+	writeFile(t, filepath.Join(root, "a", "f1"), "1")
+
+	db, w, stop := run(ctx, t, root, watch.Latency(10*time.Millisecond))
+	defer stop()
+
+	a, b, c := filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "b", "c")
+	waitFor(t, func() bool {
+		names, _ := files(ctx, db, a)
+		_, ok := files(ctx, db, c)
+		return equal(names, []string{"f1"}) && ok && w.Stats().Watched == 4
+	})
+
+	// New files are noticed.
+	writeFile(t, filepath.Join(a, "f2"), "2")
+	waitFor(t, func() bool {
+		names, _ := files(ctx, db, a)
+		return equal(names, []string{"f1", "f2"})
+	})
+
+	// As are new prefixes and their contents.
+	d := filepath.Join(root, "d")
+	mkdir(t, filepath.Join(d, "e"))
+	writeFile(t, filepath.Join(d, "e", "f3"), "3")
+	waitFor(t, func() bool {
+		names, _ := files(ctx, db, filepath.Join(d, "e"))
+		return equal(names, []string{"f3"})
+	})
+	writeFile(t, filepath.Join(d, "e", "f4"), "4")
+	waitFor(t, func() bool {
+		names, _ := files(ctx, db, filepath.Join(d, "e"))
+		return equal(names, []string{"f3", "f4"})
+	})
+
+	// Removed prefixes are deleted.
+	if err := os.RemoveAll(b); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, bok := files(ctx, db, b)
+		_, cok := files(ctx, db, c)
+		return !bok && !cok
+	})
+
+	if stats := w.Stats(); stats.Events == 0 || stats.Refreshed == 0 || stats.Deleted == 0 || stats.Unwatched != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestRescan(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	mkdir(t, filepath.Join(a, "b"))
+
+	// Only root can be watched and hence changes to a and b are found by
+	// rescanning them.
+	db, w, stop := run(ctx, t, root,
+		watch.Latency(10*time.Millisecond),
+		watch.MaxWatches(1),
+		watch.RescanInterval(20*time.Millisecond))
+	defer stop()
+
+	waitFor(t, func() bool {
+		stats := w.Stats()
+		return stats.Watched == 1 && stats.Unwatched == 2
+	})
+	writeFile(t, filepath.Join(a, "b", "f1"), "1")
+	waitFor(t, func() bool {
+		names, _ := files(ctx, db, filepath.Join(a, "b"))
+		return equal(names, []string{"f1"})
+	})
+	// Changes to existing files do not change the modification time of
+	// the prefix containing them but must still be found.
+	writeFile(t, filepath.Join(a, "b", "f1"), "12345")
+	waitFor(t, func() bool {
+		return size(ctx, db, filepath.Join(a, "b"), "f1") == 5
+	})
+	if stats := w.Stats(); stats.Rescans == 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package watch
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// Watcher is not supported on this system.
+type Watcher struct{}
+
+// New returns a Watcher whose Run method always returns an error.
+func New(fs filewalk.Filesystem, db filewalk.Database, opts ...Option) *Watcher {
+	return &Watcher{}
+}
+
+// Stats returns an empty Stats.
+func (w *Watcher) Stats() Stats {
+	return Stats{}
+}
+
+// Run always returns an error.
+func (w *Watcher) Run(ctx context.Context, roots ...string) error {
+	return fmt.Errorf("watch: not supported on %v", runtime.GOOS)
+}
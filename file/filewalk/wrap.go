@@ -0,0 +1,51 @@
+// Copyright 2020 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package filewalk
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"cloudeng.io/errors"
+)
+
+// ErrNotSupported is wrapped by the error returned by Open for a Filesystem
+// that does not implement Reader.
+var ErrNotSupported = errors.New("not supported")
+
+// The following functions are intended for use by Filesystems that wrap
+// another Filesystem in order to forward the optional Reader and
+// LinkFollower methods to it. Such Filesystems always provide these
+// methods, but they behave as if the wrapped Filesystem had been used
+// directly.
+
+// Open calls fs.Open if fs implements Reader and returns an error wrapping
+// ErrNotSupported otherwise.
+func Open(ctx context.Context, fs Filesystem, path string) (io.ReadCloser, error) {
+	if rd, ok := fs.(Reader); ok {
+		return rd.Open(ctx, path)
+	}
+	return nil, fmt.Errorf("open %v: %w", path, ErrNotSupported)
+}
+
+// StatFollow calls fs.StatFollow if fs implements LinkFollower and
+// fs.Stat otherwise, since a Filesystem that cannot follow links has no
+// links to follow.
+func StatFollow(ctx context.Context, fs Filesystem, path string) (Info, error) {
+	if lf, ok := fs.(LinkFollower); ok {
+		return lf.StatFollow(ctx, path)
+	}
+	return fs.Stat(ctx, path)
+}
+
+// FileID calls fs.FileID if fs implements LinkFollower and returns false
+// otherwise.
+func FileID(fs Filesystem, info Info) (device, inode uint64, ok bool) {
+	if lf, ok := fs.(LinkFollower); ok {
+		return lf.FileID(info)
+	}
+	return 0, 0, false
+}
@@ -1,11 +1,12 @@
 module cloudeng.io/file
 
-go 1.15
+go 1.16
 
 require (
 	cloudeng.io/algo v0.0.0-20201019005056-d61ea7d0acd4
 	cloudeng.io/errors v0.0.6
 	cloudeng.io/os v0.0.0-20201019005056-d61ea7d0acd4
+	cloudeng.io/path v0.0.0-20201019005056-d61ea7d0acd4
 	cloudeng.io/sync v0.0.5
 	github.com/cosnicolaou/pudge v1.0.4
 )